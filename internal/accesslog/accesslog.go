@@ -0,0 +1,268 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/humanbelnik/load-balancer/internal/accesslog/config"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown access log format")
+)
+
+/*
+Rate limiter decision for the request.
+*/
+const (
+	RateLimitNone   = ""
+	RateLimitAllow  = "allow"
+	RateLimitDeny   = "deny"
+	RateLimitShadow = "shadow_deny"
+)
+
+/*
+Record describes one finished request.
+*/
+type Record struct {
+	Time      time.Time
+	RequestID string
+	ClientIP  string
+	Method    string
+	Host      string
+	Path      string
+	// Path with query, as sent by client.
+	URI       string
+	Proto     string
+	Status    int
+	BytesIn   int64
+	BytesOut  int64
+	Duration  time.Duration
+	Upstream  time.Duration
+	Backend   string
+	Attempts  int
+	RateLimit string
+	Referer   string
+	UserAgent string
+}
+
+type formatter func(buf []byte, rec Record) []byte
+
+/*
+Logger writes one line per request in configured format.
+*/
+type Logger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+	format formatter
+
+	sampleRate      float64
+	alwaysLogErrors bool
+}
+
+func New(cfg config.AccessLogConfig) (*Logger, error) {
+	l := &Logger{
+		sampleRate:      cfg.SampleRate,
+		alwaysLogErrors: cfg.AlwaysLogErrors,
+	}
+
+	switch cfg.Format {
+	case config.FormatJSON:
+		l.format = formatJSON
+	case config.FormatLogfmt:
+		l.format = formatLogfmt
+	case config.FormatCombined:
+		l.format = formatCombined
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, cfg.Format)
+	}
+
+	switch cfg.Output {
+	case "stdout":
+		l.w = os.Stdout
+	case "stderr":
+		l.w = os.Stderr
+	default:
+		rotating := &lumberjack.Logger{
+			Filename:   cfg.Output,
+			MaxSize:    cfg.MaxSizeMB,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAgeDays,
+			Compress:   cfg.Compress,
+		}
+		l.w, l.closer = rotating, rotating
+	}
+	return l, nil
+}
+
+/*
+For tests and custom sinks.
+*/
+func NewWriter(w io.Writer, format string) (*Logger, error) {
+	l, err := New(config.AccessLogConfig{Format: format, Output: "stdout", SampleRate: 1})
+	if err != nil {
+		return nil, err
+	}
+	l.w = w
+	return l, nil
+}
+
+func (l *Logger) Log(rec Record) {
+	if !l.sampled(rec) {
+		return
+	}
+
+	line := l.format(make([]byte, 0, 256), rec)
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.w.Write(line)
+}
+
+func (l *Logger) sampled(rec Record) bool {
+	if l.sampleRate >= 1 {
+		return true
+	}
+	if l.alwaysLogErrors && rec.Status >= 500 {
+		return true
+	}
+	return rand.Float64() < l.sampleRate
+}
+
+func (l *Logger) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
+
+type jsonRecord struct {
+	Time       string  `json:"time"`
+	RequestID  string  `json:"request_id,omitempty"`
+	ClientIP   string  `json:"client_ip"`
+	Method     string  `json:"method"`
+	Host       string  `json:"host"`
+	Path       string  `json:"path"`
+	Proto      string  `json:"proto"`
+	Status     int     `json:"status"`
+	BytesIn    int64   `json:"bytes_in"`
+	BytesOut   int64   `json:"bytes_out"`
+	DurationMs float64 `json:"duration_ms"`
+	UpstreamMs float64 `json:"upstream_ms"`
+	Backend    string  `json:"backend,omitempty"`
+	Attempts   int     `json:"attempts"`
+	RateLimit  string  `json:"rate_limit,omitempty"`
+	Referer    string  `json:"referer,omitempty"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+}
+
+func formatJSON(buf []byte, rec Record) []byte {
+	b, _ := json.Marshal(jsonRecord{
+		Time:       rec.Time.Format(time.RFC3339Nano),
+		RequestID:  rec.RequestID,
+		ClientIP:   rec.ClientIP,
+		Method:     rec.Method,
+		Host:       rec.Host,
+		Path:       rec.Path,
+		Proto:      rec.Proto,
+		Status:     rec.Status,
+		BytesIn:    rec.BytesIn,
+		BytesOut:   rec.BytesOut,
+		DurationMs: millis(rec.Duration),
+		UpstreamMs: millis(rec.Upstream),
+		Backend:    rec.Backend,
+		Attempts:   rec.Attempts,
+		RateLimit:  rec.RateLimit,
+		Referer:    rec.Referer,
+		UserAgent:  rec.UserAgent,
+	})
+	return append(buf, b...)
+}
+
+func formatLogfmt(buf []byte, rec Record) []byte {
+	pairs := []struct{ k, v string }{
+		{"time", rec.Time.Format(time.RFC3339Nano)},
+		{"request_id", rec.RequestID},
+		{"client_ip", rec.ClientIP},
+		{"method", rec.Method},
+		{"host", rec.Host},
+		{"path", rec.Path},
+		{"proto", rec.Proto},
+		{"status", strconv.Itoa(rec.Status)},
+		{"bytes_in", strconv.FormatInt(rec.BytesIn, 10)},
+		{"bytes_out", strconv.FormatInt(rec.BytesOut, 10)},
+		{"duration_ms", strconv.FormatFloat(millis(rec.Duration), 'f', 3, 64)},
+		{"upstream_ms", strconv.FormatFloat(millis(rec.Upstream), 'f', 3, 64)},
+		{"backend", rec.Backend},
+		{"attempts", strconv.Itoa(rec.Attempts)},
+		{"rate_limit", rec.RateLimit},
+	}
+	for i, p := range pairs {
+		if i > 0 {
+			buf = append(buf, ' ')
+		}
+		buf = append(buf, p.k...)
+		buf = append(buf, '=')
+		buf = appendLogfmtValue(buf, p.v)
+	}
+	return buf
+}
+
+func appendLogfmtValue(buf []byte, v string) []byte {
+	if v == "" || strings.ContainsAny(v, " =\"\t\n") {
+		return strconv.AppendQuote(buf, v)
+	}
+	return append(buf, v...)
+}
+
+/*
+Apache combined log format:
+%h - - [%t] "%r" %>s %b "%{Referer}i" "%{User-agent}i"
+*/
+func formatCombined(buf []byte, rec Record) []byte {
+	buf = append(buf, dash(rec.ClientIP)...)
+	buf = append(buf, " - - ["...)
+	buf = rec.Time.AppendFormat(buf, "02/Jan/2006:15:04:05 -0700")
+	buf = append(buf, "] \""...)
+	buf = append(buf, rec.Method...)
+	buf = append(buf, ' ')
+	buf = append(buf, rec.URI...)
+	buf = append(buf, ' ')
+	buf = append(buf, rec.Proto...)
+	buf = append(buf, "\" "...)
+	buf = strconv.AppendInt(buf, int64(rec.Status), 10)
+	buf = append(buf, ' ')
+	if rec.BytesOut == 0 {
+		buf = append(buf, '-')
+	} else {
+		buf = strconv.AppendInt(buf, rec.BytesOut, 10)
+	}
+	buf = append(buf, ' ')
+	buf = strconv.AppendQuote(buf, dash(rec.Referer))
+	buf = append(buf, ' ')
+	buf = strconv.AppendQuote(buf, dash(rec.UserAgent))
+	return buf
+}
+
+func dash(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
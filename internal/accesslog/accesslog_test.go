@@ -0,0 +1,89 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/accesslog/config"
+)
+
+func testRecord() Record {
+	return Record{
+		Time:      time.Date(2025, 3, 1, 12, 30, 0, 0, time.UTC),
+		RequestID: "req-1",
+		ClientIP:  "10.0.0.1",
+		Method:    "GET",
+		Host:      "example.com",
+		Path:      "/items",
+		URI:       "/items?page=2",
+		Proto:     "HTTP/1.1",
+		Status:    200,
+		BytesIn:   0,
+		BytesOut:  512,
+		Duration:  15 * time.Millisecond,
+		Upstream:  12 * time.Millisecond,
+		Backend:   "http://localhost:9001",
+		Attempts:  1,
+		RateLimit: RateLimitAllow,
+		UserAgent: "curl/8.0",
+	}
+}
+
+func TestLogger_JSON(t *testing.T) {
+	out := &bytes.Buffer{}
+	l, err := NewWriter(out, config.FormatJSON)
+	require.NoError(t, err)
+
+	l.Log(testRecord())
+
+	var got map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &got))
+	require.Equal(t, "req-1", got["request_id"])
+	require.Equal(t, "http://localhost:9001", got["backend"])
+	require.EqualValues(t, 512, got["bytes_out"])
+	require.EqualValues(t, 15, got["duration_ms"])
+	require.Equal(t, "allow", got["rate_limit"])
+}
+
+func TestLogger_Logfmt(t *testing.T) {
+	out := &bytes.Buffer{}
+	l, err := NewWriter(out, config.FormatLogfmt)
+	require.NoError(t, err)
+
+	rec := testRecord()
+	rec.Path = "/with space"
+	l.Log(rec)
+
+	require.Contains(t, out.String(), `status=200 `)
+	require.Contains(t, out.String(), `path="/with space" `)
+	require.Contains(t, out.String(), `upstream_ms=12.000 `)
+}
+
+func TestLogger_Combined(t *testing.T) {
+	out := &bytes.Buffer{}
+	l, err := NewWriter(out, config.FormatCombined)
+	require.NoError(t, err)
+
+	l.Log(testRecord())
+
+	require.Equal(t,
+		`10.0.0.1 - - [01/Mar/2025:12:30:00 +0000] "GET /items?page=2 HTTP/1.1" 200 512 "-" "curl/8.0"`+"\n",
+		out.String())
+}
+
+func TestLogger_Sampling(t *testing.T) {
+	out := &bytes.Buffer{}
+	l, err := NewWriter(out, config.FormatLogfmt)
+	require.NoError(t, err)
+	l.sampleRate = 0.0000001
+	l.alwaysLogErrors = true
+
+	rec := testRecord()
+	rec.Status = 502
+	l.Log(rec)
+	require.NotZero(t, out.Len())
+}
@@ -0,0 +1,26 @@
+package config
+
+const (
+	FormatJSON     = "json"
+	FormatLogfmt   = "logfmt"
+	FormatCombined = "combined"
+)
+
+type AccessLogConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 'json', 'logfmt' or 'combined' (Apache).
+	Format string `yaml:"format" env-default:"json"`
+	// 'stdout', 'stderr' or file path.
+	Output string `yaml:"output" env-default:"stdout"`
+
+	// Rotation, used only for file output.
+	MaxSizeMB  int  `yaml:"max_size_mb" env-default:"100"`
+	MaxBackups int  `yaml:"max_backups" env-default:"5"`
+	MaxAgeDays int  `yaml:"max_age_days"`
+	Compress   bool `yaml:"compress"`
+
+	// Fraction of requests to log, in (0, 1].
+	SampleRate float64 `yaml:"sample_rate" env-default:"1"`
+	// Log every 5xx regardless of sampling.
+	AlwaysLogErrors bool `yaml:"always_log_errors"`
+}
@@ -0,0 +1,267 @@
+package admin
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/humanbelnik/load-balancer/internal/admin/audit"
+	"github.com/humanbelnik/load-balancer/internal/admin/auth"
+	"github.com/humanbelnik/load-balancer/internal/admin/config"
+)
+
+var (
+	ErrNoAuth     = errors.New("no admin authentication configured")
+	ErrLoadTokens = errors.New("unable to load admin tokens")
+	ErrTLS        = errors.New("unable to configure admin TLS")
+)
+
+/*
+Server is a separate listener for the management API.
+Every route requires an authenticated principal with sufficient role,
+mutating calls are written to the audit log.
+*/
+type Server struct {
+	mux    *http.ServeMux
+	auth   auth.Authenticator
+	audit  *audit.Logger
+	logger *slog.Logger
+	srv    *http.Server
+
+	// Cancelled on shutdown, ends long-lived streams.
+	base   context.Context
+	cancel context.CancelFunc
+}
+
+type Option func(*Server)
+
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+func WithAuthenticator(a auth.Authenticator) Option {
+	return func(s *Server) {
+		s.auth = a
+	}
+}
+
+func WithAuditLogger(l *audit.Logger) Option {
+	return func(s *Server) {
+		s.audit = l
+	}
+}
+
+func New(cfg config.AdminConfig, opts ...Option) (*Server, error) {
+	s := &Server{
+		mux:    http.NewServeMux(),
+		logger: slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	tlsCfg, err := setupTLS(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.auth == nil {
+		s.auth, err = setupAuth(cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.audit == nil {
+		s.audit, err = audit.Open(cfg.AuditLog)
+		if err != nil {
+			return nil, fmt.Errorf("open audit log: %w", err)
+		}
+	}
+
+	s.base, s.cancel = context.WithCancel(context.Background())
+	s.srv = &http.Server{
+		Addr:        cfg.Addr,
+		Handler:     s.mux,
+		TLSConfig:   tlsCfg,
+		BaseContext: func(net.Listener) context.Context { return s.base },
+	}
+	return s, nil
+}
+
+func setupAuth(cfg config.AdminConfig) (auth.Authenticator, error) {
+	chain := auth.Chain{}
+
+	tokens := auth.NewTokenAuthenticator()
+	if cfg.TokensFile != "" {
+		if err := tokens.LoadFile(cfg.TokensFile); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrLoadTokens, err)
+		}
+	}
+	if cfg.TokensEnv != "" {
+		if err := tokens.LoadEnv(cfg.TokensEnv); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrLoadTokens, err)
+		}
+	}
+	if tokens.Len() > 0 {
+		chain = append(chain, tokens)
+	}
+
+	if cfg.TLS.ClientCAFile != "" && len(cfg.CertRoles) > 0 {
+		certs, err := auth.NewCertAuthenticator(cfg.CertRoles)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, certs)
+	}
+
+	/*
+		Refuse to start unprotected admin API.
+	*/
+	if len(chain) == 0 {
+		return nil, ErrNoAuth
+	}
+	return chain, nil
+}
+
+func setupTLS(cfg config.AdminConfig) (*tls.Config, error) {
+	if cfg.TLS.CertFile == "" || cfg.TLS.KeyFile == "" {
+		// Client certificates can't be presented over plain HTTP, certificate roles would lock everyone out.
+		if cfg.TLS.ClientCAFile != "" {
+			return nil, fmt.Errorf("%w: client_ca_file requires cert_file and key_file", ErrTLS)
+		}
+		if cfg.TLS.CertFile != "" || cfg.TLS.KeyFile != "" {
+			return nil, fmt.Errorf("%w: cert_file and key_file must be set together", ErrTLS)
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTLS, err)
+	}
+	tlsCfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if cfg.TLS.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLS.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrTLS, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: no certificates in %s", ErrTLS, cfg.TLS.ClientCAFile)
+		}
+		tlsCfg.ClientCAs = pool
+		// Tokens are still accepted, so certificate is verified only if presented.
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsCfg, nil
+}
+
+/*
+Registers handler under Go 1.22 mux pattern, eg. 'POST /clients'.
+*/
+func (s *Server) Handle(pattern string, role auth.Role, h http.HandlerFunc) {
+	s.mux.Handle(pattern, s.protect(role, h))
+}
+
+/*
+Registers handler available without authentication.
+Only for static content, never for data or actions.
+*/
+func (s *Server) HandlePublic(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, h)
+}
+
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+func (s *Server) protect(required auth.Role, h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := s.auth.Authenticate(r)
+		if err != nil {
+			s.logger.Warn("admin authentication failed", slog.String("remote", r.RemoteAddr), slog.String("path", r.URL.Path))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !p.Role.Allows(required) {
+			s.logger.Warn("admin access denied", slog.String("principal", p.Name), slog.String("path", r.URL.Path))
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		if !isMutating(r.Method) {
+			h(w, r)
+			return
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r)
+		s.audit.Log(audit.Record{
+			Principal: p.Name,
+			Role:      p.Role.String(),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Remote:    r.RemoteAddr,
+			Status:    rec.status,
+			Duration:  time.Since(start),
+		})
+	})
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
+
+func (s *Server) Addr() string {
+	return s.srv.Addr
+}
+
+func (s *Server) ListenAndServe() error {
+	if s.srv.TLSConfig != nil {
+		return s.srv.ListenAndServeTLS("", "")
+	}
+	return s.srv.ListenAndServe()
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.cancel()
+	err := s.srv.Shutdown(ctx)
+	if cerr := s.audit.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,79 @@
+package admin
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/admin/audit"
+	"github.com/humanbelnik/load-balancer/internal/admin/auth"
+	"github.com/humanbelnik/load-balancer/internal/admin/config"
+)
+
+func newTestServer(t *testing.T, out *bytes.Buffer) *Server {
+	t.Helper()
+	tokens := auth.NewTokenAuthenticator()
+	require.NoError(t, tokens.Add("read:reader"))
+	require.NoError(t, tokens.Add("write:writer"))
+
+	s, err := New(config.AdminConfig{Addr: "localhost:0"},
+		WithAuthenticator(tokens),
+		WithAuditLogger(audit.New(out)),
+	)
+	require.NoError(t, err)
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	s.Handle("GET /clients", auth.RoleRead, ok)
+	s.Handle("POST /clients", auth.RoleWrite, ok)
+	return s
+}
+
+func do(s *Server, method, token string) int {
+	req := httptest.NewRequest(method, "/clients", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestAdmin_Unauthenticated(t *testing.T) {
+	s := newTestServer(t, &bytes.Buffer{})
+
+	require.Equal(t, http.StatusUnauthorized, do(s, http.MethodGet, ""))
+	require.Equal(t, http.StatusUnauthorized, do(s, http.MethodGet, "unknown"))
+}
+
+func TestAdmin_Roles(t *testing.T) {
+	out := &bytes.Buffer{}
+	s := newTestServer(t, out)
+
+	require.Equal(t, http.StatusNoContent, do(s, http.MethodGet, "reader"))
+	require.Equal(t, http.StatusForbidden, do(s, http.MethodPost, "reader"))
+	require.Zero(t, out.Len())
+
+	require.Equal(t, http.StatusNoContent, do(s, http.MethodGet, "writer"))
+	require.Zero(t, out.Len())
+
+	require.Equal(t, http.StatusNoContent, do(s, http.MethodPost, "writer"))
+	require.Contains(t, out.String(), `"method":"POST"`)
+	require.Contains(t, out.String(), `"status":204`)
+}
+
+func TestAdmin_NoAuthRefused(t *testing.T) {
+	_, err := New(config.AdminConfig{Addr: "localhost:0", AuditLog: t.TempDir() + "/audit.log"})
+	require.ErrorIs(t, err, ErrNoAuth)
+}
+
+func TestAdmin_ClientCAWithoutTLS(t *testing.T) {
+	cfg := config.AdminConfig{Addr: "localhost:0", AuditLog: t.TempDir() + "/audit.log"}
+	cfg.TLS.ClientCAFile = "ca.crt"
+	cfg.CertRoles = map[string]string{"ops": "write"}
+
+	_, err := New(cfg)
+	require.ErrorIs(t, err, ErrTLS)
+}
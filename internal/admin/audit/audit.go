@@ -0,0 +1,61 @@
+package audit
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+type Record struct {
+	Principal string
+	Role      string
+	Method    string
+	Path      string
+	Remote    string
+	Status    int
+	Duration  time.Duration
+}
+
+/*
+Logger writes one JSON line per mutating admin call.
+*/
+type Logger struct {
+	logger *slog.Logger
+	closer io.Closer
+}
+
+func New(w io.Writer) *Logger {
+	return &Logger{
+		logger: slog.New(slog.NewJSONHandler(w, nil)),
+	}
+}
+
+func Open(path string) (*Logger, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	l := New(f)
+	l.closer = f
+	return l, nil
+}
+
+func (l *Logger) Log(rec Record) {
+	l.logger.Info("admin call",
+		slog.String("principal", rec.Principal),
+		slog.String("role", rec.Role),
+		slog.String("method", rec.Method),
+		slog.String("path", rec.Path),
+		slog.String("remote", rec.Remote),
+		slog.Int("status", rec.Status),
+		slog.Duration("duration", rec.Duration),
+	)
+}
+
+func (l *Logger) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
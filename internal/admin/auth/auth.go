@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var (
+	ErrUnauthenticated = errors.New("unauthenticated")
+	ErrBadToken        = errors.New("malformed token entry")
+	ErrUnknownRole     = errors.New("unknown role")
+)
+
+/*
+Role of an admin API caller.
+Write role implies read.
+*/
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleRead
+	RoleWrite
+)
+
+func ParseRole(raw string) (Role, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "read", "ro", "read-only":
+		return RoleRead, nil
+	case "write", "rw", "read-write":
+		return RoleWrite, nil
+	default:
+		return RoleNone, fmt.Errorf("%w: %q", ErrUnknownRole, raw)
+	}
+}
+
+func (r Role) String() string {
+	switch r {
+	case RoleRead:
+		return "read"
+	case RoleWrite:
+		return "write"
+	default:
+		return "none"
+	}
+}
+
+func (r Role) Allows(required Role) bool {
+	return r >= required
+}
+
+/*
+Principal is an authenticated admin API caller.
+*/
+type Principal struct {
+	Name string
+	Role Role
+}
+
+type Authenticator interface {
+	Authenticate(r *http.Request) (Principal, error)
+}
+
+/*
+TokenAuthenticator checks 'Authorization: Bearer <token>' header.
+Tokens are kept hashed, so lookups do not leak timing of the raw value.
+*/
+type TokenAuthenticator struct {
+	tokens map[[sha256.Size]byte]Role
+}
+
+func NewTokenAuthenticator() *TokenAuthenticator {
+	return &TokenAuthenticator{
+		tokens: make(map[[sha256.Size]byte]Role),
+	}
+}
+
+/*
+Entry format is '<role>:<token>'.
+*/
+func (a *TokenAuthenticator) Add(entry string) error {
+	roleRaw, token, ok := strings.Cut(strings.TrimSpace(entry), ":")
+	if !ok || token == "" {
+		return ErrBadToken
+	}
+	role, err := ParseRole(roleRaw)
+	if err != nil {
+		return err
+	}
+	a.tokens[sha256.Sum256([]byte(token))] = role
+	return nil
+}
+
+/*
+Loads tokens from file, one entry per line.
+Empty lines and lines starting with '#' are skipped.
+*/
+func (a *TokenAuthenticator) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := a.Add(text); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+/*
+Loads comma separated tokens from environment variable.
+Missing variable is not an error.
+*/
+func (a *TokenAuthenticator) LoadEnv(name string) error {
+	raw, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	for _, entry := range strings.Split(raw, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		if err := a.Add(entry); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (a *TokenAuthenticator) Len() int {
+	return len(a.tokens)
+}
+
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	header := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || token == "" {
+		return Principal{}, ErrUnauthenticated
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	for known, role := range a.tokens {
+		if subtle.ConstantTimeCompare(known[:], sum[:]) == 1 {
+			// Do not log the token itself, only a short fingerprint.
+			return Principal{Name: fmt.Sprintf("token:%x", sum[:4]), Role: role}, nil
+		}
+	}
+	return Principal{}, ErrUnauthenticated
+}
+
+/*
+CertAuthenticator maps verified client certificates to roles by Common Name.
+Verification itself is done by TLS listener. It uses tls.VerifyClientCertIfGiven,
+so token clients may connect without certificate: requests without verified chain
+must be rejected here, presented but unverified certificates included.
+*/
+type CertAuthenticator struct {
+	roles map[string]Role
+}
+
+func NewCertAuthenticator(roles map[string]string) (*CertAuthenticator, error) {
+	a := &CertAuthenticator{roles: make(map[string]Role, len(roles))}
+	for cn, raw := range roles {
+		role, err := ParseRole(raw)
+		if err != nil {
+			return nil, fmt.Errorf("cert %q: %w", cn, err)
+		}
+		a.roles[cn] = role
+	}
+	return a, nil
+}
+
+func (a *CertAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return Principal{}, ErrUnauthenticated
+	}
+	cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	role, ok := a.roles[cn]
+	if !ok {
+		return Principal{}, ErrUnauthenticated
+	}
+	return Principal{Name: "cert:" + cn, Role: role}, nil
+}
+
+/*
+Chain tries authenticators in order, first success wins.
+*/
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (Principal, error) {
+	for _, a := range c {
+		if p, err := a.Authenticate(r); err == nil {
+			return p, nil
+		}
+	}
+	return Principal{}, ErrUnauthenticated
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertAuthenticator(t *testing.T) {
+	a, err := NewCertAuthenticator(map[string]string{"ops": "write"})
+	require.NoError(t, err)
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "ops"}}
+
+	// Plain HTTP and TLS without client certificate.
+	r := httptest.NewRequest("GET", "/clients", nil)
+	_, err = a.Authenticate(r)
+	require.ErrorIs(t, err, ErrUnauthenticated)
+	r.TLS = &tls.ConnectionState{}
+	_, err = a.Authenticate(r)
+	require.ErrorIs(t, err, ErrUnauthenticated)
+
+	// Presented, but not verified by listener.
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	_, err = a.Authenticate(r)
+	require.ErrorIs(t, err, ErrUnauthenticated)
+
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+	p, err := a.Authenticate(r)
+	require.NoError(t, err)
+	require.Equal(t, Principal{Name: "cert:ops", Role: RoleWrite}, p)
+
+	unknown := &x509.Certificate{Subject: pkix.Name{CommonName: "intruder"}}
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{unknown}}}
+	_, err = a.Authenticate(r)
+	require.ErrorIs(t, err, ErrUnauthenticated)
+}
@@ -0,0 +1,22 @@
+package config
+
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+type AdminConfig struct {
+	Addr string `yaml:"addr" env-default:"localhost:9090"`
+
+	// Bearer tokens in '<role>:<token>' format, one per line.
+	TokensFile string `yaml:"tokens_file"`
+	// Same format, comma separated.
+	TokensEnv string `yaml:"tokens_env" env-default:"LB_ADMIN_TOKENS"`
+
+	TLS TLSConfig `yaml:"tls"`
+	// Client certificate Common Name -> role. Used only with client_ca_file set.
+	CertRoles map[string]string `yaml:"cert_roles"`
+
+	AuditLog string `yaml:"audit_log" env-default:"admin_audit.log"`
+}
@@ -0,0 +1,185 @@
+package dashboard
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+	"github.com/humanbelnik/load-balancer/internal/events"
+)
+
+// Events scanned for the recent errors list.
+const recentScan = 256
+
+// Errors shown on the page.
+const recentErrors = 20
+
+//go:embed static
+var static embed.FS
+
+type Events interface {
+	Recent(n int, types ...events.Type) []events.Event
+}
+
+/*
+Dashboard serves status page, which polls JSON admin endpoints.
+Tracks configuration version and observes pools of every upstream group to count servers.
+*/
+type Dashboard struct {
+	events  Events
+	started time.Time
+	logger  *slog.Logger
+
+	mu         sync.Mutex
+	version    uint64
+	reloadedAt time.Time
+	// Pool size by upstream group.
+	sizes     map[string]int
+	reloadErr string
+}
+
+type Option func(*Dashboard)
+
+func WithLogger(logger *slog.Logger) Option {
+	return func(d *Dashboard) {
+		d.logger = logger
+	}
+}
+
+func New(events Events, opts ...Option) *Dashboard {
+	d := &Dashboard{
+		events:  events,
+		started: time.Now(),
+		logger:  slog.Default(),
+		sizes:   make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+/*
+Called once per load, initial one included. Successful load bumps configuration version.
+*/
+func (d *Dashboard) ConfigReloaded(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil {
+		d.reloadErr = err.Error()
+		return
+	}
+	d.version++
+	d.reloadedAt = time.Now()
+	d.reloadErr = ""
+}
+
+/*
+Observer of pool of an upstream group, one per group.
+*/
+func (d *Dashboard) PoolObserver(upstream string) *PoolObserver {
+	return &PoolObserver{d: d, upstream: upstream}
+}
+
+type PoolObserver struct {
+	d        *Dashboard
+	upstream string
+}
+
+func (o *PoolObserver) PoolUpdated(size int, err error) {
+	o.d.mu.Lock()
+	defer o.d.mu.Unlock()
+	o.d.sizes[o.upstream] = size
+}
+
+/*
+Static page, it asks for a token itself.
+*/
+func (d *Dashboard) Page() http.Handler {
+	sub, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServerFS(sub)
+}
+
+type ConfigResponse struct {
+	Version    uint64    `json:"version"`
+	ReloadedAt time.Time `json:"reloaded_at"`
+	Servers    int       `json:"servers"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+type StatusResponse struct {
+	StartedAt    time.Time      `json:"started_at"`
+	Uptime       float64        `json:"uptime_seconds"`
+	Config       ConfigResponse `json:"config"`
+	RecentErrors []events.Event `json:"recent_errors"`
+}
+
+func (d *Dashboard) Status(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	cfg := ConfigResponse{
+		Version:    d.version,
+		ReloadedAt: d.reloadedAt,
+		LastError:  d.reloadErr,
+	}
+	for _, size := range d.sizes {
+		cfg.Servers += size
+	}
+	d.mu.Unlock()
+
+	resp := StatusResponse{
+		StartedAt:    d.started,
+		Uptime:       time.Since(d.started).Seconds(),
+		Config:       cfg,
+		RecentErrors: d.recentErrors(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		d.logger.Error("encode status", slog.Any("err", err))
+	}
+}
+
+func (d *Dashboard) recentErrors() []events.Event {
+	result := make([]events.Event, 0, recentErrors)
+	if d.events == nil {
+		return result
+	}
+
+	recent := d.events.Recent(recentScan, events.BreakerTripped, events.ServerState, events.ConfigReload, events.PoolUpdated)
+	for _, e := range recent {
+		switch e.Type {
+		case events.ServerState:
+			if e.Data["to"] != server.StateEjected || trippedBefore(result, e) {
+				continue
+			}
+		case events.ConfigReload, events.PoolUpdated:
+			if e.Data["result"] != "failure" {
+				continue
+			}
+		}
+		result = append(result, e)
+	}
+	if len(result) > recentErrors {
+		result = result[len(result)-recentErrors:]
+	}
+	return result
+}
+
+/*
+Ejection after failed request is already reported as breaker trip.
+*/
+func trippedBefore(result []events.Event, e events.Event) bool {
+	if len(result) == 0 {
+		return false
+	}
+	last := result[len(result)-1]
+	return last.Type == events.BreakerTripped && last.Data["url"] == e.Data["url"]
+}
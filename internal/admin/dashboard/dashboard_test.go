@@ -0,0 +1,64 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/events"
+)
+
+func TestDashboard_Status(t *testing.T) {
+	bus := events.NewBus()
+	d := New(bus)
+
+	// Startup with two groups.
+	d.PoolObserver("api").PoolUpdated(3, nil)
+	d.PoolObserver("web").PoolUpdated(2, nil)
+	d.ConfigReloaded(nil)
+	d.ConfigReloaded(errors.New("broken URL"))
+	bus.ServerAdded("http://a")
+	bus.BreakerTripped("http://a", errors.New("502"))
+	bus.ServerStateChanged("http://a", "alive", "ejected")
+	bus.ServerStateChanged("http://a", "ejected", "alive")
+
+	rr := httptest.NewRecorder()
+	d.Status(rr, httptest.NewRequest(http.MethodGet, "/status", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var resp StatusResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	require.Equal(t, uint64(1), resp.Config.Version)
+	require.Equal(t, 5, resp.Config.Servers)
+	require.Equal(t, "broken URL", resp.Config.LastError)
+
+	// Ejection right after trip is not repeated.
+	require.Len(t, resp.RecentErrors, 1)
+	require.Equal(t, events.BreakerTripped, resp.RecentErrors[0].Type)
+}
+
+func TestDashboard_Page(t *testing.T) {
+	rr := httptest.NewRecorder()
+	New(nil).Page().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), "app.js")
+}
+
+func TestDashboard_PoolFailure(t *testing.T) {
+	bus := events.NewBus()
+	d := New(bus)
+	bus.PoolObserver("api").PoolUpdated(1, errors.New("broken URL"))
+	bus.ConfigReloaded(nil)
+
+	rr := httptest.NewRecorder()
+	d.Status(rr, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var resp StatusResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	require.Len(t, resp.RecentErrors, 1)
+	require.Equal(t, events.PoolUpdated, resp.RecentErrors[0].Type)
+}
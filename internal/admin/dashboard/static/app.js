@@ -0,0 +1,193 @@
+"use strict";
+
+const refreshEvery = 2000;
+const tokenKey = "lb-admin-token";
+
+// Totals from the previous refresh, traffic share is computed on their deltas.
+let previous = {};
+
+class Unauthorized extends Error {}
+
+async function get(path) {
+  const headers = {};
+  const token = sessionStorage.getItem(tokenKey);
+  if (token) {
+    headers["Authorization"] = "Bearer " + token;
+  }
+  const resp = await fetch(path, { headers });
+  if (resp.status === 401 || resp.status === 403) {
+    throw new Unauthorized(resp.statusText);
+  }
+  if (resp.status === 404) {
+    return null;
+  }
+  if (!resp.ok) {
+    throw new Error(path + ": " + resp.status);
+  }
+  return resp.json();
+}
+
+function cell(row, value, cls) {
+  const td = row.insertCell();
+  td.textContent = value;
+  if (cls) {
+    td.className = cls;
+  }
+  return td;
+}
+
+function duration(seconds) {
+  if (seconds < 60) return Math.floor(seconds) + "s";
+  if (seconds < 3600) return Math.floor(seconds / 60) + "m";
+  if (seconds < 86400) return Math.floor(seconds / 3600) + "h";
+  return Math.floor(seconds / 86400) + "d";
+}
+
+function ms(value) {
+  return value.toFixed(1) + " ms";
+}
+
+function time(value) {
+  return new Date(value).toLocaleTimeString();
+}
+
+function key(s) {
+  return s.upstream + " " + s.url;
+}
+
+function renderServers(servers) {
+  // Traffic share is computed within upstream group.
+  const delta = {};
+  const total = {};
+  for (const s of servers) {
+    delta[s.upstream] = (delta[s.upstream] ?? 0) + s.total - (previous[key(s)] ?? s.total);
+    total[s.upstream] = (total[s.upstream] ?? 0) + s.total;
+  }
+
+  const body = document.querySelector("#servers tbody");
+  body.replaceChildren();
+  for (const s of servers) {
+    const row = body.insertRow();
+    row.className = s.state;
+
+    // Share of recent traffic, or of all traffic if idle since last refresh.
+    const share = delta[s.upstream] > 0
+      ? (s.total - (previous[key(s)] ?? s.total)) / delta[s.upstream]
+      : (total[s.upstream] > 0 ? s.total / total[s.upstream] : 0);
+
+    cell(row, s.upstream);
+    cell(row, s.url, "text");
+    cell(row, s.state);
+    cell(row, duration(s.state_for_seconds));
+    cell(row, s.in_flight);
+    cell(row, s.total);
+    cell(row, (share * 100).toFixed(1) + "%");
+    cell(row, s.errors["4xx"]);
+    cell(row, s.errors["5xx"], s.errors["5xx"] > 0 ? "bad" : "");
+    cell(row, s.errors["transport"], s.errors["transport"] > 0 ? "bad" : "");
+    cell(row, ms(s.latency.p50_ms));
+    cell(row, ms(s.latency.p90_ms));
+    cell(row, ms(s.latency.p99_ms));
+    cell(row, s.ejections);
+
+    const hc = s.last_health_check;
+    if (!hc) {
+      cell(row, "never", "muted");
+    } else if (hc.ok) {
+      cell(row, "ok at " + time(hc.at));
+    } else {
+      cell(row, (hc.error || "failed") + " at " + time(hc.at), "bad text");
+    }
+  }
+
+  previous = {};
+  for (const s of servers) {
+    previous[key(s)] = s.total;
+  }
+}
+
+function renderStatus(status) {
+  const cfg = status.config;
+  let text = "config v" + cfg.version + ", " + cfg.servers + " servers";
+  if (cfg.reloaded_at) {
+    text += ", loaded " + time(cfg.reloaded_at);
+  }
+  if (cfg.last_error) {
+    text += ", last reload failed: " + cfg.last_error;
+  }
+  text += " | up " + duration(status.uptime_seconds);
+  document.getElementById("config").textContent = text;
+
+  const body = document.querySelector("#errors tbody");
+  body.replaceChildren();
+  if (status.recent_errors.length === 0) {
+    const row = body.insertRow();
+    const td = cell(row, "none", "muted");
+    td.colSpan = 4;
+  }
+  for (const e of status.recent_errors.slice().reverse()) {
+    const row = body.insertRow();
+    cell(row, time(e.time));
+    cell(row, e.type);
+    cell(row, e.data.url ?? "", "text");
+    cell(row, e.data.error ?? (e.data.from ? e.data.from + " -> " + e.data.to : ""), "text bad");
+  }
+}
+
+function renderClients(clients, shadow) {
+  const section = document.getElementById("clients-section");
+  if (clients === null) {
+    section.hidden = true;
+    return;
+  }
+  section.hidden = false;
+  document.getElementById("global-shadow").textContent = shadow && shadow.shadow ? "(global shadow mode)" : "";
+
+  const body = document.querySelector("#clients tbody");
+  body.replaceChildren();
+  for (const c of clients) {
+    const row = body.insertRow();
+    cell(row, c.ip);
+    cell(row, c.capacity);
+    cell(row, c.refill_every);
+    cell(row, c.shadow ? "shadow" : "enforce");
+  }
+}
+
+async function refresh() {
+  try {
+    const [servers, status, clients, shadow] = await Promise.all([
+      get("../servers"),
+      get("../status"),
+      get("../clients"),
+      get("../clients/shadow"),
+    ]);
+    renderServers(servers ?? []);
+    renderStatus(status);
+    renderClients(clients, shadow);
+    document.getElementById("updated").textContent = "updated " + new Date().toLocaleTimeString();
+    document.getElementById("main").hidden = false;
+    document.getElementById("login").hidden = true;
+  } catch (err) {
+    if (err instanceof Unauthorized) {
+      showLogin(sessionStorage.getItem(tokenKey) ? "access denied" : "");
+      return;
+    }
+    document.getElementById("updated").textContent = "refresh failed: " + err.message;
+  }
+  setTimeout(refresh, refreshEvery);
+}
+
+function showLogin(message) {
+  document.getElementById("main").hidden = true;
+  document.getElementById("login").hidden = false;
+  document.getElementById("login-error").textContent = message;
+}
+
+document.getElementById("login").addEventListener("submit", (ev) => {
+  ev.preventDefault();
+  sessionStorage.setItem(tokenKey, document.getElementById("token").value);
+  refresh();
+});
+
+refresh();
@@ -2,23 +2,47 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/humanbelnik/load-balancer/internal/accesslog"
+	"github.com/humanbelnik/load-balancer/internal/admin/admin"
+	"github.com/humanbelnik/load-balancer/internal/admin/auth"
+	"github.com/humanbelnik/load-balancer/internal/admin/dashboard"
+	api_balancer "github.com/humanbelnik/load-balancer/internal/balancer/api/http"
 	"github.com/humanbelnik/load-balancer/internal/balancer/balancer"
-	"github.com/humanbelnik/load-balancer/internal/balancer/policy/rr"
+	"github.com/humanbelnik/load-balancer/internal/balancer/concurrency"
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
 	"github.com/humanbelnik/load-balancer/internal/balancer/pool/config_watcher"
-	"github.com/humanbelnik/load-balancer/internal/balancer/pool/dynamic_pool"
-	"github.com/humanbelnik/load-balancer/internal/balancer/server/factory"
+	"github.com/humanbelnik/load-balancer/internal/balancer/router"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/proxy"
+	"github.com/humanbelnik/load-balancer/internal/balancer/tcp"
+	"github.com/humanbelnik/load-balancer/internal/balancer/udp"
+	"github.com/humanbelnik/load-balancer/internal/certstore"
 	yaml_config "github.com/humanbelnik/load-balancer/internal/config/yaml"
+	"github.com/humanbelnik/load-balancer/internal/events"
+	api_events "github.com/humanbelnik/load-balancer/internal/events/api/http"
+	"github.com/humanbelnik/load-balancer/internal/metrics"
 	api_ratelimiter "github.com/humanbelnik/load-balancer/internal/ratelimiter/api/http"
+	rl_config "github.com/humanbelnik/load-balancer/internal/ratelimiter/config"
+	"github.com/humanbelnik/load-balancer/internal/ratelimiter/gossip"
 	"github.com/humanbelnik/load-balancer/internal/ratelimiter/ratelimiter"
+	redis_limiter "github.com/humanbelnik/load-balancer/internal/ratelimiter/redis"
 	sqlite_storage "github.com/humanbelnik/load-balancer/internal/ratelimiter/storage/sqlite"
+	"github.com/humanbelnik/load-balancer/internal/requestid"
+	"github.com/humanbelnik/load-balancer/internal/tracing"
 )
 
 type Config struct {
@@ -29,7 +53,54 @@ type Config struct {
 	RlimitStore string
 }
 
-func setupBalancer(appCfg Config, mux *http.ServeMux) ([]balancer.Option, error) {
+/*
+App holds public (proxied traffic) listener and optional admin listener.
+*/
+type App struct {
+	main    *http.Server
+	tls     *http.Server
+	admin   *admin.Server
+	metrics *metrics.Metrics
+
+	// Released after listeners are shut down.
+	closers []io.Closer
+
+	events    *events.Bus
+	dashboard *dashboard.Dashboard
+
+	// Routing is rebuilt on config reload, upstream groups stay.
+	router   *router.Router
+	handlers map[string]http.Handler
+	// Weighted routes by name.
+	splitsMu sync.Mutex
+	splits   map[string]*router.Split
+
+	// Hijacked by proxies, not closed by listeners' shutdown.
+	upgrades *proxy.Conns
+
+	// L4 listeners.
+	tcp []*tcp.Proxy
+	udp []*udp.Proxy
+
+	// Trusted sources of incoming PROXY protocol headers.
+	proxyProto *proxyProtocol
+}
+
+func setupAdmin(appCfg Config) (*admin.Server, error) {
+	cfg, err := yaml_config.NewAdminLoader().Load(appCfg.Confpath)
+	if err != nil {
+		return nil, fmt.Errorf("admin config: %w", err)
+	}
+
+	srv, err := admin.New(cfg)
+	if errors.Is(err, admin.ErrNoAuth) {
+		log.Printf("admin API disabled: %v", err)
+		return nil, nil
+	}
+	return srv, err
+}
+
+func setupBalancer(appCfg Config, a *App) ([]balancer.Option, error) {
 	opts := []balancer.Option{}
 	if appCfg.Rlimit {
 		store, err := sqlite_storage.New(appCfg.RlimitStore)
@@ -41,67 +112,227 @@ func setupBalancer(appCfg Config, mux *http.ServeMux) ([]balancer.Option, error)
 		if err != nil {
 			return nil, fmt.Errorf("rate limiter config")
 		}
-		rl := ratelimiter.New(cfg, store)
-		//rl.SetClient("127.0.0.1", nil, nil)
-		opts = append(opts, balancer.WithRateLimiter(rl))
-		api := api_ratelimiter.API{Limiter: rl}
-		mux.HandleFunc("/clients", func(w http.ResponseWriter, r *http.Request) {
-			switch r.Method {
-			case http.MethodPost:
-				api.AddClient(w, r)
-			case http.MethodDelete:
-				api.DeleteClient(w, r)
-			default:
-				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		rl := ratelimiter.New(cfg, store, ratelimiter.WithObserver(a.metrics))
+		var (
+			limiter balancer.RateLimiter    = rl
+			managed api_ratelimiter.Limiter = rl
+		)
+		switch cfg.Mode {
+		case rl_config.ModeLocal, "":
+		case rl_config.ModeRedis:
+			client := redis_limiter.NewClient(cfg.Redis)
+			a.closers = append(a.closers, client)
+			limiter = redis_limiter.New(client, rl, cfg.Redis, redis_limiter.WithObserver(a.metrics))
+		case rl_config.ModeGossip:
+			node, err := gossip.New(cfg.Gossip, rl)
+			if err != nil {
+				return nil, fmt.Errorf("rate limiter gossip: %w", err)
 			}
-		})
+			a.closers = append(a.closers, node)
+			limiter, managed = node, node
+		default:
+			return nil, fmt.Errorf("unknown rate limiter mode %q", cfg.Mode)
+		}
+		opts = append(opts, balancer.WithRateLimiter(limiter))
+
+		if adm := a.admin; adm != nil {
+			api := api_ratelimiter.New(managed, api_ratelimiter.WithListener(a.events))
+			adm.Handle("GET /clients", auth.RoleRead, api.ListClients)
+			adm.Handle("POST /clients", auth.RoleWrite, api.AddClient)
+			adm.Handle("DELETE /clients", auth.RoleWrite, api.DeleteClient)
+			adm.Handle("GET /clients/shadow", auth.RoleRead, api.GetShadow)
+			adm.Handle("PUT /clients/shadow", auth.RoleWrite, api.SetShadow)
+		}
+	}
+
+	accessCfg, err := yaml_config.NewAccessLogLoader().Load(appCfg.Confpath)
+	if err != nil {
+		return nil, fmt.Errorf("access log config: %w", err)
+	}
+	if accessCfg.Enabled {
+		accessLog, err := accesslog.New(accessCfg)
+		if err != nil {
+			return nil, fmt.Errorf("access log: %w", err)
+		}
+		a.closers = append(a.closers, accessLog)
+		opts = append(opts, balancer.WithAccessLogger(accessLog))
+	}
+
+	ridCfg, err := yaml_config.NewRequestIDLoader().Load(appCfg.Confpath)
+	if err != nil {
+		return nil, fmt.Errorf("request id config: %w", err)
+	}
+	resolver, err := requestid.New(ridCfg)
+	if err != nil {
+		return nil, fmt.Errorf("request id: %w", err)
+	}
+	opts = append(opts, balancer.WithRequestIDResolver(resolver))
+
+	concCfg, err := yaml_config.NewConcurrencyLoader().Load(appCfg.Confpath)
+	if err != nil {
+		return nil, fmt.Errorf("concurrency config: %w", err)
+	}
+	if concCfg.Enabled() {
+		opts = append(opts, balancer.WithConcurrencyLimiter(concurrency.New(concCfg)))
 	}
 	return opts, nil
 }
 
-func Setup(appCfg Config) (*http.Server, error) {
-	// Manually load config and setup server pool on the launch
-	loader := yaml_config.NewBalancerLoader()
-	urls, err := loader.Load(appCfg.Confpath)
+/*
+HTTPS listener serving the same routes as the main one.
+*/
+func setupTLS(appCfg Config, a *App, handler http.Handler, h2 config.HTTP2Config) error {
+	cfg, err := yaml_config.NewTLSLoader().Load(appCfg.Confpath)
 	if err != nil {
-		return nil, fmt.Errorf("load balancer config: %w", err)
+		return fmt.Errorf("TLS config: %w", err)
+	}
+	if !cfg.Enabled {
+		return nil
 	}
 
-	factory := factory.New()
-	p := dynamic_pool.New(factory)
+	store, err := certstore.New(cfg.Certificates)
+	if err != nil {
+		return fmt.Errorf("certificates: %w", err)
+	}
+	tlsCfg, err := certstore.TLSConfig(cfg, store)
+	if err != nil {
+		return err
+	}
+
+	if cfg.ReloadInterval > 0 {
+		store.Watch(cfg.ReloadInterval)
+	}
+	a.closers = append(a.closers, store)
+	config_watcher.WatchFunc(appCfg.Confpath, func(string) error { return store.Reload() }, config_watcher.DefaultOnError)
 
-	if err := p.Update(urls); err != nil {
-		return nil, fmt.Errorf("update pool: %w", err)
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(!h2.DisableTLS)
+	a.tls = &http.Server{
+		Addr:      cfg.Addr,
+		Handler:   handler,
+		TLSConfig: tlsCfg,
+		Protocols: &protocols,
+		HTTP2:     &http.HTTP2Config{MaxConcurrentStreams: int(h2.MaxConcurrentStreams)},
 	}
+	return nil
+}
 
-	// Watch after SIGHUPs
-	watcher := config_watcher.New(loader, config_watcher.DefaultOnError)
-	watcher.Watch(appCfg.Confpath, p)
+/*
+Plain listener, optionally speaking cleartext HTTP/2.
+*/
+func setupMain(addr string, handler http.Handler, h2 config.HTTP2Config) *http.Server {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+		HTTP2:   &http.HTTP2Config{MaxConcurrentStreams: int(h2.MaxConcurrentStreams)},
+	}
+	if h2.H2C {
+		// Server handles prior knowledge itself, h2c handler only gets 'Upgrade: h2c' requests.
+		var protocols http.Protocols
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+		srv.Protocols = &protocols
+		srv.Handler = h2c.NewHandler(handler, &http2.Server{MaxConcurrentStreams: h2.MaxConcurrentStreams})
+	}
+	return srv
+}
+
+func Setup(appCfg Config) (*App, error) {
+	// Records logged with request context carry its ID.
+	// Handler must not wrap slog.Default() one: it writes through log package, which is redirected here.
+	slog.SetDefault(slog.New(requestid.NewHandler(slog.NewTextHandler(os.Stderr, nil))))
+
+	a := &App{
+		metrics: metrics.New(),
+		events:  events.NewBus(),
+		// Shared by all proxies.
+		upgrades: proxy.NewConns(),
+	}
+	a.dashboard = dashboard.New(a.events)
+
+	tracingCfg, err := yaml_config.NewTracingLoader().Load(appCfg.Confpath)
+	if err != nil {
+		return nil, fmt.Errorf("load tracing config: %w", err)
+	}
+	if tracingCfg.Enabled {
+		provider, err := tracing.Setup(tracingCfg)
+		if err != nil {
+			return nil, fmt.Errorf("setting up tracing: %w", err)
+		}
+		a.closers = append(a.closers, provider)
+	}
+
+	// Admin API lives on its own listener
+	a.admin, err = setupAdmin(appCfg)
+	if err != nil {
+		return nil, fmt.Errorf("setting up admin API: %w", err)
+	}
 
 	// Configure load balancer
-	mux := http.NewServeMux()
-	balancerOpts, err := setupBalancer(appCfg, mux)
+	balancerOpts, err := setupBalancer(appCfg, a)
 	if err != nil {
 		return nil, fmt.Errorf("setting up balancer options: %w", err)
 	}
 
-	roundRobinPolicy := rr.New()
-	bal := balancer.New(p, roundRobinPolicy, balancerOpts...)
+	balancerOpts = append(balancerOpts, balancer.WithMetrics(a.metrics))
+	handler, upstreams, err := a.setupRouting(appCfg, balancerOpts)
+	if err != nil {
+		return nil, fmt.Errorf("setting up routing: %w", err)
+	}
 	addr := appCfg.Host + ":" + appCfg.Port
 
-	// Configure API
-	mux.HandleFunc("/", bal.Serve)
-	http.HandleFunc("/", bal.Serve)
-	return &http.Server{
-		Addr:    addr,
-		Handler: mux,
-	}, nil
+	pools := make(map[string]api_balancer.Pool, len(upstreams))
+	for name, u := range upstreams {
+		pools[name] = u.pool
+	}
+	if appCfg.Rlimit {
+		a.metrics.RegisterShadowRejected(func() uint64 {
+			var total uint64
+			for _, u := range upstreams {
+				total += u.balancer.ShadowRejected()
+			}
+			return total
+		})
+	}
+	if a.admin != nil {
+		a.admin.Handle("GET /metrics", auth.RoleRead, a.metrics.Handler().ServeHTTP)
+		api := api_balancer.New(pools, api_balancer.WithSplits(a.currentSplits), api_balancer.WithListener(a.events))
+		a.admin.Handle("GET /servers", auth.RoleRead, api.ListServers)
+		a.admin.Handle("GET /splits", auth.RoleRead, api.ListSplits)
+		a.admin.Handle("PUT /splits", auth.RoleWrite, api.SetSplit)
+		a.admin.Handle("GET /events", auth.RoleRead, api_events.New(a.events).Stream)
+		a.admin.Handle("GET /status", auth.RoleRead, a.dashboard.Status)
+		a.admin.HandlePublic("GET /dashboard/", http.StripPrefix("/dashboard", a.dashboard.Page()))
+		a.admin.HandlePublic("GET /{$}", http.RedirectHandler("/dashboard/", http.StatusFound))
+	}
+
+	a.proxyProto, err = setupProxyProtocol(appCfg)
+	if err != nil {
+		return nil, fmt.Errorf("setting up PROXY protocol: %w", err)
+	}
+	if err := a.setupTCP(appCfg, upstreams); err != nil {
+		return nil, fmt.Errorf("setting up TCP: %w", err)
+	}
+	if err := a.setupUDP(appCfg, upstreams); err != nil {
+		return nil, fmt.Errorf("setting up UDP: %w", err)
+	}
+
+	h2Cfg, err := yaml_config.NewHTTP2Loader().Load(appCfg.Confpath)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP/2 config: %w", err)
+	}
+	a.main = setupMain(addr, handler, h2Cfg)
+	if err := setupTLS(appCfg, a, handler, h2Cfg); err != nil {
+		return nil, fmt.Errorf("setting up TLS: %w", err)
+	}
+	return a, nil
 }
 
 /*
 Performs gracefull shutdown on SIGINT/SIGTERM.
 */
-func Run(srv *http.Server) {
+func Run(a *App) {
 	idleConnsClosed := make(chan struct{})
 	go func() {
 		sigint := make(chan os.Signal, 1)
@@ -113,19 +344,101 @@ func Run(srv *http.Server) {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		if err := srv.Shutdown(ctx); err != nil {
+		if err := a.main.Shutdown(ctx); err != nil {
 			log.Printf("graceful shutdown failed: %v", err)
 		} else {
 			log.Println("shutdown complete")
 		}
+		if a.tls != nil {
+			if err := a.tls.Shutdown(ctx); err != nil {
+				log.Printf("TLS shutdown failed: %v", err)
+			}
+		}
+		for _, p := range a.tcp {
+			if err := p.Shutdown(ctx); err != nil {
+				log.Printf("TCP shutdown failed: %v", err)
+			}
+		}
+		for _, p := range a.udp {
+			if err := p.Shutdown(ctx); err != nil {
+				log.Printf("UDP shutdown failed: %v", err)
+			}
+		}
+		if err := a.upgrades.Shutdown(ctx); err != nil {
+			log.Printf("upgraded connections closed: %v", err)
+		}
+		if a.admin != nil {
+			if err := a.admin.Shutdown(ctx); err != nil {
+				log.Printf("admin shutdown failed: %v", err)
+			}
+		}
+		for _, c := range a.closers {
+			if err := c.Close(); err != nil {
+				log.Printf("close: %v", err)
+			}
+		}
 
 		close(idleConnsClosed)
 	}()
 
-	log.Printf("listening on %s", srv.Addr)
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	var wg sync.WaitGroup
+	if a.admin != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Printf("admin listening on %s", a.admin.Addr())
+			if err := a.admin.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("admin server error: %v", err)
+			}
+		}()
+	}
+
+	if a.tls != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Printf("TLS listening on %s", a.tls.Addr)
+			l, err := a.proxyProto.listen(a.tls.Addr)
+			if err != nil {
+				log.Fatalf("TLS server error: %v", err)
+			}
+			if err := a.tls.ServeTLS(l, "", ""); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("TLS server error: %v", err)
+			}
+		}()
+	}
+
+	for _, p := range a.tcp {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Printf("TCP %q listening on %s", p.Name(), p.Addr())
+			if err := p.ListenAndServe(); err != nil && err != tcp.ErrClosed {
+				log.Fatalf("TCP server error: %v", err)
+			}
+		}()
+	}
+
+	for _, p := range a.udp {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Printf("UDP %q listening on %s", p.Name(), p.Addr())
+			if err := p.ListenAndServe(); err != nil && err != udp.ErrClosed {
+				log.Fatalf("UDP server error: %v", err)
+			}
+		}()
+	}
+
+	log.Printf("listening on %s", a.main.Addr)
+	l, err := a.proxyProto.listen(a.main.Addr)
+	if err != nil {
+		log.Fatalf("server error: %v", err)
+	}
+	if err := a.main.Serve(l); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("server error: %v", err)
 	}
 
 	<-idleConnsClosed
+	wg.Wait()
 }
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+)
+
+/*
+Protocol of request as seen by handler of main listener.
+*/
+func serveMain(t *testing.T, h2 config.HTTP2Config, client http.Protocols) (string, error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := setupMain(ln.Addr().String(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	}), h2)
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	tr := &http.Transport{Protocols: &client}
+	defer tr.CloseIdleConnections()
+	resp, err := (&http.Client{Transport: tr}).Get("http://" + ln.Addr().String())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body), nil
+}
+
+func TestSetupMain_H2C(t *testing.T) {
+	var priorKnowledge, http1 http.Protocols
+	priorKnowledge.SetUnencryptedHTTP2(true)
+	http1.SetHTTP1(true)
+
+	proto, err := serveMain(t, config.HTTP2Config{H2C: true}, priorKnowledge)
+	require.NoError(t, err)
+	require.Equal(t, "HTTP/2.0", proto)
+
+	proto, err = serveMain(t, config.HTTP2Config{H2C: true}, http1)
+	require.NoError(t, err)
+	require.Equal(t, "HTTP/1.1", proto)
+
+	_, err = serveMain(t, config.HTTP2Config{}, priorKnowledge)
+	require.Error(t, err)
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	yaml_config "github.com/humanbelnik/load-balancer/internal/config/yaml"
+	"github.com/humanbelnik/load-balancer/internal/proxyproto"
+)
+
+var (
+	ErrNoTrusted = errors.New("PROXY protocol requires trusted sources")
+)
+
+/*
+Incoming PROXY protocol settings shared by listeners.
+*/
+type proxyProtocol struct {
+	cfg     config.ProxyProtocolConfig
+	trusted []netip.Prefix
+}
+
+func setupProxyProtocol(appCfg Config) (*proxyProtocol, error) {
+	cfg, err := yaml_config.NewProxyProtocolLoader().Load(appCfg.Confpath)
+	if err != nil {
+		return nil, err
+	}
+	trusted, err := proxyproto.ParseTrusted(cfg.Trusted)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Enabled && len(trusted) == 0 {
+		return nil, ErrNoTrusted
+	}
+	return &proxyProtocol{cfg: cfg, trusted: trusted}, nil
+}
+
+/*
+HTTP listener, header is expected from trusted sources if enabled.
+*/
+func (p *proxyProtocol) listen(addr string) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil || !p.cfg.Enabled {
+		return l, err
+	}
+	return proxyproto.NewListener(l, p.trusted, p.cfg.HeaderTimeout), nil
+}
+
+/*
+Trusted sources of TCP listener are the same.
+*/
+func (p *proxyProtocol) acceptTCP(cfg config.TCPConfig) (bool, error) {
+	if !cfg.AcceptProxyProtocol {
+		return false, nil
+	}
+	if len(p.trusted) == 0 {
+		return false, fmt.Errorf("%q: %w", cfg.Name, ErrNoTrusted)
+	}
+	return true, nil
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/router"
+	"github.com/humanbelnik/load-balancer/internal/balancer/tcp"
+	yaml_config "github.com/humanbelnik/load-balancer/internal/config/yaml"
+)
+
+var (
+	ErrNoListen = errors.New("listener must have 'listen' address")
+)
+
+/*
+Raw TCP listeners share pools, policies and health checks with upstream groups.
+*/
+func (a *App) setupTCP(appCfg Config, upstreams map[string]*upstream) error {
+	cfgs, err := yaml_config.NewTCPLoader().Load(appCfg.Confpath)
+	if err != nil {
+		return err
+	}
+	for _, cfg := range cfgs {
+		if cfg.Listen == "" {
+			return fmt.Errorf("%q: %w", cfg.Name, ErrNoListen)
+		}
+		u, ok := upstreams[cfg.Upstream]
+		if !ok {
+			return fmt.Errorf("%q: %w: %s", cfg.Name, router.ErrUnknownUpstream, cfg.Upstream)
+		}
+		var opts []tcp.Option
+		accept, err := a.proxyProto.acceptTCP(cfg)
+		if err != nil {
+			return err
+		}
+		if accept {
+			opts = append(opts, tcp.WithAcceptProxyProtocol(a.proxyProto.trusted, a.proxyProto.cfg.HeaderTimeout))
+		}
+		if u.proxyProtocol != 0 {
+			opts = append(opts, tcp.WithSendProxyProtocol(u.proxyProtocol))
+		}
+		a.tcp = append(a.tcp, tcp.New(cfg, u.pool, u.policy, opts...))
+	}
+	return nil
+}
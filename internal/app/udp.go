@@ -0,0 +1,38 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/router"
+	"github.com/humanbelnik/load-balancer/internal/balancer/udp"
+	yaml_config "github.com/humanbelnik/load-balancer/internal/config/yaml"
+)
+
+var (
+	ErrUDPProxyProtocol = errors.New("PROXY protocol is not supported for UDP servers")
+)
+
+/*
+UDP listeners, same as TCP ones, use pools, policies and health checks of upstream groups.
+*/
+func (a *App) setupUDP(appCfg Config, upstreams map[string]*upstream) error {
+	cfgs, err := yaml_config.NewUDPLoader().Load(appCfg.Confpath)
+	if err != nil {
+		return err
+	}
+	for _, cfg := range cfgs {
+		if cfg.Listen == "" {
+			return fmt.Errorf("%q: %w", cfg.Name, ErrNoListen)
+		}
+		u, ok := upstreams[cfg.Upstream]
+		if !ok {
+			return fmt.Errorf("%q: %w: %s", cfg.Name, router.ErrUnknownUpstream, cfg.Upstream)
+		}
+		if u.proxyProtocol != 0 {
+			return fmt.Errorf("%q: %w", cfg.Name, ErrUDPProxyProtocol)
+		}
+		a.udp = append(a.udp, udp.New(cfg, u.pool, u.policy))
+	}
+	return nil
+}
@@ -0,0 +1,356 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	api_balancer "github.com/humanbelnik/load-balancer/internal/balancer/api/http"
+	"github.com/humanbelnik/load-balancer/internal/balancer/balancer"
+	"github.com/humanbelnik/load-balancer/internal/balancer/concurrency"
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	"github.com/humanbelnik/load-balancer/internal/balancer/health"
+	"github.com/humanbelnik/load-balancer/internal/balancer/policy/ch"
+	"github.com/humanbelnik/load-balancer/internal/balancer/policy/lc"
+	"github.com/humanbelnik/load-balancer/internal/balancer/policy/rr"
+	"github.com/humanbelnik/load-balancer/internal/balancer/pool/config_watcher"
+	"github.com/humanbelnik/load-balancer/internal/balancer/pool/dynamic_pool"
+	"github.com/humanbelnik/load-balancer/internal/balancer/rewrite"
+	"github.com/humanbelnik/load-balancer/internal/balancer/router"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/factory"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/proxy"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+	yaml_config "github.com/humanbelnik/load-balancer/internal/config/yaml"
+	"github.com/humanbelnik/load-balancer/internal/proxyproto"
+)
+
+var (
+	ErrNoUpstreams      = errors.New("no upstream groups configured")
+	ErrUpstreamsChanged = errors.New("upstream groups can't be added or removed without restart")
+	ErrUnknownPolicy    = errors.New("unknown policy")
+	ErrBadRoute         = errors.New("route must have exactly one of upstream, split or redirect")
+	ErrUnnamedSplit     = errors.New("split route must have unique name")
+)
+
+/*
+Upstream group: own pool, policy, health checks and limiter behind a balancer.
+*/
+type upstream struct {
+	pool     *dynamic_pool.Dynamic
+	policy   balancer.Policy
+	balancer *balancer.Balancer
+	// PROXY protocol version sent to servers, 0 if off.
+	proxyProtocol int
+	// Protocol spoken to servers, checked against schemes of reloaded servers.
+	protocol string
+}
+
+func newPolicy(name string) (balancer.Policy, error) {
+	switch name {
+	case config.PolicyRoundRobin, "":
+		return rr.New(), nil
+	case config.PolicyLeastConnections:
+		return lc.New(), nil
+	case config.PolicyConsistentHash:
+		return ch.New(), nil
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownPolicy, name)
+	}
+}
+
+/*
+Group settings override global ones field by field.
+*/
+func mergeHealthCheck(global config.HealthCheckConfig, group *config.HealthCheckConfig) config.HealthCheckConfig {
+	if group == nil {
+		return global
+	}
+	merged := *group
+	if merged.Type == "" {
+		merged.Type = global.Type
+	}
+	if merged.Path == "" {
+		merged.Path = global.Path
+	}
+	if merged.Interval == 0 {
+		merged.Interval = global.Interval
+	}
+	if merged.Timeout == 0 {
+		merged.Timeout = global.Timeout
+	}
+	return merged
+}
+
+func (a *App) setupUpstream(appCfg Config, name string, cfg config.UpstreamConfig, opts []balancer.Option) (*upstream, error) {
+	transport, err := proxy.NewTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	upgradeCfg, err := yaml_config.NewUpgradeLoader().Load(appCfg.Confpath)
+	if err != nil {
+		return nil, fmt.Errorf("upgrade config: %w", err)
+	}
+
+	factory := factory.New(
+		server.WithProxyOptions(
+			proxy.WithObserver(a.metrics),
+			proxy.WithTransport(transport),
+			proxy.WithConns(a.upgrades),
+			proxy.WithIdleTimeout(upgradeCfg.IdleTimeout),
+		),
+		server.WithListener(a.events),
+	)
+	p := dynamic_pool.New(factory,
+		dynamic_pool.WithObserver(a.events.PoolObserver(name)),
+		dynamic_pool.WithObserver(a.dashboard.PoolObserver(name)),
+		dynamic_pool.WithListener(a.events),
+	)
+	if err := p.Update(cfg.Servers); err != nil {
+		return nil, fmt.Errorf("update pool: %w", err)
+	}
+	a.metrics.RegisterPool(name, p)
+
+	globalHealth, err := yaml_config.NewHealthCheckLoader().Load(appCfg.Confpath)
+	if err != nil {
+		return nil, fmt.Errorf("load health check config: %w", err)
+	}
+	if healthCfg := mergeHealthCheck(globalHealth, cfg.HealthCheck); healthCfg.Enabled {
+		prober, err := health.NewProber(healthCfg, transport)
+		if err != nil {
+			return nil, err
+		}
+		checker := health.New(p, healthCfg, health.WithProber(prober))
+		checker.Start()
+		a.closers = append(a.closers, checker)
+	}
+
+	policy, err := newPolicy(cfg.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replaces limiter shared by groups.
+	if conc := cfg.Concurrency; conc != nil && conc.Enabled() {
+		if conc.QueueTimeout == 0 {
+			globalConc, err := yaml_config.NewConcurrencyLoader().Load(appCfg.Confpath)
+			if err != nil {
+				return nil, fmt.Errorf("concurrency config: %w", err)
+			}
+			conc.QueueTimeout = globalConc.QueueTimeout
+		}
+		opts = append(slices.Clip(opts), balancer.WithConcurrencyLimiter(concurrency.New(*conc)))
+	}
+
+	// Validated by transport.
+	proxyProtocol, _ := proxyproto.ParseVersion(cfg.ProxyProtocol)
+
+	return &upstream{
+		pool:          p,
+		policy:        policy,
+		balancer:      balancer.New(p, policy, opts...),
+		proxyProtocol: proxyProtocol,
+		protocol:      cfg.Protocol,
+	}, nil
+}
+
+/*
+Builds every upstream group and router in front of them.
+Requests not matched by any route go to 'default' group, if there is one.
+*/
+func (a *App) setupRouting(appCfg Config, opts []balancer.Option) (http.Handler, map[string]*upstream, error) {
+	cfg, err := yaml_config.NewRoutingLoader().Load(appCfg.Confpath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load routing config: %w", err)
+	}
+	if len(cfg.Upstreams) == 0 {
+		return nil, nil, ErrNoUpstreams
+	}
+
+	upstreams := make(map[string]*upstream, len(cfg.Upstreams))
+	for name, ucfg := range cfg.Upstreams {
+		u, err := a.setupUpstream(appCfg, name, ucfg, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("upstream %q: %w", name, err)
+		}
+		upstreams[name] = u
+	}
+
+	a.handlers = make(map[string]http.Handler, len(upstreams))
+	for name, u := range upstreams {
+		a.handlers[name] = http.HandlerFunc(u.balancer.Serve)
+	}
+
+	routes, splits, err := a.buildRoutes(cfg.Routes)
+	if err != nil {
+		return nil, nil, err
+	}
+	a.splits = splits
+
+	var routerOpts []router.Option
+	if h, ok := a.handlers[config.DefaultUpstream]; ok {
+		routerOpts = append(routerOpts, router.WithFallback(h))
+	}
+	a.router = router.New(routes, routerOpts...)
+	// Initial load is the first configuration version.
+	a.dashboard.ConfigReloaded(nil)
+
+	// Server lists, routes and split weights follow config on SIGHUP.
+	reload := func(path string) error {
+		return a.reload(path, upstreams)
+	}
+	config_watcher.WatchFunc(appCfg.Confpath, reload, config_watcher.DefaultOnError)
+	return a.router, upstreams, nil
+}
+
+/*
+Routes in config order, splits by route name.
+*/
+func (a *App) buildRoutes(cfgs []config.RouteConfig) ([]router.Route, map[string]*router.Split, error) {
+	routes := make([]router.Route, 0, len(cfgs))
+	splits := make(map[string]*router.Split)
+	for _, rcfg := range cfgs {
+		h, err := a.routeHandler(rcfg, splits)
+		if err != nil {
+			return nil, nil, fmt.Errorf("route %q: %w", rcfg.Name, err)
+		}
+		route, err := router.NewRoute(rcfg, h)
+		if err != nil {
+			return nil, nil, err
+		}
+		routes = append(routes, route)
+	}
+	return routes, splits, nil
+}
+
+/*
+Split routes are added to splits.
+*/
+func (a *App) routeHandler(cfg config.RouteConfig, splits map[string]*router.Split) (http.Handler, error) {
+	targets := 0
+	for _, set := range []bool{cfg.Upstream != "", len(cfg.Split) > 0, cfg.Redirect != nil} {
+		if set {
+			targets++
+		}
+	}
+	if targets != 1 {
+		return nil, ErrBadRoute
+	}
+
+	switch {
+	case cfg.Redirect != nil:
+		rw, err := rewrite.Compile(cfg.Rewrite)
+		if err != nil {
+			return nil, err
+		}
+		return rewrite.NewRedirect(*cfg.Redirect, rw)
+	case cfg.Upstream != "":
+		h, ok := a.handlers[cfg.Upstream]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", router.ErrUnknownUpstream, cfg.Upstream)
+		}
+		return h, nil
+	default:
+		if _, dup := splits[cfg.Name]; cfg.Name == "" || dup {
+			return nil, ErrUnnamedSplit
+		}
+		split, err := router.NewSplit(cfg.Split, cfg.Sticky, a.handlers)
+		if err != nil {
+			return nil, err
+		}
+		splits[cfg.Name] = split
+		return split, nil
+	}
+}
+
+/*
+Reports reload once, whatever number of groups it touches.
+*/
+func (a *App) reload(path string, upstreams map[string]*upstream) error {
+	err := a.reloadRouting(path, upstreams)
+	a.metrics.ConfigReloaded(err)
+	a.events.ConfigReloaded(err)
+	a.dashboard.ConfigReloaded(err)
+	return err
+}
+
+/*
+Applies servers of every group, routes and split weights from config.
+Nothing is applied if config is invalid. Other group settings need restart.
+Pool update failures are reported by pools themselves.
+*/
+func (a *App) reloadRouting(path string, upstreams map[string]*upstream) error {
+	cfg, err := yaml_config.NewRoutingLoader().Load(path)
+	if err != nil {
+		return err
+	}
+	if len(cfg.Upstreams) != len(upstreams) {
+		return ErrUpstreamsChanged
+	}
+	for name, ucfg := range cfg.Upstreams {
+		if _, ok := upstreams[name]; !ok {
+			return fmt.Errorf("%w: %s", ErrUpstreamsChanged, name)
+		}
+		// Protocol can't be changed, but servers can.
+		ucfg.Protocol = upstreams[name].protocol
+		if err := proxy.CheckProtocol(ucfg); err != nil {
+			return fmt.Errorf("upstream %q: %w", name, err)
+		}
+	}
+	routes, splits, err := a.buildRoutes(cfg.Routes)
+	if err != nil {
+		return err
+	}
+
+	for name, u := range upstreams {
+		if err := u.pool.Update(cfg.Upstreams[name].Servers); err != nil {
+			log.Printf("upstream %q: %v", name, err)
+		}
+	}
+	a.router.SetRoutes(routes)
+	a.setSplits(splits)
+	return nil
+}
+
+/*
+Replaces splits. Changed weights of routes kept by name are reported,
+upstreams dropped from a route are reported with zero weight.
+*/
+func (a *App) setSplits(splits map[string]*router.Split) {
+	a.splitsMu.Lock()
+	defer a.splitsMu.Unlock()
+	for name, split := range splits {
+		old, ok := a.splits[name]
+		if !ok || slices.Equal(old.Weights(), split.Weights()) {
+			continue
+		}
+		weights := make(map[string]int)
+		for _, w := range old.Weights() {
+			weights[w.Upstream] = 0
+		}
+		for _, w := range split.Weights() {
+			weights[w.Upstream] = w.Weight
+		}
+		a.events.SplitChanged(name, weights)
+	}
+	for name := range a.splits {
+		if _, ok := splits[name]; !ok {
+			a.events.SplitRemoved(name)
+		}
+	}
+	a.splits = splits
+}
+
+/*
+Current splits for admin API.
+*/
+func (a *App) currentSplits() map[string]api_balancer.Split {
+	a.splitsMu.Lock()
+	defer a.splitsMu.Unlock()
+	splits := make(map[string]api_balancer.Split, len(a.splits))
+	for name, s := range a.splits {
+		splits[name] = s
+	}
+	return splits
+}
@@ -0,0 +1,315 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/admin/dashboard"
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	"github.com/humanbelnik/load-balancer/internal/balancer/policy/ch"
+	"github.com/humanbelnik/load-balancer/internal/balancer/policy/lc"
+	"github.com/humanbelnik/load-balancer/internal/balancer/policy/rr"
+	"github.com/humanbelnik/load-balancer/internal/balancer/router"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/proxy"
+	"github.com/humanbelnik/load-balancer/internal/events"
+	"github.com/humanbelnik/load-balancer/internal/metrics"
+)
+
+func newTestApp() *App {
+	a := &App{
+		metrics:  metrics.New(),
+		events:   events.NewBus(),
+		upgrades: proxy.NewConns(),
+	}
+	a.dashboard = dashboard.New(a.events)
+	return a
+}
+
+/*
+Backend answering with its name.
+*/
+func backend(t *testing.T, name string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+}
+
+func get(t *testing.T, h http.Handler, target string) string {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+	if rr.Code != http.StatusOK {
+		return rr.Result().Status
+	}
+	return rr.Body.String()
+}
+
+func TestNewPolicy(t *testing.T) {
+	for name, want := range map[string]any{
+		"":                            &rr.RoundRobinPolicy{},
+		config.PolicyRoundRobin:       &rr.RoundRobinPolicy{},
+		config.PolicyLeastConnections: &lc.LeastConnectionsPolicy{},
+		config.PolicyConsistentHash:   &ch.ConsistentHashPolicy{},
+	} {
+		p, err := newPolicy(name)
+		require.NoError(t, err)
+		require.IsType(t, want, p, name)
+	}
+	_, err := newPolicy("random")
+	require.ErrorIs(t, err, ErrUnknownPolicy)
+}
+
+func TestMergeHealthCheck(t *testing.T) {
+	global := config.HealthCheckConfig{Enabled: true, Path: "/health", Interval: 10 * time.Second, Timeout: 2 * time.Second}
+	require.Equal(t, global, mergeHealthCheck(global, nil))
+
+	merged := mergeHealthCheck(global, &config.HealthCheckConfig{Enabled: true, Type: config.HealthCheckGRPC, Timeout: time.Second})
+	require.Equal(t, config.HealthCheckGRPC, merged.Type)
+	require.Equal(t, "/health", merged.Path)
+	require.Equal(t, 10*time.Second, merged.Interval)
+	require.Equal(t, time.Second, merged.Timeout)
+
+	// Group may turn checks off.
+	require.False(t, mergeHealthCheck(global, &config.HealthCheckConfig{}).Enabled)
+}
+
+func TestSetupRouting_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	a := backend(t, "a")
+	for name, tc := range map[string]struct {
+		routes string
+		err    error
+	}{
+		"unknown upstream": {routes: "[{name: r, upstream: missing}]", err: router.ErrUnknownUpstream},
+		"no target":        {routes: "[{name: r}]", err: ErrBadRoute},
+		"two targets":      {routes: "[{name: r, upstream: default, redirect: {host: x}}]", err: ErrBadRoute},
+		"unnamed split":    {routes: "[{split: [{upstream: default, weight: 1}]}]", err: ErrUnnamedSplit},
+		"bad wildcard":     {routes: "[{name: r, hosts: ['*example.com'], upstream: default}]", err: router.ErrBadRoute},
+	} {
+		t.Run(name, func(t *testing.T) {
+			writeConfig(t, path, "servers: ["+a+"]\nroutes: "+tc.routes+"\n")
+			_, _, err := newTestApp().setupRouting(Config{Confpath: path}, nil)
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+
+	writeConfig(t, path, "upstreams: {}\n")
+	_, _, err := newTestApp().setupRouting(Config{Confpath: path}, nil)
+	require.ErrorIs(t, err, ErrNoUpstreams)
+}
+
+func TestReloadRouting(t *testing.T) {
+	a, b, c := backend(t, "a"), backend(t, "b"), backend(t, "c")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, `
+servers: [`+a+`]
+upstreams:
+  api:
+    servers: [`+b+`]
+routes:
+  - name: api
+    path_prefix: /api/
+    upstream: api
+  - name: app
+    path_prefix: /app/
+    split:
+      - upstream: default
+        weight: 1
+      - upstream: api
+        weight: 0
+`)
+	app := newTestApp()
+	h, upstreams, err := app.setupRouting(Config{Confpath: path}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "b", get(t, h, "/api/x"))
+	require.Equal(t, "a", get(t, h, "/app/x"))
+	require.Equal(t, "a", get(t, h, "/other"))
+
+	changes, _, cancel := app.events.Subscribe(0, events.SplitChanged)
+	defer cancel()
+
+	// Routes, weights and servers are changed at once.
+	writeConfig(t, path, `
+servers: [`+a+`]
+upstreams:
+  api:
+    servers: [`+c+`]
+routes:
+  - name: api
+    path_prefix: /v2/
+    upstream: api
+  - name: app
+    path_prefix: /app/
+    split:
+      - upstream: default
+        weight: 0
+      - upstream: api
+        weight: 1
+`)
+	require.NoError(t, app.reloadRouting(path, upstreams))
+	require.Equal(t, "c", get(t, h, "/v2/x"))
+	require.Equal(t, "a", get(t, h, "/api/x"))
+	require.Equal(t, "c", get(t, h, "/app/x"))
+
+	e := <-changes
+	require.Equal(t, "app", e.Data["route"])
+	require.Contains(t, app.currentSplits(), "app")
+
+	// Invalid config is not applied at all.
+	writeConfig(t, path, `
+servers: [`+b+`]
+upstreams:
+  api:
+    servers: [`+a+`]
+routes:
+  - name: api
+    upstream: missing
+`)
+	require.ErrorIs(t, app.reloadRouting(path, upstreams), router.ErrUnknownUpstream)
+	require.Equal(t, "c", get(t, h, "/v2/x"))
+	require.Equal(t, "a", get(t, h, "/other"))
+
+	writeConfig(t, path, `
+servers: [`+a+`]
+upstreams:
+  api:
+    servers: [`+b+`]
+  extra:
+    servers: [`+c+`]
+`)
+	require.ErrorIs(t, app.reloadRouting(path, upstreams), ErrUpstreamsChanged)
+	require.Equal(t, "c", get(t, h, "/v2/x"))
+}
+
+func TestReloadRouting_SplitEvents(t *testing.T) {
+	a, b := backend(t, "a"), backend(t, "b")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, `
+servers: [`+a+`]
+upstreams:
+  canary:
+    servers: [`+b+`]
+routes:
+  - name: app
+    path_prefix: /app/
+    split:
+      - upstream: default
+        weight: 95
+      - upstream: canary
+        weight: 5
+  - name: beta
+    path_prefix: /beta/
+    split:
+      - upstream: canary
+        weight: 1
+`)
+	app := newTestApp()
+	h, upstreams, err := app.setupRouting(Config{Confpath: path}, nil)
+	require.NoError(t, err)
+
+	changes, _, cancel := app.events.Subscribe(0, events.SplitChanged, events.SplitRemoved)
+	defer cancel()
+
+	// Canary is dropped from 'app', 'beta' is gone.
+	writeConfig(t, path, `
+servers: [`+a+`]
+upstreams:
+  canary:
+    servers: [`+b+`]
+routes:
+  - name: app
+    path_prefix: /app/
+    split:
+      - upstream: default
+        weight: 1
+`)
+	require.NoError(t, app.reloadRouting(path, upstreams))
+
+	e := <-changes
+	require.Equal(t, events.SplitChanged, e.Type)
+	require.Equal(t, map[string]int{"default": 1, "canary": 0}, e.Data["weights"])
+	e = <-changes
+	require.Equal(t, events.SplitRemoved, e.Type)
+	require.Equal(t, "beta", e.Data["route"])
+
+	// Dropped upstream gets no traffic at all.
+	for range 100 {
+		require.Equal(t, "a", get(t, h, "/app/x"))
+	}
+	require.NotContains(t, app.currentSplits(), "beta")
+}
+
+func TestReloadRouting_Protocol(t *testing.T) {
+	a := backend(t, "a")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, `
+servers: [`+a+`]
+upstreams:
+  api:
+    protocol: h2c
+    servers: [http://127.0.0.1:1]
+`)
+	app := newTestApp()
+	h, upstreams, err := app.setupRouting(Config{Confpath: path}, nil)
+	require.NoError(t, err)
+
+	// Protocol is kept from startup, new servers must match it.
+	writeConfig(t, path, `
+servers: [`+a+`]
+upstreams:
+  api:
+    servers: [https://127.0.0.1:2]
+`)
+	require.ErrorIs(t, app.reloadRouting(path, upstreams), proxy.ErrUpstreamProtocol)
+	require.Equal(t, "a", get(t, h, "/"))
+}
+
+func TestReload_ReportedOnce(t *testing.T) {
+	a, b := backend(t, "a"), backend(t, "b")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+servers: [` + a + `]
+upstreams:
+  api:
+    servers: [` + b + `]
+  web:
+    servers: [` + b + `]
+`
+	writeConfig(t, path, content)
+	app := newTestApp()
+	_, upstreams, err := app.setupRouting(Config{Confpath: path}, nil)
+	require.NoError(t, err)
+	require.NotContains(t, get(t, app.metrics.Handler(), "/metrics"), "lb_config_reloads_total")
+	require.Empty(t, app.events.Recent(16, events.ConfigReload))
+	// Pools of 'default', 'api' and 'web' groups.
+	require.Len(t, app.events.Recent(16, events.PoolUpdated), 3)
+
+	require.NoError(t, app.reload(path, upstreams))
+	require.Contains(t, get(t, app.metrics.Handler(), "/metrics"), `lb_config_reloads_total{result="success"} 1`)
+	reloads := app.events.Recent(16, events.ConfigReload)
+	require.Len(t, reloads, 1)
+	require.Equal(t, "success", reloads[0].Data["result"])
+	require.Len(t, app.events.Recent(16, events.PoolUpdated), 6)
+
+	rr := httptest.NewRecorder()
+	app.dashboard.Status(rr, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var status dashboard.StatusResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&status))
+	require.Equal(t, uint64(2), status.Config.Version)
+	require.Equal(t, 3, status.Config.Servers)
+}
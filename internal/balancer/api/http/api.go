@@ -0,0 +1,218 @@
+package api_balancer
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/router"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/stats"
+)
+
+type Pool interface {
+	All() []server.Server
+}
+
+/*
+Implemented by servers collecting runtime statistics.
+*/
+type snapshotter interface {
+	Snapshot() server.Snapshot
+}
+
+/*
+Weighted route, see router.Split.
+*/
+type Split interface {
+	Weights() []router.Weight
+	SetWeights(weights map[string]int) error
+}
+
+/*
+Listener is notified about weights changed through API.
+*/
+type Listener interface {
+	SplitChanged(route string, weights map[string]int)
+}
+
+type API struct {
+	// Keyed by upstream group name.
+	Pools map[string]Pool
+	// Keyed by route name, current set is asked on every request,
+	// since routes change on config reload.
+	Splits   func() map[string]Split
+	logger   *slog.Logger
+	listener Listener
+}
+
+type Option func(*API)
+
+func WithLogger(logger *slog.Logger) Option {
+	return func(a *API) {
+		a.logger = logger
+	}
+}
+
+func WithSplits(splits func() map[string]Split) Option {
+	return func(a *API) {
+		a.Splits = splits
+	}
+}
+
+func WithListener(l Listener) Option {
+	return func(a *API) {
+		a.listener = l
+	}
+}
+
+func New(pools map[string]Pool, opts ...Option) *API {
+	api := &API{
+		Pools:  pools,
+		logger: slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(api)
+	}
+	return api
+}
+
+type LatencyResponse struct {
+	P50     float64 `json:"p50_ms"`
+	P90     float64 `json:"p90_ms"`
+	P99     float64 `json:"p99_ms"`
+	Samples int     `json:"samples"`
+}
+
+type ServerResponse struct {
+	Upstream       string              `json:"upstream"`
+	URL            string              `json:"url"`
+	State          string              `json:"state"`
+	InFlight       int64               `json:"in_flight"`
+	Upgraded       int64               `json:"upgraded"`
+	Total          uint64              `json:"total"`
+	Errors         map[string]uint64   `json:"errors"`
+	Latency        LatencyResponse     `json:"latency"`
+	HealthCheck    *stats.HealthResult `json:"last_health_check"`
+	Ejections      uint64              `json:"ejections"`
+	StateChangedAt time.Time           `json:"state_changed_at"`
+	StateFor       float64             `json:"state_for_seconds"`
+}
+
+func (a *API) ListServers(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	resp := make([]ServerResponse, 0)
+	for upstream, pool := range a.Pools {
+		for _, s := range pool.All() {
+			sn, ok := s.(snapshotter)
+			if !ok {
+				continue
+			}
+			resp = append(resp, response(upstream, sn.Snapshot(), now))
+		}
+	}
+	sort.Slice(resp, func(i, j int) bool {
+		if resp[i].Upstream != resp[j].Upstream {
+			return resp[i].Upstream < resp[j].Upstream
+		}
+		return resp[i].URL < resp[j].URL
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		a.logger.Error("failed to encode servers", slog.Any("err", err))
+	}
+}
+
+func response(upstream string, snap server.Snapshot, now time.Time) ServerResponse {
+	return ServerResponse{
+		Upstream: upstream,
+		URL:      snap.URL,
+		State:    snap.State,
+		InFlight: snap.InFlight,
+		Upgraded: snap.Upgraded,
+		Total:    snap.Total,
+		Errors:   snap.Errors,
+		Latency: LatencyResponse{
+			P50:     ms(snap.Latency.P50),
+			P90:     ms(snap.Latency.P90),
+			P99:     ms(snap.Latency.P99),
+			Samples: snap.Latency.Samples,
+		},
+		HealthCheck:    snap.Health,
+		Ejections:      snap.Ejections,
+		StateChangedAt: snap.StateChangedAt,
+		StateFor:       now.Sub(snap.StateChangedAt).Seconds(),
+	}
+}
+
+type SplitResponse struct {
+	Route   string          `json:"route"`
+	Weights []router.Weight `json:"weights"`
+}
+
+type SplitRequest struct {
+	Route   string         `json:"route"`
+	Weights map[string]int `json:"weights"`
+}
+
+func (a *API) ListSplits(w http.ResponseWriter, r *http.Request) {
+	splits := a.splits()
+	resp := make([]SplitResponse, 0, len(splits))
+	for name, split := range splits {
+		resp = append(resp, SplitResponse{Route: name, Weights: split.Weights()})
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Route < resp[j].Route })
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		a.logger.Error("failed to encode splits", slog.Any("err", err))
+	}
+}
+
+/*
+Changes weights of given upstreams, others keep their current weight.
+*/
+func (a *API) SetSplit(w http.ResponseWriter, r *http.Request) {
+	var req SplitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.logger.Warn("invalid JSON on SetSplit", slog.Any("err", err))
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	split, ok := a.splits()[req.Route]
+	if !ok {
+		a.logger.Warn("unknown route on SetSplit", slog.String("route", req.Route))
+		http.Error(w, "route not found", http.StatusNotFound)
+		return
+	}
+	if len(req.Weights) == 0 {
+		http.Error(w, "missing weights", http.StatusBadRequest)
+		return
+	}
+
+	if err := split.SetWeights(req.Weights); err != nil {
+		a.logger.Warn("SetSplit failed", slog.String("route", req.Route), slog.Any("err", err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.logger.Info("split weights changed", slog.String("route", req.Route), slog.Any("weights", req.Weights))
+	if a.listener != nil {
+		a.listener.SplitChanged(req.Route, req.Weights)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *API) splits() map[string]Split {
+	if a.Splits == nil {
+		return nil
+	}
+	return a.Splits()
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -1,13 +1,36 @@
 package balancer
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
 	"log/slog"
 	"net"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/humanbelnik/load-balancer/internal/accesslog"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/proxy"
 	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+	"github.com/humanbelnik/load-balancer/internal/requestid"
 )
 
+const tracerName = "github.com/humanbelnik/load-balancer/internal/balancer/balancer"
+
+// Request body read by failed attempt is kept up to this size to be sent to the next server.
+const retryBodyLimit = 1 << 20
+
 /*
 Pool is responsible for giving alive servers for the future routing based
 on policy decision.
@@ -24,15 +47,73 @@ type Policy interface {
 	Select(servers []server.Server) (server.Server, error)
 }
 
+/*
+KeyedPolicy picks server by client IP, eg. consistent hashing.
+Used instead of Select if policy implements it.
+*/
+type KeyedPolicy interface {
+	SelectKey(servers []server.Server, key string) (server.Server, error)
+}
+
+/*
+RateLimiter decides whether client may send a request.
+Rejections of shadowed clients are only logged and counted.
+*/
 type RateLimiter interface {
 	Allow(ip string) bool
+	Shadow(ip string) bool
+}
+
+var (
+	ErrClientInFlight = errors.New("too many in-flight requests from client")
+	ErrInFlight       = errors.New("too many in-flight requests")
+)
+
+/*
+ConcurrencyLimiter caps requests being served at the same time.
+Release must be called once the response is finished.
+Fails with ErrClientInFlight or ErrInFlight.
+*/
+type ConcurrencyLimiter interface {
+	Acquire(ctx context.Context, key string) (release func(), err error)
+}
+
+/*
+Metrics is notified about finished requests and retries.
+Backend is empty if request never reached one.
+*/
+type Metrics interface {
+	RequestServed(backend, method string, code int)
+	Retry(backend string)
+}
+
+/*
+AccessLogger gets one record per finished request.
+*/
+type AccessLogger interface {
+	Log(rec accesslog.Record)
+}
+
+/*
+RequestIDResolver picks ID of incoming request.
+*/
+type RequestIDResolver interface {
+	Resolve(r *http.Request) string
 }
 
 type Balancer struct {
-	pool    Pool
-	policy  Policy
-	ratelim RateLimiter
-	logger  *slog.Logger
+	pool       Pool
+	policy     Policy
+	ratelim    RateLimiter
+	conclim    ConcurrencyLimiter
+	metrics    Metrics
+	tracer     trace.Tracer
+	accessLog  AccessLogger
+	requestIDs RequestIDResolver
+	logger     *slog.Logger
+
+	// Requests let through by shadow mode.
+	shadowRejected atomic.Uint64
 }
 
 type Option func(*Balancer)
@@ -43,6 +124,36 @@ func WithRateLimiter(rl RateLimiter) Option {
 	}
 }
 
+func WithConcurrencyLimiter(cl ConcurrencyLimiter) Option {
+	return func(b *Balancer) {
+		b.conclim = cl
+	}
+}
+
+func WithMetrics(m Metrics) Option {
+	return func(b *Balancer) {
+		b.metrics = m
+	}
+}
+
+func WithTracer(t trace.Tracer) Option {
+	return func(b *Balancer) {
+		b.tracer = t
+	}
+}
+
+func WithAccessLogger(l AccessLogger) Option {
+	return func(b *Balancer) {
+		b.accessLog = l
+	}
+}
+
+func WithRequestIDResolver(res RequestIDResolver) Option {
+	return func(b *Balancer) {
+		b.requestIDs = res
+	}
+}
+
 func WithLogger(logger *slog.Logger) Option {
 	return func(b *Balancer) {
 		b.logger = logger
@@ -58,7 +169,9 @@ func New(pool Pool, policy Policy, opts ...Option) *Balancer {
 		pool:   pool,
 		policy: policy,
 		// If not specified in functional options - use default
-		logger: slog.Default(),
+		logger:     slog.Default(),
+		tracer:     otel.Tracer(tracerName),
+		requestIDs: requestid.Default(),
 	}
 
 	for _, opt := range opts {
@@ -68,58 +181,393 @@ func New(pool Pool, policy Policy, opts ...Option) *Balancer {
 }
 
 func (b *Balancer) Serve(w http.ResponseWriter, r *http.Request) {
-	b.logger.Info("handling request", slog.String("method", r.Method), slog.String("url", r.URL.String()), slog.String("client", r.RemoteAddr))
-	if b.ratelim != nil && !b.HasTicket(w, r) {
-		return
+	start := time.Now()
+
+	// Proxy forwards it to backend, logger attaches it to every record.
+	id := b.requestIDs.Resolve(r)
+	w.Header().Set(requestid.Header, id)
+	ctx := requestid.NewContext(r.Context(), id)
+
+	// Continue trace of the caller, if any.
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+	ctx, span := b.tracer.Start(ctx, "balancer "+r.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+			attribute.String("lb.request_id", id),
+		),
+		trace.WithAttributes(clientAttributes(r)...),
+	)
+	r = r.WithContext(ctx)
+
+	body := &countingBody{ReadCloser: r.Body}
+	var replay *replayBody
+	if r.Body != nil && r.Body != http.NoBody {
+		replay = &replayBody{src: body, limit: retryBodyLimit}
+		r.Body = body
+	}
+
+	st := &requestState{}
+	rec := &statusRecorder{ResponseWriter: w}
+	w = rec
+	defer func() {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.status), attribute.String("lb.backend", st.backend))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+		span.End()
+
+		if b.metrics != nil {
+			b.metrics.RequestServed(st.backend, r.Method, rec.status)
+		}
+		if b.accessLog != nil {
+			b.accessLog.Log(accesslog.Record{
+				Time:      start,
+				RequestID: id,
+				ClientIP:  clientIP(r),
+				Method:    r.Method,
+				Host:      r.Host,
+				Path:      r.URL.Path,
+				URI:       r.URL.RequestURI(),
+				Proto:     r.Proto,
+				Status:    rec.status,
+				BytesIn:   body.n,
+				BytesOut:  rec.bytes,
+				Duration:  time.Since(start),
+				Upstream:  st.upstream,
+				Backend:   st.backend,
+				Attempts:  st.attempts,
+				RateLimit: st.rateLimit,
+				Referer:   r.Referer(),
+				UserAgent: r.UserAgent(),
+			})
+		}
+	}()
+
+	if b.ratelim != nil {
+		ok, decision := b.checkTicket(w, r)
+		st.rateLimit = decision
+		if !ok {
+			return
+		}
+	}
+	if b.conclim != nil {
+		release, ok := b.acquire(w, r)
+		if !ok {
+			return
+		}
+		defer release()
 	}
 
 	aliveServers, err := b.pool.Alive()
 	if err != nil {
-		b.logger.Error("no backends available", slog.Any("err", err))
-		http.Error(w, "no backends available", http.StatusServiceUnavailable)
+		b.logger.ErrorContext(r.Context(), "no backends available", slog.Any("err", err))
+		requestid.Error(w, r, "no backends available", http.StatusServiceUnavailable)
 		return
 	}
 
 	/*
 		Try in loop.
 		If choosen server gave 5xx (his problem) - retry with the next.
+		Same for failing gRPC status, unless it came after streamed response.
 	*/
-	for range aliveServers {
-		srv, err := b.policy.Select(aliveServers)
+	for attempt := range aliveServers {
+		req := r.WithContext(proxy.WithAttempt(r.Context(), attempt))
+		// Every server gets the whole body from its own reader.
+		if replay != nil {
+			body, ok := replay.next()
+			if !ok {
+				b.logger.WarnContext(r.Context(), "request body is too large to retry")
+				break
+			}
+			req.Body = body
+		}
+		srv, err := b.selectServer(aliveServers, r)
 		if err != nil {
-			b.logger.Error("policy selection failed", slog.Any("err", err))
-			http.Error(w, "policy error", http.StatusServiceUnavailable)
+			b.logger.ErrorContext(r.Context(), "policy selection failed", slog.Any("err", err))
+			requestid.Error(w, r, "policy error", http.StatusServiceUnavailable)
 			return
 		}
 
-		err = srv.Serve(w, r)
+		if attempt > 0 && b.metrics != nil {
+			b.metrics.Retry(srv.URL())
+		}
+		st.backend = srv.URL()
+		st.attempts++
+
+		upstreamStart := time.Now()
+		err = srv.Serve(w, req)
+		st.upstream += time.Since(upstreamStart)
 		if err == nil {
-			b.logger.Info("request served", slog.String("server", srv.URL()))
 			return
 		}
 
-		b.logger.Warn("backend failed", slog.String("server", srv.URL()), slog.Any("err", err))
+		b.logger.WarnContext(r.Context(), "backend failed", slog.String("server", srv.URL()), slog.Any("err", err))
+		// Client has got the response already, it can't be replaced.
+		if errors.Is(err, proxy.ErrResponseSent) {
+			return
+		}
+	}
+
+	if proxy.IsGRPC(r) {
+		proxy.GRPCUnavailable(w, "all backends failed")
+		return
+	}
+	requestid.Error(w, r, "all backends failed", http.StatusBadGateway)
+}
+
+func (b *Balancer) selectServer(servers []server.Server, r *http.Request) (server.Server, error) {
+	if kp, ok := b.policy.(KeyedPolicy); ok {
+		return kp.SelectKey(servers, clientIP(r))
+	}
+	return b.policy.Select(servers)
+}
+
+/*
+Per-request details, reported once the request is finished.
+*/
+type requestState struct {
+	backend   string
+	attempts  int
+	upstream  time.Duration
+	rateLimit string
+}
+
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+/*
+client.address and client.port of remote address, the latter is left out if it's missing.
+*/
+func clientAttributes(r *http.Request) []attribute.KeyValue {
+	host, port, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return []attribute.KeyValue{semconv.ClientAddress(r.RemoteAddr)}
 	}
+	attrs := []attribute.KeyValue{semconv.ClientAddress(host)}
+	if p, err := strconv.Atoi(port); err == nil {
+		attrs = append(attrs, semconv.ClientPort(p))
+	}
+	return attrs
+}
 
-	http.Error(w, "all backends failed", http.StatusBadGateway)
+/*
+Wrap concurrency limiter's work
+*/
+func (b *Balancer) acquire(w http.ResponseWriter, r *http.Request) (func(), bool) {
+	key := clientIP(r)
+	release, err := b.conclim.Acquire(r.Context(), key)
+	switch {
+	case err == nil:
+		return release, true
+	case errors.Is(err, ErrClientInFlight):
+		b.logger.InfoContext(r.Context(), "client in-flight limit reached", slog.String("ip", key), slog.Any("err", err))
+		requestid.Error(w, r, "too many concurrent requests", http.StatusTooManyRequests)
+	default:
+		b.logger.WarnContext(r.Context(), "in-flight limit reached", slog.Any("err", err))
+		requestid.Error(w, r, "server busy", http.StatusServiceUnavailable)
+	}
+	return nil, false
 }
 
 /*
 Wrap rate limiter's work
 */
 func (b *Balancer) HasTicket(w http.ResponseWriter, r *http.Request) bool {
+	ok, _ := b.checkTicket(w, r)
+	return ok
+}
+
+/*
+Same as HasTicket, also reports decision for the access log.
+*/
+func (b *Balancer) checkTicket(w http.ResponseWriter, r *http.Request) (bool, string) {
 	ip, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		b.logger.Warn("malformed remote addr", slog.String("remote", r.RemoteAddr), slog.Any("err", err))
-		http.Error(w, "incorrect request", http.StatusBadRequest)
-		return false
+		b.logger.WarnContext(r.Context(), "malformed remote addr", slog.String("remote", r.RemoteAddr), slog.Any("err", err))
+		requestid.Error(w, r, "incorrect request", http.StatusBadRequest)
+		return false, accesslog.RateLimitNone
 	}
 
 	if !b.ratelim.Allow(ip) {
-		b.logger.Info("rate limit exceeded", slog.String("ip", ip))
-		http.Error(w, "rate limit exeed", http.StatusTooManyRequests)
-		return false
+		if b.ratelim.Shadow(ip) {
+			b.shadowRejected.Add(1)
+			b.logger.InfoContext(r.Context(), "rate limit exceeded (shadow)", slog.String("ip", ip))
+			return true, accesslog.RateLimitShadow
+		}
+		b.logger.InfoContext(r.Context(), "rate limit exceeded", slog.String("ip", ip))
+		requestid.Error(w, r, "rate limit exeed", http.StatusTooManyRequests)
+		return false, accesslog.RateLimitDeny
+	}
+
+	return true, accesslog.RateLimitAllow
+}
+
+/*
+Number of requests that would have been rejected, if shadowed limits were enforced.
+*/
+func (b *Balancer) ShadowRejected() uint64 {
+	return b.shadowRejected.Load()
+}
+
+/*
+Remembers response status and size for metrics, tracing and access log.
+Unwrap keeps http.ResponseController (flush, hijack) working.
+*/
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+/*
+Protocol switch is written by proxy straight to connection.
+*/
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil && r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
 	}
+	return conn, brw, err
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+/*
+Keeps body read from client, so every attempt reads it from the start through its own reader.
+Transport of a failed attempt may still be reading when the next one starts: then it gets an error,
+and what it has read is left for the current attempt. Body larger than limit is not kept,
+attempt reading past it is the last one. Client body is closed by http.Server.
+*/
+type replayBody struct {
+	src   io.Reader
+	limit int
+
+	// Serializes reads of client body.
+	srcMu sync.Mutex
+
+	mu       sync.Mutex
+	buf      []byte
+	eof      bool
+	overflow bool
+	attempt  int
+}
+
+var errAttemptEnded = errors.New("request body of finished attempt")
+
+/*
+Body for the next attempt, false if part of body is lost.
+*/
+func (b *replayBody) next() (io.ReadCloser, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.overflow {
+		return nil, false
+	}
+	b.attempt++
+	return &attemptBody{b: b, attempt: b.attempt}, true
+}
+
+func (b *replayBody) read(a *attemptBody, p []byte) (int, error) {
+	if n, done, err := b.buffered(a, p); done {
+		return n, err
+	}
+	b.srcMu.Lock()
+	defer b.srcMu.Unlock()
+	// Another attempt may have read meanwhile.
+	if n, done, err := b.buffered(a, p); done {
+		return n, err
+	}
+
+	n, err := b.src.Read(p)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == io.EOF {
+		b.eof = true
+	}
+	if a.attempt != b.attempt {
+		// Left for the current attempt, at most one read per finished attempt is over limit.
+		b.buf = append(b.buf, p[:n]...)
+		return 0, errAttemptEnded
+	}
+	if !b.overflow && len(b.buf)+n > b.limit {
+		b.overflow = true
+		b.buf = nil
+		a.off = 0
+	}
+	if !b.overflow {
+		b.buf = append(b.buf, p[:n]...)
+		a.off = len(b.buf)
+	}
+	return n, err
+}
+
+/*
+Reads kept part of body, done is false if client body must be read.
+*/
+func (b *replayBody) buffered(a *attemptBody, p []byte) (int, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if a.attempt != b.attempt {
+		return 0, true, errAttemptEnded
+	}
+	if a.off < len(b.buf) {
+		n := copy(p, b.buf[a.off:])
+		a.off += n
+		return n, true, nil
+	}
+	if b.eof {
+		return 0, true, io.EOF
+	}
+	return 0, false, nil
+}
+
+type attemptBody struct {
+	b       *replayBody
+	attempt int
+	off     int
+}
+
+func (a *attemptBody) Read(p []byte) (int, error) {
+	return a.b.read(a, p)
+}
 
-	return true
+func (a *attemptBody) Close() error {
+	return nil
 }
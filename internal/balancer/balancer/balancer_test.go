@@ -1,16 +1,28 @@
 package balancer
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
+	"github.com/humanbelnik/load-balancer/internal/accesslog"
+	"github.com/humanbelnik/load-balancer/internal/accesslog/config"
+	balancer_config "github.com/humanbelnik/load-balancer/internal/balancer/config"
 	"github.com/humanbelnik/load-balancer/internal/balancer/mocks"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/proxy"
 	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+	"github.com/humanbelnik/load-balancer/internal/requestid"
+	requestid_config "github.com/humanbelnik/load-balancer/internal/requestid/config"
 )
 
 func makeMockServer(t *testing.T, code int, err error) *mocks.Server {
@@ -86,6 +98,27 @@ func TestBalancer_ServerError_5xx(t *testing.T) {
 	require.Equal(t, http.StatusBadGateway, rr.Code)
 }
 
+func TestBalancer_ResponseSent(t *testing.T) {
+	s := new(mocks.Server)
+	s.On("URL").Return("http://mock")
+	s.On("Serve", mock.Anything, mock.Anything).Return(fmt.Errorf("%w: %w", proxy.ErrGRPC, proxy.ErrResponseSent))
+
+	pool := new(mocks.Pool)
+	pool.On("Alive").Return([]server.Server{s, s}, nil)
+
+	policy := new(mocks.Policy)
+	policy.On("Select", mock.Anything).Return(s, nil)
+
+	b := New(pool, policy)
+
+	req := httptest.NewRequest("POST", "/", nil)
+	rr := httptest.NewRecorder()
+	b.Serve(rr, req)
+
+	s.AssertNumberOfCalls(t, "Serve", 1)
+	require.Zero(t, rr.Body.Len())
+}
+
 func TestBalancer_PoolError(t *testing.T) {
 	pool := new(mocks.Pool)
 	pool.On("Alive").Return(nil, errors.New("pool failure"))
@@ -118,3 +151,377 @@ func TestBalancer_PolicyError(t *testing.T) {
 
 	require.Equal(t, http.StatusServiceUnavailable, rr.Code)
 }
+
+type fakeLimiter struct {
+	allow  bool
+	shadow bool
+}
+
+func (f fakeLimiter) Allow(ip string) bool  { return f.allow }
+func (f fakeLimiter) Shadow(ip string) bool { return f.shadow }
+
+func TestBalancer_RateLimited(t *testing.T) {
+	pool := new(mocks.Pool)
+	policy := new(mocks.Policy)
+
+	b := New(pool, policy, WithRateLimiter(fakeLimiter{allow: false}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rr := httptest.NewRecorder()
+	b.Serve(rr, req)
+
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+	require.Zero(t, b.ShadowRejected())
+	pool.AssertNotCalled(t, "Alive")
+}
+
+func TestBalancer_RateLimitedShadow(t *testing.T) {
+	s := makeMockServer(t, http.StatusOK, nil)
+
+	pool := new(mocks.Pool)
+	pool.On("Alive").Return([]server.Server{s}, nil)
+
+	policy := new(mocks.Policy)
+	policy.On("Select", mock.Anything).Return(s, nil)
+
+	b := New(pool, policy, WithRateLimiter(fakeLimiter{allow: false, shadow: true}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rr := httptest.NewRecorder()
+	b.Serve(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.EqualValues(t, 1, b.ShadowRejected())
+}
+
+func TestBalancer_RequestIDOnErrorPage(t *testing.T) {
+	pool := new(mocks.Pool)
+	pool.On("Alive").Return(nil, errors.New("pool failure"))
+
+	b := New(pool, new(mocks.Policy))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", "spoofed")
+	rr := httptest.NewRecorder()
+	b.Serve(rr, req)
+
+	id := rr.Header().Get("X-Request-ID")
+	require.NotEmpty(t, id)
+	require.NotEqual(t, "spoofed", id)
+	require.Contains(t, rr.Body.String(), "request id: "+id)
+}
+
+func TestBalancer_AccessLog(t *testing.T) {
+	failing := new(mocks.Server)
+	failing.On("URL").Return("http://a")
+	failing.On("Serve", mock.Anything, mock.Anything).Return(proxy.ErrTransport)
+
+	ok := new(mocks.Server)
+	ok.On("URL").Return("http://b")
+	ok.On("Serve", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		w, r := args.Get(0).(http.ResponseWriter), args.Get(1).(*http.Request)
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("created"))
+	}).Return(nil)
+
+	pool := new(mocks.Pool)
+	pool.On("Alive").Return([]server.Server{failing, ok}, nil)
+	policy := new(mocks.Policy)
+	policy.On("Select", mock.Anything).Return(failing, nil).Once()
+	policy.On("Select", mock.Anything).Return(ok, nil).Once()
+
+	out := &bytes.Buffer{}
+	logger, err := accesslog.NewWriter(out, config.FormatJSON)
+	require.NoError(t, err)
+	b := New(pool, policy, WithAccessLogger(logger), WithRateLimiter(fakeLimiter{allow: true}))
+
+	req := httptest.NewRequest("POST", "http://example.com/items?page=2", strings.NewReader("payload"))
+	req.RemoteAddr = "203.0.113.7:5123"
+	req.Header.Set("User-Agent", "curl/8.0")
+	req.Header.Set("Referer", "http://example.com/")
+	rr := httptest.NewRecorder()
+	b.Serve(rr, req)
+
+	var got map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &got), out.String())
+	require.Equal(t, rr.Header().Get("X-Request-ID"), got["request_id"])
+	require.Equal(t, "203.0.113.7", got["client_ip"])
+	require.Equal(t, "POST", got["method"])
+	require.Equal(t, "example.com", got["host"])
+	require.Equal(t, "/items", got["path"])
+	require.Equal(t, "HTTP/1.1", got["proto"])
+	require.EqualValues(t, http.StatusCreated, got["status"])
+	require.EqualValues(t, len("payload"), got["bytes_in"])
+	require.EqualValues(t, len("created"), got["bytes_out"])
+	require.Equal(t, "http://b", got["backend"])
+	require.EqualValues(t, 2, got["attempts"])
+	require.Equal(t, accesslog.RateLimitAllow, got["rate_limit"])
+	require.Equal(t, "http://example.com/", got["referer"])
+	require.Equal(t, "curl/8.0", got["user_agent"])
+	require.Contains(t, got, "duration_ms")
+	require.Contains(t, got, "upstream_ms")
+}
+
+func TestBalancer_RequestIDPropagation(t *testing.T) {
+	got := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Get(requestid.Header)
+		// Balancer's ID wins over backend's one.
+		w.Header().Set(requestid.Header, "backend-id")
+	}))
+	defer backend.Close()
+
+	s, err := server.New(backend.URL)
+	require.NoError(t, err)
+	pool := new(mocks.Pool)
+	pool.On("Alive").Return([]server.Server{s}, nil)
+	policy := new(mocks.Policy)
+	policy.On("Select", mock.Anything).Return(s, nil)
+
+	res, err := requestid.New(requestid_config.RequestIDConfig{TrustIncoming: true})
+	require.NoError(t, err)
+	b := New(pool, policy, WithRequestIDResolver(res))
+
+	t.Run("generated", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		b.Serve(rr, httptest.NewRequest("GET", "/", nil))
+
+		id := rr.Header().Get(requestid.Header)
+		require.NotEmpty(t, id)
+		require.Equal(t, id, <-got)
+	})
+
+	t.Run("incoming", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(requestid.Header, "abc-1")
+		rr := httptest.NewRecorder()
+		b.Serve(rr, req)
+
+		require.Equal(t, "abc-1", <-got)
+		require.Equal(t, "abc-1", rr.Header().Get(requestid.Header))
+	})
+}
+
+/*
+Fake gRPC server reading whole request. Failing one answers trailers-only status,
+the other echoes request in a message with OK in trailers.
+*/
+func grpcBackend(t *testing.T, status string) server.Server {
+	t.Helper()
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", "application/grpc")
+		if status != "0" {
+			w.Header().Set("Grpc-Status", status)
+			return
+		}
+		w.Header().Set("Trailer", "Grpc-Status")
+		_, _ = w.Write(body)
+		w.Header().Set("Grpc-Status", status)
+	}))
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	backend.Config.Protocols = &protocols
+	backend.Start()
+	t.Cleanup(backend.Close)
+
+	tr, err := proxy.NewTransport(balancer_config.UpstreamConfig{Protocol: balancer_config.ProtocolH2C})
+	require.NoError(t, err)
+	s, err := server.New(backend.URL, server.WithProxyOptions(proxy.WithTransport(tr)))
+	require.NoError(t, err)
+	return s
+}
+
+func grpcCall(b *Balancer, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/svc/Method", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	rr := httptest.NewRecorder()
+	b.Serve(rr, req)
+	return rr
+}
+
+func TestBalancer_GRPCRetry(t *testing.T) {
+	failing, ok := grpcBackend(t, "14"), grpcBackend(t, "0")
+	pool := new(mocks.Pool)
+	pool.On("Alive").Return([]server.Server{failing, ok}, nil)
+	policy := new(mocks.Policy)
+	policy.On("Select", mock.Anything).Return(failing, nil).Once()
+	policy.On("Select", mock.Anything).Return(ok, nil).Once()
+	b := New(pool, policy)
+
+	// Second server gets the body read by the first one.
+	rr := grpcCall(b, "\x00\x00\x00\x00\x03abc")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "\x00\x00\x00\x00\x03abc", rr.Body.String())
+	require.Equal(t, "0", rr.Result().Trailer.Get("Grpc-Status"))
+}
+
+func TestBalancer_GRPCAllFailed(t *testing.T) {
+	first, second := grpcBackend(t, "14"), grpcBackend(t, "14")
+	pool := new(mocks.Pool)
+	pool.On("Alive").Return([]server.Server{first, second}, nil)
+	policy := new(mocks.Policy)
+	policy.On("Select", mock.Anything).Return(first, nil).Once()
+	policy.On("Select", mock.Anything).Return(second, nil).Once()
+	b := New(pool, policy)
+
+	rr := grpcCall(b, "\x00\x00\x00\x00\x00")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "14", rr.Header().Get("Grpc-Status"))
+	require.Equal(t, "application/grpc", rr.Header().Get("Content-Type"))
+	require.Zero(t, rr.Body.Len())
+	policy.AssertNumberOfCalls(t, "Select", 2)
+}
+
+func TestBalancer_RetryLargeBody(t *testing.T) {
+	failing := grpcBackend(t, "14")
+	pool := new(mocks.Pool)
+	pool.On("Alive").Return([]server.Server{failing, failing}, nil)
+	policy := new(mocks.Policy)
+	policy.On("Select", mock.Anything).Return(failing, nil)
+	b := New(pool, policy)
+
+	// Body is not kept, so it can't be sent again.
+	rr := grpcCall(b, strings.Repeat("x", retryBodyLimit+1))
+	require.Equal(t, "14", rr.Header().Get("Grpc-Status"))
+	policy.AssertNumberOfCalls(t, "Select", 1)
+}
+
+func TestBalancer_StreamedFailureNotRetried(t *testing.T) {
+	streaming := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "data: 1\n\n")
+	}))
+	defer streaming.Close()
+	var hits atomic.Int32
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer other.Close()
+
+	first, err := server.New(streaming.URL)
+	require.NoError(t, err)
+	second, err := server.New(other.URL)
+	require.NoError(t, err)
+	pool := new(mocks.Pool)
+	pool.On("Alive").Return([]server.Server{first, second}, nil)
+	policy := new(mocks.Policy)
+	policy.On("Select", mock.Anything).Return(first, nil).Once()
+	policy.On("Select", mock.Anything).Return(second, nil).Once()
+	b := New(pool, policy)
+
+	rr := httptest.NewRecorder()
+	b.Serve(rr, httptest.NewRequest("GET", "/events", nil))
+
+	require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	require.Equal(t, "data: 1\n\n", rr.Body.String())
+	require.Zero(t, hits.Load())
+	policy.AssertNumberOfCalls(t, "Select", 1)
+}
+
+func TestReplayBody_FinishedAttemptStillReading(t *testing.T) {
+	pr, pw := io.Pipe()
+	go pw.Write([]byte("ab"))
+	replay := &replayBody{src: pr, limit: retryBodyLimit}
+
+	first, ok := replay.next()
+	require.True(t, ok)
+	head := make([]byte, 2)
+	_, err := io.ReadFull(first, head)
+	require.NoError(t, err)
+	require.Equal(t, "ab", string(head))
+
+	// Transport of the failed attempt keeps reading while the next one starts.
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, first)
+		done <- err
+	}()
+	second, ok := replay.next()
+	require.True(t, ok)
+	go func() {
+		pw.Write([]byte("cd"))
+		pw.Write([]byte("ef"))
+		pw.Close()
+	}()
+	body, err := io.ReadAll(second)
+	require.NoError(t, err)
+	require.Equal(t, "abcdef", string(body))
+	require.ErrorIs(t, <-done, errAttemptEnded)
+}
+
+func TestReplayBody_Overflow(t *testing.T) {
+	replay := &replayBody{src: strings.NewReader("abcdef"), limit: 4}
+	first, ok := replay.next()
+	require.True(t, ok)
+	body, err := io.ReadAll(first)
+	require.NoError(t, err)
+	require.Equal(t, "abcdef", string(body))
+
+	_, ok = replay.next()
+	require.False(t, ok)
+}
+
+func TestBalancer_RetryAnswersOnce(t *testing.T) {
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(append([]byte("ok "), body...))
+	}))
+	defer ok.Close()
+
+	for name, failingURL := range map[string]string{"5xx": unavailable.URL, "unreachable": "http://127.0.0.1:1"} {
+		t.Run(name, func(t *testing.T) {
+			failing, err := server.New(failingURL)
+			require.NoError(t, err)
+			second, err := server.New(ok.URL)
+			require.NoError(t, err)
+			pool := new(mocks.Pool)
+			pool.On("Alive").Return([]server.Server{failing, second}, nil)
+			policy := new(mocks.Policy)
+			policy.On("Select", mock.Anything).Return(failing, nil).Once()
+			policy.On("Select", mock.Anything).Return(second, nil).Once()
+
+			// Real server, so a second WriteHeader or body would reach the client.
+			front := httptest.NewServer(http.HandlerFunc(New(pool, policy).Serve))
+			defer front.Close()
+			resp, err := http.Post(front.URL, "text/plain", strings.NewReader("payload"))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, "ok payload", string(body))
+			require.Empty(t, resp.Header.Get("Retry-After"))
+		})
+	}
+}
+
+func TestBalancer_AllFailedAnswersOnce(t *testing.T) {
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+	s, err := server.New(unavailable.URL)
+	require.NoError(t, err)
+	pool := new(mocks.Pool)
+	pool.On("Alive").Return([]server.Server{s, s}, nil)
+	policy := new(mocks.Policy)
+	policy.On("Select", mock.Anything).Return(s, nil)
+
+	rr := httptest.NewRecorder()
+	New(pool, policy).Serve(rr, httptest.NewRequest("GET", "/", nil))
+	require.Equal(t, http.StatusBadGateway, rr.Code)
+	require.Equal(t, 1, strings.Count(rr.Body.String(), "all backends failed"))
+	require.NotContains(t, rr.Body.String(), "unavailable")
+	policy.AssertNumberOfCalls(t, "Select", 2)
+}
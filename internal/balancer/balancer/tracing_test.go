@@ -0,0 +1,80 @@
+package balancer
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/mocks"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+)
+
+func recordSpans(t *testing.T) (*tracetest.SpanRecorder, trace.Tracer) {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	t.Cleanup(func() { _ = tp.Shutdown(t.Context()) })
+	return rec, tp.Tracer("test")
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestBalancer_Span(t *testing.T) {
+	rec, tracer := recordSpans(t)
+	s := makeMockServer(t, http.StatusOK, nil)
+	pool := new(mocks.Pool)
+	pool.On("Alive").Return([]server.Server{s}, nil)
+	policy := new(mocks.Policy)
+	policy.On("Select", mock.Anything).Return(s, nil)
+
+	b := New(pool, policy, WithTracer(tracer))
+	req := httptest.NewRequest("GET", "/users", nil)
+	req.RemoteAddr = "203.0.113.7:5123"
+	b.Serve(httptest.NewRecorder(), req)
+
+	spans := rec.Ended()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	require.Equal(t, "balancer GET", span.Name())
+	require.Equal(t, trace.SpanKindServer, span.SpanKind())
+	require.Equal(t, codes.Unset, span.Status().Code)
+
+	attrs := attributes(span)
+	require.Equal(t, "GET", attrs["http.request.method"].AsString())
+	require.Equal(t, "/users", attrs["url.path"].AsString())
+	require.Equal(t, "203.0.113.7", attrs["client.address"].AsString())
+	require.Equal(t, int64(5123), attrs["client.port"].AsInt64())
+	require.Equal(t, int64(http.StatusOK), attrs["http.response.status_code"].AsInt64())
+	require.Equal(t, "http://mock", attrs["lb.backend"].AsString())
+	require.NotEmpty(t, attrs["lb.request_id"].AsString())
+}
+
+func TestBalancer_SpanError(t *testing.T) {
+	rec, tracer := recordSpans(t)
+	pool := new(mocks.Pool)
+	pool.On("Alive").Return(nil, errors.New("pool failure"))
+
+	b := New(pool, new(mocks.Policy), WithTracer(tracer))
+	b.Serve(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+
+	spans := rec.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, "balancer POST", spans[0].Name())
+	require.Equal(t, codes.Error, spans[0].Status().Code)
+	require.Equal(t, int64(http.StatusServiceUnavailable), attributes(spans[0])["http.response.status_code"].AsInt64())
+}
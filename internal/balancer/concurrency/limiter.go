@@ -0,0 +1,148 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/balancer"
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+)
+
+var (
+	ErrQueueFull    = errors.New("queue is full")
+	ErrQueueTimeout = errors.New("queue timeout")
+)
+
+/*
+Per-client semaphore, removed once nobody holds or waits for it.
+*/
+type clientSlot struct {
+	sem  chan struct{}
+	refs int
+}
+
+/*
+Limiter caps in-flight requests per client and globally.
+Requests over the cap wait in a bounded queue or are rejected right away.
+*/
+type Limiter struct {
+	cfg config.ConcurrencyConfig
+
+	global chan struct{}
+
+	mu      sync.Mutex
+	clients map[string]*clientSlot
+
+	waiting atomic.Int64
+}
+
+func New(cfg config.ConcurrencyConfig) *Limiter {
+	l := &Limiter{
+		cfg:     cfg,
+		clients: make(map[string]*clientSlot),
+	}
+	if cfg.Global > 0 {
+		l.global = make(chan struct{}, cfg.Global)
+	}
+	return l
+}
+
+func (l *Limiter) Acquire(ctx context.Context, key string) (func(), error) {
+	var slot *clientSlot
+	if l.cfg.PerClient > 0 {
+		slot = l.ref(key)
+		if err := l.wait(ctx, slot.sem); err != nil {
+			l.unref(key)
+			return nil, fmt.Errorf("%w: %w", balancer.ErrClientInFlight, err)
+		}
+	}
+
+	if l.global != nil {
+		if err := l.wait(ctx, l.global); err != nil {
+			if slot != nil {
+				<-slot.sem
+				l.unref(key)
+			}
+			return nil, fmt.Errorf("%w: %w", balancer.ErrInFlight, err)
+		}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if l.global != nil {
+				<-l.global
+			}
+			if slot != nil {
+				<-slot.sem
+				l.unref(key)
+			}
+		})
+	}, nil
+}
+
+/*
+Takes a slot in semaphore, queueing if allowed.
+*/
+func (l *Limiter) wait(ctx context.Context, sem chan struct{}) error {
+	select {
+	case sem <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if l.waiting.Add(1) > int64(l.cfg.QueueSize) {
+		l.waiting.Add(-1)
+		return ErrQueueFull
+	}
+	defer l.waiting.Add(-1)
+
+	timer := time.NewTimer(l.cfg.QueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return ErrQueueTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *Limiter) ref(key string) *clientSlot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slot, ok := l.clients[key]
+	if !ok {
+		slot = &clientSlot{sem: make(chan struct{}, l.cfg.PerClient)}
+		l.clients[key] = slot
+	}
+	slot.refs++
+	return slot
+}
+
+func (l *Limiter) unref(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slot := l.clients[key]
+	slot.refs--
+	if slot.refs == 0 {
+		delete(l.clients, key)
+	}
+}
+
+/*
+Requests currently holding a global slot.
+*/
+func (l *Limiter) InFlight() int {
+	return len(l.global)
+}
+
+func (l *Limiter) Waiting() int {
+	return int(l.waiting.Load())
+}
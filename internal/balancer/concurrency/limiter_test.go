@@ -0,0 +1,73 @@
+package concurrency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/balancer"
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+)
+
+func TestLimiter_PerClient(t *testing.T) {
+	l := New(config.ConcurrencyConfig{PerClient: 1})
+
+	release, err := l.Acquire(context.Background(), "a")
+	require.NoError(t, err)
+
+	_, err = l.Acquire(context.Background(), "a")
+	require.ErrorIs(t, err, balancer.ErrClientInFlight)
+	require.ErrorIs(t, err, ErrQueueFull)
+
+	other, err := l.Acquire(context.Background(), "b")
+	require.NoError(t, err)
+	other()
+
+	release()
+	release()
+	again, err := l.Acquire(context.Background(), "a")
+	require.NoError(t, err)
+	again()
+	require.Empty(t, l.clients)
+}
+
+func TestLimiter_GlobalQueue(t *testing.T) {
+	l := New(config.ConcurrencyConfig{Global: 1, QueueSize: 1, QueueTimeout: time.Second})
+
+	release, err := l.Acquire(context.Background(), "a")
+	require.NoError(t, err)
+
+	acquired := make(chan error)
+	go func() {
+		r, err := l.Acquire(context.Background(), "b")
+		if err == nil {
+			r()
+		}
+		acquired <- err
+	}()
+
+	require.Eventually(t, func() bool { return l.Waiting() == 1 }, time.Second, time.Millisecond)
+
+	// Queue is full, third request is rejected right away.
+	_, err = l.Acquire(context.Background(), "c")
+	require.ErrorIs(t, err, balancer.ErrInFlight)
+	require.ErrorIs(t, err, ErrQueueFull)
+
+	release()
+	require.NoError(t, <-acquired)
+}
+
+func TestLimiter_QueueTimeout(t *testing.T) {
+	l := New(config.ConcurrencyConfig{Global: 1, PerClient: 5, QueueSize: 1, QueueTimeout: 10 * time.Millisecond})
+
+	release, err := l.Acquire(context.Background(), "a")
+	require.NoError(t, err)
+	defer release()
+
+	_, err = l.Acquire(context.Background(), "a")
+	require.ErrorIs(t, err, balancer.ErrInFlight)
+	require.ErrorIs(t, err, ErrQueueTimeout)
+	require.Equal(t, 1, l.clients["a"].refs)
+}
@@ -0,0 +1,17 @@
+package config
+
+import "time"
+
+type ConcurrencyConfig struct {
+	// Max in-flight requests per client IP, 0 disables the limit.
+	PerClient int `yaml:"per_client"`
+	// Max in-flight requests in total, 0 disables the limit.
+	Global int `yaml:"global"`
+	// Requests allowed to wait for a free slot, 0 rejects immediately.
+	QueueSize    int           `yaml:"queue_size"`
+	QueueTimeout time.Duration `yaml:"queue_timeout" env-default:"1s"`
+}
+
+func (c ConcurrencyConfig) Enabled() bool {
+	return c.PerClient > 0 || c.Global > 0
+}
@@ -0,0 +1,24 @@
+package config
+
+import "time"
+
+// Health check protocols.
+const (
+	HealthCheckHTTP = "http"
+	// grpc.health.v1.Health/Check, servers must speak HTTP/2.
+	HealthCheckGRPC = "grpc"
+	// Connect only, for 'tcp://' servers.
+	HealthCheckTCP = "tcp"
+)
+
+type HealthCheckConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 'http' if empty, 'grpc' or 'tcp'.
+	Type string `yaml:"type"`
+	// Probed with GET, 2xx and 3xx mean healthy.
+	Path string `yaml:"path" env-default:"/"`
+	// gRPC service to check, whole server if empty.
+	Service  string        `yaml:"service"`
+	Interval time.Duration `yaml:"interval" env-default:"10s"`
+	Timeout  time.Duration `yaml:"timeout" env-default:"2s"`
+}
@@ -0,0 +1,9 @@
+package config
+
+type HTTP2Config struct {
+	// Cleartext HTTP/2 on main listener, both with prior knowledge and 'Upgrade: h2c'.
+	H2C bool `yaml:"h2c"`
+	// HTTP/2 is negotiated on TLS listener unless disabled.
+	DisableTLS           bool   `yaml:"disable_tls"`
+	MaxConcurrentStreams uint32 `yaml:"max_concurrent_streams" env-default:"250"`
+}
@@ -0,0 +1,16 @@
+package config
+
+import "time"
+
+/*
+PROXY protocol on incoming connections, eg. behind L4 load balancer.
+Header gives real client address to rate limiter, logs and servers.
+*/
+type ProxyProtocolConfig struct {
+	// Main and HTTPS listeners, TCP ones opt in with 'accept_proxy_protocol'.
+	Enabled bool `yaml:"enabled" env-default:"false"`
+	// CIDRs or addresses which must send header, it's ignored from others.
+	Trusted []string `yaml:"trusted"`
+	// Trusted connection is closed if header is not received in time.
+	HeaderTimeout time.Duration `yaml:"header_timeout" env-default:"5s"`
+}
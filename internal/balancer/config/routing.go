@@ -0,0 +1,147 @@
+package config
+
+import "time"
+
+// Upstream group built from top-level 'servers' list.
+const DefaultUpstream = "default"
+
+const (
+	PolicyRoundRobin = "round_robin"
+	// Fewest in-flight requests and upgraded connections.
+	PolicyLeastConnections = "least_connections"
+	// Same client address goes to the same server.
+	PolicyConsistentHash = "consistent_hash"
+)
+
+// Protocols spoken to servers of upstream group.
+const (
+	ProtocolHTTP1 = "http1"
+	// Over TLS only, for 'https://' servers.
+	ProtocolHTTP2 = "http2"
+	// Cleartext HTTP/2 with prior knowledge, for 'http://' servers.
+	ProtocolH2C = "h2c"
+)
+
+type UpstreamConfig struct {
+	Servers []string `yaml:"servers"`
+	// 'round_robin' (default), 'least_connections' or 'consistent_hash'.
+	Policy string `yaml:"policy"`
+	// Global health check settings are used if not set.
+	HealthCheck *HealthCheckConfig `yaml:"health_check"`
+	// Global concurrency limiter is shared with group if not set.
+	Concurrency *ConcurrencyConfig `yaml:"concurrency"`
+	// Used for 'https://' servers.
+	TLS *UpstreamTLSConfig `yaml:"tls"`
+	// If empty, HTTP/1.1 is used, or HTTP/2 when negotiated over TLS.
+	Protocol string `yaml:"protocol"`
+	// 'v1' or 'v2' header with client address is sent on every connection to servers.
+	// HTTP connections are not reused then, one client per connection.
+	ProxyProtocol string `yaml:"proxy_protocol"`
+}
+
+type UpstreamTLSConfig struct {
+	// Servers are verified against system roots if empty.
+	CAFile string `yaml:"ca_file"`
+	// Client certificate for mutual TLS.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// SNI and verified name, host of server URL if empty.
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+/*
+RouteConfig matches request if every given condition holds.
+*/
+type RouteConfig struct {
+	Name string `yaml:"name"`
+	// Higher goes first, routes with equal priority keep config order.
+	Priority int `yaml:"priority"`
+	// Exact ('api.example.com') or wildcard ('*.example.com') hosts.
+	Hosts      []string `yaml:"hosts"`
+	PathPrefix string   `yaml:"path_prefix"`
+	PathRegex  string   `yaml:"path_regex"`
+	Methods    []string `yaml:"methods"`
+	// 'http' or 'https'.
+	Scheme string `yaml:"scheme"`
+	// Empty value only requires header to be present.
+	Headers map[string]string `yaml:"headers"`
+	// Exactly one of: single upstream, split between several ones or redirect.
+	Upstream string          `yaml:"upstream"`
+	Split    []SplitConfig   `yaml:"split"`
+	Sticky   *StickyConfig   `yaml:"sticky"`
+	Redirect *RedirectConfig `yaml:"redirect"`
+
+	// Applied to path and host sent to backend, or to redirect location.
+	Rewrite RewriteConfig `yaml:"rewrite"`
+
+	// Applied to request before proxying and to backend response.
+	RequestHeaders  HeaderRulesConfig `yaml:"request_headers"`
+	ResponseHeaders HeaderRulesConfig `yaml:"response_headers"`
+
+	// How often response is flushed to client, negative flushes after every write.
+	// If 0, only streamed (no Content-Length) and 'text/event-stream' responses are flushed, immediately.
+	FlushInterval time.Duration `yaml:"flush_interval"`
+}
+
+/*
+Path is rewritten in order: strip prefix, regex, add prefix.
+*/
+type RewriteConfig struct {
+	StripPrefix string `yaml:"strip_prefix"`
+	AddPrefix   string `yaml:"add_prefix"`
+	// Replacement may refer to capture groups, eg. '/users/$1'.
+	Regex       string `yaml:"regex"`
+	Replacement string `yaml:"replacement"`
+	// Host header sent to backend.
+	Host string `yaml:"host"`
+}
+
+/*
+Redirect is answered by balancer itself. Empty fields keep values of request,
+path is rewritten by route rewrite rules. Query is always kept.
+*/
+type RedirectConfig struct {
+	// 301, 302, 307 or 308, 302 if empty.
+	Code   int    `yaml:"code"`
+	Scheme string `yaml:"scheme"`
+	Host   string `yaml:"host"`
+	Path   string `yaml:"path"`
+}
+
+/*
+Applied in order: remove, rename, set, add.
+Values of set and add may refer to variables, eg. '${client_ip}'.
+*/
+type HeaderRulesConfig struct {
+	Add    map[string]string `yaml:"add"`
+	Set    map[string]string `yaml:"set"`
+	Remove []string          `yaml:"remove"`
+	// Applied in order, so one header may be renamed twice.
+	Rename []RenameConfig `yaml:"rename"`
+}
+
+type RenameConfig struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+type SplitConfig struct {
+	Upstream string `yaml:"upstream"`
+	// Relative to other weights of the route, eg. 95 and 5.
+	Weight int `yaml:"weight"`
+}
+
+/*
+Requests with the same header or cookie value go to the same upstream,
+while weights stay unchanged. Header is checked first.
+*/
+type StickyConfig struct {
+	Header string `yaml:"header"`
+	Cookie string `yaml:"cookie"`
+}
+
+type RoutingConfig struct {
+	Upstreams map[string]UpstreamConfig `yaml:"upstreams"`
+	Routes    []RouteConfig             `yaml:"routes"`
+}
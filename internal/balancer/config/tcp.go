@@ -0,0 +1,21 @@
+package config
+
+import "time"
+
+const DefaultConnectTimeout = 5 * time.Second
+
+/*
+Listener proxying raw TCP connections to servers of upstream group.
+*/
+type TCPConfig struct {
+	Name   string `yaml:"name"`
+	Listen string `yaml:"listen"`
+	// Group servers are 'tcp://host:port'.
+	Upstream string `yaml:"upstream"`
+	// DefaultConnectTimeout if 0, next server is tried after timeout.
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	// Closed without traffic in both directions for that long, 0 disables.
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	// Expect PROXY protocol header from trusted sources of 'proxy_protocol' section.
+	AcceptProxyProtocol bool `yaml:"accept_proxy_protocol"`
+}
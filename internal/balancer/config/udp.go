@@ -0,0 +1,19 @@
+package config
+
+import "time"
+
+const DefaultSessionTimeout = 30 * time.Second
+
+/*
+Listener forwarding UDP datagrams to servers of upstream group.
+Datagrams from one client address form a session bound to one server.
+*/
+type UDPConfig struct {
+	Name   string `yaml:"name"`
+	Listen string `yaml:"listen"`
+	// Group servers are 'udp://host:port'.
+	Upstream string `yaml:"upstream"`
+	// Session is dropped after no datagrams in both directions for that long,
+	// DefaultSessionTimeout if 0.
+	SessionTimeout time.Duration `yaml:"session_timeout"`
+}
@@ -0,0 +1,11 @@
+package config
+
+import "time"
+
+/*
+Connections switched to another protocol (WebSocket) after 'Upgrade' request.
+*/
+type UpgradeConfig struct {
+	// Closed without traffic in both directions for that long, 0 disables.
+	IdleTimeout time.Duration `yaml:"idle_timeout" env-default:"10m"`
+}
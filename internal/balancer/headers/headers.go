@@ -0,0 +1,324 @@
+package headers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	"github.com/humanbelnik/load-balancer/internal/requestid"
+)
+
+var (
+	ErrBadTemplate = errors.New("bad header template")
+)
+
+/*
+Vars are values available to header templates.
+*/
+type Vars struct {
+	ClientIP  string
+	RequestID string
+	Backend   string
+	Host      string
+	Path      string
+	Method    string
+	Time      time.Time
+}
+
+func RequestVars(r *http.Request, requestID, backend string) Vars {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return Vars{
+		ClientIP:  ip,
+		RequestID: requestID,
+		Backend:   backend,
+		Host:      r.Host,
+		Path:      r.URL.Path,
+		Method:    r.Method,
+		Time:      time.Now(),
+	}
+}
+
+var variables = map[string]func(v *Vars) string{
+	"client_ip":    func(v *Vars) string { return v.ClientIP },
+	"request_id":   func(v *Vars) string { return v.RequestID },
+	"backend":      func(v *Vars) string { return v.Backend },
+	"host":         func(v *Vars) string { return v.Host },
+	"path":         func(v *Vars) string { return v.Path },
+	"method":       func(v *Vars) string { return v.Method },
+	"time_unix":    func(v *Vars) string { return strconv.FormatInt(v.Time.Unix(), 10) },
+	"time_unix_ms": func(v *Vars) string { return strconv.FormatInt(v.Time.UnixMilli(), 10) },
+	"time_rfc3339": func(v *Vars) string { return v.Time.UTC().Format(time.RFC3339) },
+	"time_http":    func(v *Vars) string { return v.Time.UTC().Format(http.TimeFormat) },
+}
+
+/*
+Template is a header value with '${name}' variables. '$$' stands for a single '$'.
+*/
+type Template struct {
+	// Literal text and variables, variables have non-nil resolver.
+	parts []part
+}
+
+type part struct {
+	text    string
+	resolve func(v *Vars) string
+}
+
+func ParseTemplate(s string) (Template, error) {
+	var t Template
+	var literal strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			literal.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '$' {
+			literal.WriteByte('$')
+			i++
+			continue
+		}
+		if i+1 >= len(s) || s[i+1] != '{' {
+			return Template{}, fmt.Errorf("%w: %q: '$' must be followed by '{' or '$'", ErrBadTemplate, s)
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return Template{}, fmt.Errorf("%w: %q: unclosed '${'", ErrBadTemplate, s)
+		}
+		name := s[i+2 : i+end]
+		resolve, ok := variables[name]
+		if !ok {
+			return Template{}, fmt.Errorf("%w: %q: unknown variable %q", ErrBadTemplate, s, name)
+		}
+		if literal.Len() > 0 {
+			t.parts = append(t.parts, part{text: literal.String()})
+			literal.Reset()
+		}
+		t.parts = append(t.parts, part{resolve: resolve})
+		i += end
+	}
+	if literal.Len() > 0 {
+		t.parts = append(t.parts, part{text: literal.String()})
+	}
+	return t, nil
+}
+
+func (t Template) Execute(v *Vars) string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.resolve != nil {
+			b.WriteString(p.resolve(v))
+		} else {
+			b.WriteString(p.text)
+		}
+	}
+	return b.String()
+}
+
+type valueRule struct {
+	name  string
+	value Template
+}
+
+type renameRule struct {
+	from string
+	to   string
+}
+
+/*
+Rules modify a set of headers.
+*/
+type Rules struct {
+	remove []string
+	rename []renameRule
+	set    []valueRule
+	add    []valueRule
+}
+
+func Compile(cfg config.HeaderRulesConfig) (*Rules, error) {
+	r := &Rules{}
+	for _, name := range cfg.Remove {
+		r.remove = append(r.remove, http.CanonicalHeaderKey(name))
+	}
+	for _, rn := range cfg.Rename {
+		r.rename = append(r.rename, renameRule{from: http.CanonicalHeaderKey(rn.From), to: http.CanonicalHeaderKey(rn.To)})
+	}
+
+	var err error
+	if r.set, err = compileValues(cfg.Set); err != nil {
+		return nil, err
+	}
+	if r.add, err = compileValues(cfg.Add); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+/*
+Sorted by name, so result doesn't depend on map order.
+*/
+func compileValues(values map[string]string) ([]valueRule, error) {
+	rules := make([]valueRule, 0, len(values))
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		t, err := ParseTemplate(values[name])
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, valueRule{name: http.CanonicalHeaderKey(name), value: t})
+	}
+	return rules, nil
+}
+
+func (r *Rules) Empty() bool {
+	return len(r.remove) == 0 && len(r.rename) == 0 && len(r.set) == 0 && len(r.add) == 0
+}
+
+func (r *Rules) Apply(h http.Header, v *Vars) {
+	for _, name := range r.remove {
+		h.Del(name)
+	}
+	for _, rr := range r.rename {
+		if values, ok := h[rr.from]; ok {
+			h.Del(rr.from)
+			h[rr.to] = append(h[rr.to], values...)
+		}
+	}
+	for _, s := range r.set {
+		h.Set(s.name, s.value.Execute(v))
+	}
+	for _, a := range r.add {
+		h.Add(a.name, a.value.Execute(v))
+	}
+}
+
+/*
+RouteRules are header rules of a route. Request ones are passed to proxy in request context,
+response ones are applied by ResponseWriter.
+*/
+type RouteRules struct {
+	Request  *Rules
+	Response *Rules
+}
+
+func CompileRoute(cfg config.RouteConfig) (*RouteRules, error) {
+	req, err := Compile(cfg.RequestHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("request headers: %w", err)
+	}
+	resp, err := Compile(cfg.ResponseHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("response headers: %w", err)
+	}
+	if req.Empty() && resp.Empty() {
+		return nil, nil
+	}
+	return &RouteRules{Request: req, Response: resp}, nil
+}
+
+type rulesKey struct{}
+
+func NewContext(ctx context.Context, rules *RouteRules) context.Context {
+	return context.WithValue(ctx, rulesKey{}, rules)
+}
+
+func FromContext(ctx context.Context) *RouteRules {
+	rules, _ := ctx.Value(rulesKey{}).(*RouteRules)
+	return rules
+}
+
+/*
+ResponseWriter applies response rules to headers once, right before they are written,
+whoever writes the response: server through proxy or balancer itself (errors, redirects).
+Set replaces values copied from server response, as they are already in the header map.
+*/
+type ResponseWriter struct {
+	http.ResponseWriter
+	rules *Rules
+	r     *http.Request
+
+	mu      sync.Mutex
+	backend string
+	applied bool
+}
+
+func NewResponseWriter(w http.ResponseWriter, r *http.Request, rules *Rules) *ResponseWriter {
+	return &ResponseWriter{ResponseWriter: w, r: r, rules: rules}
+}
+
+/*
+Server of the last attempt, for ${backend}.
+*/
+func (w *ResponseWriter) SetBackend(backend string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.backend = backend
+}
+
+func (w *ResponseWriter) apply() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.applied {
+		return
+	}
+	w.applied = true
+	// Balancer sets request ID on every response before it is written.
+	h := w.ResponseWriter.Header()
+	vars := RequestVars(w.r, h.Get(requestid.Header), w.backend)
+	w.rules.Apply(h, &vars)
+}
+
+func (w *ResponseWriter) WriteHeader(code int) {
+	// Informational responses are followed by the final one.
+	if code >= 200 || code == http.StatusSwitchingProtocols {
+		w.apply()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *ResponseWriter) Write(b []byte) (int, error) {
+	w.apply()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *ResponseWriter) FlushError() error {
+	w.apply()
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+/*
+Switching protocols response is written by proxy after hijacking, from the same header map.
+*/
+func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.apply()
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type responseKey struct{}
+
+/*
+Lets proxy report backend to ResponseWriter of the route.
+*/
+func NewResponseContext(ctx context.Context, w *ResponseWriter) context.Context {
+	return context.WithValue(ctx, responseKey{}, w)
+}
+
+func ResponseFromContext(ctx context.Context) *ResponseWriter {
+	w, _ := ctx.Value(responseKey{}).(*ResponseWriter)
+	return w
+}
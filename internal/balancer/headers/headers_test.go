@@ -0,0 +1,105 @@
+package headers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+)
+
+func TestTemplate(t *testing.T) {
+	v := &Vars{ClientIP: "10.0.0.1", RequestID: "abc", Time: time.Unix(1700000000, 0)}
+
+	tmpl, err := ParseTemplate("for=${client_ip};id=${request_id};t=${time_unix};cost=$$5")
+	require.NoError(t, err)
+	require.Equal(t, "for=10.0.0.1;id=abc;t=1700000000;cost=$5", tmpl.Execute(v))
+
+	for _, bad := range []string{"${unknown}", "${client_ip", "$x"} {
+		_, err := ParseTemplate(bad)
+		require.ErrorIs(t, err, ErrBadTemplate, bad)
+	}
+}
+
+func TestRules_Apply(t *testing.T) {
+	rules, err := Compile(config.HeaderRulesConfig{
+		Add:    map[string]string{"x-forwarded-prefix": "/api"},
+		Set:    map[string]string{"X-Backend": "${backend}"},
+		Remove: []string{"x-internal-token"},
+		Rename: []config.RenameConfig{{From: "X-Old", To: "X-New"}},
+	})
+	require.NoError(t, err)
+
+	h := http.Header{}
+	h.Set("X-Internal-Token", "secret")
+	h.Set("X-Old", "1")
+	h.Set("X-Backend", "spoofed")
+	rules.Apply(h, &Vars{Backend: "http://b:80"})
+
+	require.Empty(t, h.Get("X-Internal-Token"))
+	require.Empty(t, h.Get("X-Old"))
+	require.Equal(t, "1", h.Get("X-New"))
+	require.Equal(t, []string{"http://b:80"}, h.Values("X-Backend"))
+	require.Equal(t, "/api", h.Get("X-Forwarded-Prefix"))
+}
+
+func TestCompileRoute_Empty(t *testing.T) {
+	rules, err := CompileRoute(config.RouteConfig{})
+	require.NoError(t, err)
+	require.Nil(t, rules)
+}
+
+func TestRules_RenameOrder(t *testing.T) {
+	rules, err := Compile(config.HeaderRulesConfig{
+		Rename: []config.RenameConfig{{From: "X-A", To: "X-B"}, {From: "X-B", To: "X-C"}},
+	})
+	require.NoError(t, err)
+
+	for range 10 {
+		h := http.Header{}
+		h.Set("X-A", "1")
+		rules.Apply(h, &Vars{})
+		require.Equal(t, http.Header{"X-C": {"1"}}, h)
+	}
+}
+
+func TestResponseWriter(t *testing.T) {
+	rules, err := Compile(config.HeaderRulesConfig{
+		Set:    map[string]string{"Server": "lb", "X-Request-Id-Copy": "${request_id}", "X-Backend": "${backend}"},
+		Remove: []string{"X-Powered-By"},
+	})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	w := NewResponseWriter(rec, httptest.NewRequest(http.MethodGet, "/", nil), rules)
+	w.SetBackend("http://b:80")
+	// Proxy adds server headers onto balancer ones.
+	w.Header().Set("X-Request-Id", "abc")
+	w.Header().Add("Server", "nginx")
+	w.Header().Add("X-Powered-By", "php")
+
+	w.WriteHeader(http.StatusBadGateway)
+	w.Header().Set("Server", "late")
+	_, err = w.Write([]byte("x"))
+	require.NoError(t, err)
+
+	require.Equal(t, http.StatusBadGateway, rec.Code)
+	require.Equal(t, []string{"lb"}, rec.Result().Header.Values("Server"))
+	require.Empty(t, rec.Result().Header.Get("X-Powered-By"))
+	require.Equal(t, "abc", rec.Result().Header.Get("X-Request-Id-Copy"))
+	require.Equal(t, "http://b:80", rec.Result().Header.Get("X-Backend"))
+}
+
+func TestResponseWriter_Flush(t *testing.T) {
+	rules, err := Compile(config.HeaderRulesConfig{Set: map[string]string{"X-Route": "api"}})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	w := NewResponseWriter(rec, httptest.NewRequest(http.MethodGet, "/", nil), rules)
+	require.NoError(t, http.NewResponseController(w).Flush())
+	require.True(t, rec.Flushed)
+	require.Equal(t, "api", rec.Result().Header.Get("X-Route"))
+}
@@ -0,0 +1,206 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+)
+
+var (
+	ErrUnhealthy   = errors.New("unhealthy response")
+	ErrUnknownType = errors.New("unknown health check type")
+)
+
+type Pool interface {
+	All() []server.Server
+}
+
+/*
+Prober checks a single server, nil error means healthy.
+*/
+type Prober interface {
+	Probe(ctx context.Context, s server.Server) error
+}
+
+/*
+Implemented by servers keeping last health check result.
+*/
+type recorder interface {
+	RecordHealthCheck(ok bool, err error)
+}
+
+/*
+Checker periodically probes every server in the pool,
+ejects failing ones and brings recovered ones back.
+*/
+type Checker struct {
+	pool   Pool
+	prober Prober
+	cfg    config.HealthCheckConfig
+	logger *slog.Logger
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+type Option func(*Checker)
+
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Checker) {
+		c.logger = logger
+	}
+}
+
+func WithProber(p Prober) Option {
+	return func(c *Checker) {
+		c.prober = p
+	}
+}
+
+func New(pool Pool, cfg config.HealthCheckConfig, opts ...Option) *Checker {
+	c := &Checker{
+		pool:   pool,
+		cfg:    cfg,
+		prober: NewHTTPProber(cfg.Path, nil),
+		logger: slog.Default(),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *Checker) Start() {
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(c.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.CheckAll(context.Background())
+			case <-c.quit:
+				return
+			}
+		}
+	}()
+}
+
+func (c *Checker) Close() error {
+	close(c.quit)
+	<-c.done
+	return nil
+}
+
+/*
+Probes every server concurrently and waits for results.
+*/
+func (c *Checker) CheckAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, s := range c.pool.All() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.check(ctx, s)
+		}()
+	}
+	wg.Wait()
+}
+
+func (c *Checker) check(ctx context.Context, s server.Server) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	err := c.prober.Probe(ctx, s)
+	ok := err == nil
+	if r, isRecorder := s.(recorder); isRecorder {
+		r.RecordHealthCheck(ok, err)
+	}
+
+	if was := s.IsAlive(); was != ok {
+		c.logger.Info("server health changed", slog.String("server", s.URL()), slog.Bool("alive", ok), slog.Any("err", err))
+	}
+	s.SetAlive(ok)
+}
+
+/*
+Prober for health check type, transport is shared with proxy.
+*/
+func NewProber(cfg config.HealthCheckConfig, transport http.RoundTripper) (Prober, error) {
+	switch cfg.Type {
+	case config.HealthCheckHTTP, "":
+		return NewHTTPProber(cfg.Path, transport), nil
+	case config.HealthCheckGRPC:
+		return NewGRPCProber(cfg.Service, transport), nil
+	case config.HealthCheckTCP:
+		return NewTCPProber(), nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownType, cfg.Type)
+}
+
+/*
+HTTPProber sends GET to server URL joined with path.
+*/
+type HTTPProber struct {
+	path   string
+	client *http.Client
+}
+
+/*
+Transport should be the one used for proxying, so TLS settings are the same.
+Default one is used if nil.
+*/
+func NewHTTPProber(path string, transport http.RoundTripper) *HTTPProber {
+	return &HTTPProber{
+		path:   path,
+		client: &http.Client{Transport: transport},
+	}
+}
+
+func (p *HTTPProber) Probe(ctx context.Context, s server.Server) error {
+	target, err := probeURL(s.URL(), p.path)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("%w: %d", ErrUnhealthy, resp.StatusCode)
+	}
+	return nil
+}
+
+/*
+Path is joined to server URL path, query of the path is kept.
+*/
+func probeURL(serverURL, path string) (string, error) {
+	base, err := url.Parse(serverURL)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(path)
+	if err != nil {
+		return "", err
+	}
+	u := base.JoinPath(ref.Path)
+	u.RawQuery = ref.RawQuery
+	return u.String(), nil
+}
@@ -0,0 +1,146 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+)
+
+type staticPool []server.Server
+
+func (p staticPool) All() []server.Server {
+	return p
+}
+
+/*
+Healthy unless server URL is marked as failing.
+*/
+type fakeProber struct {
+	mu      sync.Mutex
+	failing map[string]bool
+}
+
+func (p *fakeProber) set(url string, failing bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failing[url] = failing
+}
+
+func (p *fakeProber) Probe(ctx context.Context, s server.Server) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failing[s.URL()] {
+		return ErrUnhealthy
+	}
+	return nil
+}
+
+func newServer(t *testing.T, rawURL string) *server.ServerInst {
+	t.Helper()
+	s, err := server.New(rawURL)
+	require.NoError(t, err)
+	return s
+}
+
+func TestChecker_StateTransitions(t *testing.T) {
+	a, b := newServer(t, "http://a"), newServer(t, "http://b")
+	prober := &fakeProber{failing: map[string]bool{}}
+	c := New(staticPool{a, b}, config.HealthCheckConfig{Timeout: time.Second}, WithProber(prober))
+
+	// alive -> ejected
+	prober.set("http://b", true)
+	c.CheckAll(context.Background())
+	require.True(t, a.IsAlive())
+	require.False(t, b.IsAlive())
+	require.Equal(t, server.StateEjected, b.Snapshot().State)
+	require.Equal(t, uint64(1), b.Ejections())
+	require.False(t, b.Snapshot().Health.OK)
+
+	// Still failing, not ejected once more.
+	c.CheckAll(context.Background())
+	require.Equal(t, uint64(1), b.Ejections())
+
+	// ejected -> alive
+	prober.set("http://b", false)
+	c.CheckAll(context.Background())
+	require.True(t, b.IsAlive())
+	require.Equal(t, server.StateAlive, b.Snapshot().State)
+	require.True(t, b.Snapshot().Health.OK)
+
+	// Ejected by proxy errors, brought back by health check.
+	a.SetAlive(false)
+	c.CheckAll(context.Background())
+	require.True(t, a.IsAlive())
+}
+
+func TestChecker_Timeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+
+	s := newServer(t, slow.URL)
+	c := New(staticPool{s}, config.HealthCheckConfig{Path: "/", Timeout: 50 * time.Millisecond})
+	c.CheckAll(context.Background())
+	require.False(t, s.IsAlive())
+	require.Contains(t, s.Snapshot().Health.Error, context.DeadlineExceeded.Error())
+}
+
+func TestChecker_Start(t *testing.T) {
+	s := newServer(t, "http://a")
+	prober := &fakeProber{failing: map[string]bool{"http://a": true}}
+	c := New(staticPool{s}, config.HealthCheckConfig{Interval: 10 * time.Millisecond, Timeout: time.Second}, WithProber(prober))
+	c.Start()
+	defer c.Close()
+
+	require.Eventually(t, func() bool { return !s.IsAlive() }, time.Second, 10*time.Millisecond)
+	prober.set("http://a", false)
+	require.Eventually(t, s.IsAlive, time.Second, 10*time.Millisecond)
+}
+
+func TestHTTPProber(t *testing.T) {
+	var mu sync.Mutex
+	var got string
+	status := http.StatusOK
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = r.URL.RequestURI()
+		w.WriteHeader(status)
+	}))
+	defer backend.Close()
+
+	p := NewHTTPProber("/healthz?full=1", nil)
+	require.NoError(t, p.Probe(context.Background(), newServer(t, backend.URL+"/api/")))
+	require.Equal(t, "/api/healthz?full=1", got)
+
+	mu.Lock()
+	status = http.StatusServiceUnavailable
+	mu.Unlock()
+	require.ErrorIs(t, p.Probe(context.Background(), newServer(t, backend.URL)), ErrUnhealthy)
+	require.Equal(t, "/healthz?full=1", got)
+}
+
+func TestProbeURL(t *testing.T) {
+	for _, tc := range []struct {
+		server, path, want string
+	}{
+		{server: "http://a:9001", path: "/health", want: "http://a:9001/health"},
+		{server: "http://a:9001/", path: "/health", want: "http://a:9001/health"},
+		{server: "http://a:9001/api", path: "health", want: "http://a:9001/api/health"},
+		{server: "http://a:9001/api/", path: "/health?full=1", want: "http://a:9001/api/health?full=1"},
+		{server: "http://a:9001", path: grpcHealthCheck, want: "http://a:9001" + grpcHealthCheck},
+	} {
+		got, err := probeURL(tc.server, tc.path)
+		require.NoError(t, err)
+		require.Equal(t, tc.want, got)
+	}
+}
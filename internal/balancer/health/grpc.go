@@ -0,0 +1,139 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+)
+
+var (
+	ErrBadResponse = errors.New("malformed gRPC response")
+)
+
+const grpcHealthCheck = "/grpc.health.v1.Health/Check"
+
+// grpc.health.v1.HealthCheckResponse.ServingStatus
+const grpcServing = 1
+
+/*
+GRPCProber implements gRPC health checking protocol over plain HTTP/2,
+server is healthy if it reports SERVING.
+*/
+type GRPCProber struct {
+	service string
+	client  *http.Client
+}
+
+/*
+Transport must speak HTTP/2, eg. h2c for 'http://' servers.
+*/
+func NewGRPCProber(service string, transport http.RoundTripper) *GRPCProber {
+	return &GRPCProber{
+		service: service,
+		client:  &http.Client{Transport: transport},
+	}
+}
+
+func (p *GRPCProber) Probe(ctx context.Context, s server.Server) error {
+	// HealthCheckRequest{service = 1}
+	var msg []byte
+	if p.service != "" {
+		msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+		msg = protowire.AppendString(msg, p.service)
+	}
+	target, err := probeURL(s.URL(), grpcHealthCheck)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(grpcFrame(msg)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %d", ErrUnhealthy, resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// Trailers-only response carries status in headers.
+	trailer := resp.Trailer
+	if trailer.Get("Grpc-Status") == "" {
+		trailer = resp.Header
+	}
+	if status := trailer.Get("Grpc-Status"); status != "0" {
+		return fmt.Errorf("%w: grpc-status %q: %s", ErrUnhealthy, status, trailer.Get("Grpc-Message"))
+	}
+
+	serving, err := parseHealthResponse(body)
+	if err != nil {
+		return err
+	}
+	if serving != grpcServing {
+		return fmt.Errorf("%w: serving status %d", ErrUnhealthy, serving)
+	}
+	return nil
+}
+
+/*
+Length-prefixed message, not compressed.
+*/
+func grpcFrame(msg []byte) []byte {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+/*
+Returns HealthCheckResponse{status = 1}, unknown fields are skipped.
+*/
+func parseHealthResponse(body []byte) (uint64, error) {
+	if len(body) < 5 || body[0] != 0 {
+		return 0, ErrBadResponse
+	}
+	n := binary.BigEndian.Uint32(body[1:5])
+	if uint32(len(body)-5) < n {
+		return 0, ErrBadResponse
+	}
+	msg := body[5 : 5+n]
+
+	var status uint64
+	for len(msg) > 0 {
+		num, typ, l := protowire.ConsumeTag(msg)
+		if l < 0 {
+			return 0, ErrBadResponse
+		}
+		msg = msg[l:]
+		if num == 1 && typ == protowire.VarintType {
+			v, l := protowire.ConsumeVarint(msg)
+			if l < 0 {
+				return 0, ErrBadResponse
+			}
+			status, msg = v, msg[l:]
+			continue
+		}
+		l = protowire.ConsumeFieldValue(num, typ, msg)
+		if l < 0 {
+			return 0, ErrBadResponse
+		}
+		msg = msg[l:]
+	}
+	return status, nil
+}
@@ -0,0 +1,116 @@
+package health
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+)
+
+/*
+Fake health service answering with given serving status, or failing gRPC status if non-zero.
+*/
+func grpcHealthServer(t *testing.T, serving uint64, grpcStatus string) (*httptest.Server, *string) {
+	t.Helper()
+	var service string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, grpcHealthCheck, r.URL.Path)
+		require.Equal(t, "application/grpc", r.Header.Get("Content-Type"))
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if len(body) > 5 {
+			_, _, l := protowire.ConsumeTag(body[5:])
+			v, _ := protowire.ConsumeString(body[5+l:])
+			service = v
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		if grpcStatus != "0" {
+			// Trailers-only.
+			w.Header().Set("Grpc-Status", grpcStatus)
+			w.Header().Set("Grpc-Message", "unknown service")
+			return
+		}
+		w.Header().Set("Trailer", "Grpc-Status")
+		msg := protowire.AppendTag(nil, 1, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, serving)
+		_, _ = w.Write(grpcFrame(msg))
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	srv.Config.Protocols = &protocols
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv, &service
+}
+
+func h2c() *http.Transport {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Transport{Protocols: &protocols}
+}
+
+func TestGRPCProber(t *testing.T) {
+	tests := []struct {
+		name       string
+		serving    uint64
+		grpcStatus string
+		healthy    bool
+	}{
+		{name: "serving", serving: grpcServing, grpcStatus: "0", healthy: true},
+		{name: "not serving", serving: 2, grpcStatus: "0"},
+		{name: "not found", grpcStatus: "5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, service := grpcHealthServer(t, tt.serving, tt.grpcStatus)
+			s, err := server.New(srv.URL)
+			require.NoError(t, err)
+			p := NewGRPCProber("lb.Echo", h2c())
+
+			err = p.Probe(context.Background(), s)
+			if tt.healthy {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, ErrUnhealthy)
+			}
+			require.Equal(t, "lb.Echo", *service)
+		})
+	}
+}
+
+func TestParseHealthResponse(t *testing.T) {
+	// Unknown field before status is skipped.
+	msg := protowire.AppendTag(nil, 7, protowire.BytesType)
+	msg = protowire.AppendString(msg, "x")
+	msg = protowire.AppendTag(msg, 1, protowire.VarintType)
+	msg = protowire.AppendVarint(msg, grpcServing)
+
+	status, err := parseHealthResponse(grpcFrame(msg))
+	require.NoError(t, err)
+	require.EqualValues(t, grpcServing, status)
+
+	_, err = parseHealthResponse([]byte{0, 0, 0, 0, 9, 1})
+	require.ErrorIs(t, err, ErrBadResponse)
+	_, err = parseHealthResponse(nil)
+	require.ErrorIs(t, err, ErrBadResponse)
+}
+
+func TestNewProber(t *testing.T) {
+	p, err := NewProber(config.HealthCheckConfig{}, nil)
+	require.NoError(t, err)
+	require.IsType(t, &HTTPProber{}, p)
+	p, err = NewProber(config.HealthCheckConfig{Type: config.HealthCheckGRPC}, nil)
+	require.NoError(t, err)
+	require.IsType(t, &GRPCProber{}, p)
+	_, err = NewProber(config.HealthCheckConfig{Type: "icmp"}, nil)
+	require.ErrorIs(t, err, ErrUnknownType)
+}
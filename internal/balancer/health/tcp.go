@@ -0,0 +1,36 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+)
+
+/*
+TCPProber only checks that server accepts connections.
+*/
+type TCPProber struct {
+	dialer net.Dialer
+}
+
+func NewTCPProber() *TCPProber {
+	return &TCPProber{}
+}
+
+func (p *TCPProber) Probe(ctx context.Context, s server.Server) error {
+	u, err := url.Parse(s.URL())
+	if err != nil {
+		return err
+	}
+	if u.Port() == "" {
+		return fmt.Errorf("%w: no port in %s", ErrUnhealthy, s.URL())
+	}
+	conn, err := p.dialer.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,28 @@
+package health
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+)
+
+func TestTCPProber(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	up, err := server.New("tcp://" + l.Addr().String())
+	require.NoError(t, err)
+
+	p := NewTCPProber()
+	require.NoError(t, p.Probe(context.Background(), up))
+
+	l.Close()
+	require.Error(t, p.Probe(context.Background(), up))
+
+	noPort, err := server.New("tcp://localhost")
+	require.NoError(t, err)
+	require.ErrorIs(t, p.Probe(context.Background(), noPort), ErrUnhealthy)
+}
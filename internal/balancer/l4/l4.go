@@ -0,0 +1,62 @@
+package l4
+
+import (
+	"net"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+)
+
+/*
+Policy of TCP or UDP proxy, same as of HTTP balancer.
+*/
+type Policy interface {
+	Select(servers []server.Server) (server.Server, error)
+}
+
+/*
+Policies hashing client address get client IP as key.
+*/
+type keyedPolicy interface {
+	SelectKey(servers []server.Server, key string) (server.Server, error)
+}
+
+/*
+Implemented by servers accounting connections as in-flight requests.
+Done is called once connection is closed, or with connect error.
+*/
+type tracker interface {
+	BeginConn() (done func(err error))
+}
+
+func SelectServer(policy Policy, servers []server.Server, client net.Addr) (server.Server, error) {
+	if kp, ok := policy.(keyedPolicy); ok {
+		ip, _, err := net.SplitHostPort(client.String())
+		if err != nil {
+			ip = client.String()
+		}
+		return kp.SelectKey(servers, ip)
+	}
+	return policy.Select(servers)
+}
+
+/*
+Counts connection to server, returned done is nil if server doesn't account them.
+*/
+func BeginConn(s server.Server) (done func(err error)) {
+	if t, ok := s.(tracker); ok {
+		return t.BeginConn()
+	}
+	return nil
+}
+
+/*
+Ends connection begun by BeginConn. Server which doesn't account connections is ejected on error.
+*/
+func EndConn(s server.Server, done func(error), err error) {
+	switch {
+	case done != nil:
+		done(err)
+	case err != nil:
+		s.SetAlive(false)
+	}
+}
@@ -0,0 +1,66 @@
+package l4
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/mocks"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+)
+
+type keyed struct {
+	mocks.Policy
+	key string
+}
+
+func (k *keyed) SelectKey(servers []server.Server, key string) (server.Server, error) {
+	k.key = key
+	return servers[0], nil
+}
+
+func TestSelectServer(t *testing.T) {
+	s := new(mocks.Server)
+	servers := []server.Server{s}
+	client := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000}
+
+	p := &keyed{}
+	got, err := SelectServer(p, servers, client)
+	require.NoError(t, err)
+	require.Equal(t, s, got)
+	require.Equal(t, "10.0.0.1", p.key)
+
+	plain := new(mocks.Policy)
+	plain.On("Select", mock.Anything).Return(s, nil)
+	_, err = SelectServer(plain, servers, client)
+	require.NoError(t, err)
+	plain.AssertNumberOfCalls(t, "Select", 1)
+}
+
+type tracked struct {
+	mocks.Server
+	ended []error
+}
+
+func (s *tracked) BeginConn() func(error) {
+	return func(err error) { s.ended = append(s.ended, err) }
+}
+
+func TestConn(t *testing.T) {
+	s := &tracked{}
+	done := BeginConn(s)
+	require.NotNil(t, done)
+	EndConn(s, done, errors.New("refused"))
+	require.Len(t, s.ended, 1)
+
+	// Server without accounting is ejected on error only.
+	plain := new(mocks.Server)
+	plain.On("SetAlive", false).Once()
+	require.Nil(t, BeginConn(plain))
+	EndConn(plain, nil, nil)
+	EndConn(plain, nil, errors.New("refused"))
+	plain.AssertExpectations(t)
+}
@@ -0,0 +1,64 @@
+package ch
+
+import (
+	"errors"
+	"hash/fnv"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/policy/rr"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+)
+
+var (
+	ErrNoServers = errors.New("no servers")
+)
+
+/*
+ConsistentHashPolicy sends the same key (client address) to the same server.
+Rendezvous hashing is used: once server leaves the pool, only its keys move elsewhere.
+Servers ejected after selection are skipped, so retries reach the next one.
+*/
+type ConsistentHashPolicy struct {
+	fallback *rr.RoundRobinPolicy
+}
+
+func New() *ConsistentHashPolicy {
+	return &ConsistentHashPolicy{fallback: rr.New()}
+}
+
+/*
+Callers without key get round-robin.
+*/
+func (p *ConsistentHashPolicy) Select(servers []server.Server) (server.Server, error) {
+	return p.fallback.Select(servers)
+}
+
+func (p *ConsistentHashPolicy) SelectKey(servers []server.Server, key string) (server.Server, error) {
+	if len(servers) == 0 {
+		return nil, ErrNoServers
+	}
+
+	var (
+		best      server.Server
+		bestScore uint64
+		bestAlive bool
+	)
+	for _, s := range servers {
+		score := weight(key, s.URL())
+		alive := s.IsAlive()
+		switch {
+		case best == nil,
+			alive && !bestAlive,
+			alive == bestAlive && score > bestScore:
+			best, bestScore, bestAlive = s, score, alive
+		}
+	}
+	return best, nil
+}
+
+func weight(key, server string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(server))
+	return h.Sum64()
+}
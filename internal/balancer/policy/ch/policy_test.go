@@ -0,0 +1,72 @@
+package ch
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+)
+
+type fakeServer struct {
+	url  string
+	dead bool
+}
+
+func (f *fakeServer) Serve(w http.ResponseWriter, r *http.Request) error { return nil }
+func (f *fakeServer) SetAlive(alive bool)                                { f.dead = !alive }
+func (f *fakeServer) IsAlive() bool                                      { return !f.dead }
+func (f *fakeServer) URL() string                                        { return f.url }
+
+func TestConsistentHash(t *testing.T) {
+	a, b, c := &fakeServer{url: "a"}, &fakeServer{url: "b"}, &fakeServer{url: "c"}
+	p := New()
+
+	picked := map[string]string{}
+	for i := range 100 {
+		key := fmt.Sprintf("10.0.0.%d", i)
+		s, err := p.SelectKey([]server.Server{a, b, c}, key)
+		require.NoError(t, err)
+		again, err := p.SelectKey([]server.Server{c, a, b}, key)
+		require.NoError(t, err)
+		require.Equal(t, s, again)
+		picked[key] = s.URL()
+	}
+	used := map[string]bool{}
+	for _, url := range picked {
+		used[url] = true
+	}
+	require.Len(t, used, 3)
+
+	// Only keys of removed server move.
+	for key, url := range picked {
+		s, err := p.SelectKey([]server.Server{a, c}, key)
+		require.NoError(t, err)
+		if url != "b" {
+			require.Equal(t, url, s.URL())
+		}
+	}
+
+	// Ejected server is skipped until every one is.
+	for key, url := range picked {
+		if url != "a" {
+			continue
+		}
+		a.SetAlive(false)
+		s, err := p.SelectKey([]server.Server{a, b, c}, key)
+		require.NoError(t, err)
+		require.NotEqual(t, "a", s.URL())
+
+		b.SetAlive(false)
+		c.SetAlive(false)
+		s, err = p.SelectKey([]server.Server{a, b, c}, key)
+		require.NoError(t, err)
+		require.Equal(t, "a", s.URL())
+		break
+	}
+
+	_, err := p.SelectKey(nil, "k")
+	require.ErrorIs(t, err, ErrNoServers)
+}
@@ -0,0 +1,60 @@
+package lc
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+)
+
+var (
+	ErrNoServers = errors.New("no servers")
+)
+
+/*
+Implemented by servers counting requests being served, upgraded connections included.
+*/
+type loaded interface {
+	InFlight() int64
+}
+
+/*
+LeastConnectionsPolicy picks server with fewest in-flight requests.
+Ties are broken in round-robin order, servers without counter are considered idle.
+*/
+type LeastConnectionsPolicy struct {
+	m    sync.Mutex
+	next int
+}
+
+func New() *LeastConnectionsPolicy {
+	return &LeastConnectionsPolicy{}
+}
+
+func (p *LeastConnectionsPolicy) Select(servers []server.Server) (server.Server, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if len(servers) == 0 {
+		return nil, ErrNoServers
+	}
+
+	start := p.next % len(servers)
+	p.next++
+
+	var (
+		best     server.Server
+		bestLoad int64
+	)
+	for i := range servers {
+		s := servers[(start+i)%len(servers)]
+		var load int64
+		if l, ok := s.(loaded); ok {
+			load = l.InFlight()
+		}
+		if best == nil || load < bestLoad {
+			best, bestLoad = s, load
+		}
+	}
+	return best, nil
+}
@@ -0,0 +1,48 @@
+package lc
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+)
+
+type fakeServer struct {
+	url      string
+	inFlight int64
+}
+
+func (f *fakeServer) Serve(w http.ResponseWriter, r *http.Request) error { return nil }
+func (f *fakeServer) SetAlive(alive bool)                                {}
+func (f *fakeServer) IsAlive() bool                                      { return true }
+func (f *fakeServer) URL() string                                        { return f.url }
+func (f *fakeServer) InFlight() int64                                    { return f.inFlight }
+
+func TestLeastConnections(t *testing.T) {
+	a := &fakeServer{url: "a", inFlight: 3}
+	b := &fakeServer{url: "b", inFlight: 1}
+	c := &fakeServer{url: "c", inFlight: 2}
+	servers := []server.Server{a, b, c}
+	p := New()
+
+	for range 3 {
+		s, err := p.Select(servers)
+		require.NoError(t, err)
+		require.Equal(t, "b", s.URL())
+	}
+
+	// Equally loaded servers take turns.
+	b.inFlight, c.inFlight = 3, 3
+	seen := map[string]int{}
+	for range 6 {
+		s, err := p.Select(servers)
+		require.NoError(t, err)
+		seen[s.URL()]++
+	}
+	require.Equal(t, map[string]int{"a": 2, "b": 2, "c": 2}, seen)
+
+	_, err := p.Select(nil)
+	require.ErrorIs(t, err, ErrNoServers)
+}
@@ -1,6 +1,7 @@
 package config_watcher
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -8,6 +9,11 @@ import (
 	"syscall"
 )
 
+var (
+	ErrLoad   = errors.New("failed to load config")
+	ErrUpdate = errors.New("failed to update server pool")
+)
+
 type Loader interface {
 	Load(path string) ([]string, error)
 }
@@ -40,12 +46,12 @@ func (w *ConfigWatcher) Watch(path string, updater PoolUpdater) {
 		for range signals {
 			urls, err := w.loader.Load(path)
 			if err != nil {
-				w.onError(fmt.Errorf("failed to load config: %w", err))
+				w.onError(fmt.Errorf("%w: %w", ErrLoad, err))
 				continue
 			}
 
 			if err := updater.Update(urls); err != nil {
-				w.onError(fmt.Errorf("failed to update server pool: %w", err))
+				w.onError(fmt.Errorf("%w: %w", ErrUpdate, err))
 				continue
 			}
 
@@ -57,3 +63,20 @@ func (w *ConfigWatcher) Watch(path string, updater PoolUpdater) {
 func DefaultOnError(err error) {
 	log.Println(err)
 }
+
+/*
+WatchFunc calls reload on every SIGHUP.
+Used for configuration applied without touching server pools.
+*/
+func WatchFunc(path string, reload func(path string) error, onError func(error)) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			if err := reload(path); err != nil {
+				onError(fmt.Errorf("%w: %w", ErrLoad, err))
+			}
+		}
+	}()
+}
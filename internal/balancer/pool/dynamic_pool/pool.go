@@ -19,6 +19,29 @@ type Factory interface {
 	Create(url string) (server.Server, error)
 }
 
+/*
+Observer is notified about every pool update.
+*/
+type Observer interface {
+	PoolUpdated(size int, err error)
+}
+
+/*
+Listener is notified about servers added to and removed from the pool.
+*/
+type Listener interface {
+	ServerAdded(url string)
+	ServerRemoved(url string, draining bool)
+}
+
+/*
+Implemented by servers able to finish in-flight requests after removal.
+*/
+type drainable interface {
+	InFlight() int64
+	SetDraining(draining bool)
+}
+
 /*
 Dynamic since it expand/shrink it's size based on the current configuration.
 */
@@ -32,16 +55,48 @@ type Dynamic struct {
 	// Gonna use URLs as keys, 'http://localhost:9001' as an example.
 	servers map[string]server.Server
 
+	// Removed from configuration, but still serving in-flight requests.
+	// Never returned by Alive.
+	draining map[string]server.Server
+
 	// Used to create new Server instances on Update call.
 	serverFactory Factory
+
+	observers []Observer
+	listeners []Listener
+}
+
+type Option func(*Dynamic)
+
+/*
+May be passed several times.
+*/
+func WithObserver(o Observer) Option {
+	return func(p *Dynamic) {
+		p.observers = append(p.observers, o)
+	}
 }
 
-func New(factory Factory) *Dynamic {
-	return &Dynamic{
+/*
+May be passed several times.
+*/
+func WithListener(l Listener) Option {
+	return func(p *Dynamic) {
+		p.listeners = append(p.listeners, l)
+	}
+}
+
+func New(factory Factory, opts ...Option) *Dynamic {
+	p := &Dynamic{
 		servers:       make(map[string]server.Server),
+		draining:      make(map[string]server.Server),
 		urls:          make(map[string]struct{}),
 		serverFactory: factory,
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
 func (p *Dynamic) add(s server.Server) error {
@@ -51,16 +106,40 @@ func (p *Dynamic) add(s server.Server) error {
 	}
 	p.servers[url] = s
 	p.urls[url] = struct{}{}
+	for _, l := range p.listeners {
+		l.ServerAdded(url)
+	}
 	return nil
 }
 
 func (p *Dynamic) remove(url string) error {
+	s := p.servers[url]
 	delete(p.servers, url)
 	delete(p.urls, url)
 
+	draining := false
+	if d, ok := s.(drainable); ok && d.InFlight() > 0 {
+		d.SetDraining(true)
+		p.draining[url] = s
+		draining = true
+	}
+	for _, l := range p.listeners {
+		l.ServerRemoved(url, draining)
+	}
 	return nil
 }
 
+/*
+Forgets draining servers that have finished their requests.
+*/
+func (p *Dynamic) reap() {
+	for url, s := range p.draining {
+		if s.(drainable).InFlight() == 0 {
+			delete(p.draining, url)
+		}
+	}
+}
+
 func (p *Dynamic) Alive() ([]server.Server, error) {
 	p.m.RLock()
 	defer p.m.RUnlock()
@@ -78,15 +157,55 @@ func (p *Dynamic) Alive() ([]server.Server, error) {
 	return result, nil
 }
 
+/*
+Every server in the pool, dead and draining ones included.
+Drained servers are forgotten here, so they leave stats and metrics
+as soon as they are done, not on the next update.
+*/
+func (p *Dynamic) All() []server.Server {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.reap()
+
+	result := make([]server.Server, 0, len(p.servers)+len(p.draining))
+	for _, s := range p.servers {
+		result = append(result, s)
+	}
+	for _, s := range p.draining {
+		result = append(result, s)
+	}
+	return result
+}
+
 func (p *Dynamic) Update(urls []string) error {
 	log.Println("update", urls)
 	p.m.Lock()
 	defer p.m.Unlock()
+
+	err := p.update(urls)
+	for _, o := range p.observers {
+		o.PoolUpdated(len(p.servers), err)
+	}
+	return err
+}
+
+func (p *Dynamic) update(urls []string) error {
+	p.reap()
 	for _, url := range urls {
 		if _, exists := p.urls[url]; exists {
 			continue
 		}
 
+		// Returned back to configuration before it was drained.
+		if s, ok := p.draining[url]; ok {
+			delete(p.draining, url)
+			s.(drainable).SetDraining(false)
+			if err := p.add(s); err != nil {
+				return err
+			}
+			continue
+		}
+
 		new, err := p.serverFactory.Create(url)
 		if err != nil {
 			return fmt.Errorf("%w: %w", ErrUnableToUpdate, err)
@@ -0,0 +1,126 @@
+package dynamic_pool
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/factory"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+)
+
+type recordingListener struct {
+	events []string
+}
+
+func (l *recordingListener) ServerAdded(url string) {
+	l.events = append(l.events, "added "+url)
+}
+
+func (l *recordingListener) ServerRemoved(url string, draining bool) {
+	if draining {
+		l.events = append(l.events, "draining "+url)
+		return
+	}
+	l.events = append(l.events, "removed "+url)
+}
+
+type recordingObserver struct {
+	sizes []int
+	errs  []error
+}
+
+func (o *recordingObserver) PoolUpdated(size int, err error) {
+	o.sizes = append(o.sizes, size)
+	o.errs = append(o.errs, err)
+}
+
+func urls(servers []server.Server) []string {
+	result := make([]string, 0, len(servers))
+	for _, s := range servers {
+		result = append(result, s.URL())
+	}
+	sort.Strings(result)
+	return result
+}
+
+func find(servers []server.Server, url string) server.Server {
+	for _, s := range servers {
+		if s.URL() == url {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestDynamic_Update(t *testing.T) {
+	l, o := &recordingListener{}, &recordingObserver{}
+	p := New(factory.New(), WithListener(l), WithObserver(o))
+
+	require.NoError(t, p.Update([]string{"http://a", "http://b"}))
+	require.NoError(t, p.Update([]string{"http://b", "http://c"}))
+
+	alive, err := p.Alive()
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://b", "http://c"}, urls(alive))
+	require.Equal(t, []int{2, 2}, o.sizes)
+	require.Contains(t, l.events, "removed http://a")
+	require.Contains(t, l.events, "added http://c")
+
+	require.NoError(t, p.Update(nil))
+	_, err = p.Alive()
+	require.ErrorIs(t, err, ErrNoServers)
+}
+
+func TestDynamic_UpdateError(t *testing.T) {
+	o := &recordingObserver{}
+	p := New(factory.New(), WithObserver(o))
+
+	err := p.Update([]string{"://bad"})
+	require.ErrorIs(t, err, ErrUnableToUpdate)
+	require.Len(t, o.errs, 1)
+	require.ErrorIs(t, o.errs[0], ErrUnableToUpdate)
+}
+
+func TestDynamic_Drain(t *testing.T) {
+	l := &recordingListener{}
+	p := New(factory.New(), WithListener(l))
+	require.NoError(t, p.Update([]string{"http://a", "http://b"}))
+
+	a := find(p.All(), "http://a").(*server.ServerInst)
+	done := a.BeginConn()
+
+	require.NoError(t, p.Update([]string{"http://b"}))
+	require.Contains(t, l.events, "draining http://a")
+
+	// No new requests, but still listed while finishing the old one.
+	alive, err := p.Alive()
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://b"}, urls(alive))
+	require.Equal(t, []string{"http://a", "http://b"}, urls(p.All()))
+	require.Equal(t, server.StateDraining, a.Snapshot().State)
+
+	// Forgotten right after the request is done, without another update.
+	done(nil)
+	require.Equal(t, []string{"http://b"}, urls(p.All()))
+}
+
+func TestDynamic_DrainReturned(t *testing.T) {
+	p := New(factory.New())
+	require.NoError(t, p.Update([]string{"http://a", "http://b"}))
+
+	a := find(p.All(), "http://a").(*server.ServerInst)
+	done := a.BeginConn()
+	defer done(nil)
+	require.NoError(t, p.Update([]string{"http://b"}))
+	require.Equal(t, server.StateDraining, a.Snapshot().State)
+
+	// Same instance goes back, with its in-flight request.
+	require.NoError(t, p.Update([]string{"http://a", "http://b"}))
+	alive, err := p.Alive()
+	require.NoError(t, err)
+	require.Same(t, a, find(alive, "http://a"))
+	require.Equal(t, server.StateAlive, a.Snapshot().State)
+	require.Equal(t, int64(1), a.InFlight())
+}
@@ -0,0 +1,174 @@
+package rewrite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+)
+
+var (
+	ErrBadRewrite  = errors.New("bad rewrite")
+	ErrBadRedirect = errors.New("bad redirect")
+)
+
+/*
+Rewrite changes path and host of request sent to backend,
+so backends don't need to know their public mount point.
+*/
+type Rewrite struct {
+	stripPrefix string
+	addPrefix   string
+	re          *regexp.Regexp
+	replacement string
+	host        string
+}
+
+/*
+Nil if config has no rules.
+*/
+func Compile(cfg config.RewriteConfig) (*Rewrite, error) {
+	if cfg == (config.RewriteConfig{}) {
+		return nil, nil
+	}
+	rw := &Rewrite{
+		stripPrefix: strings.TrimSuffix(cfg.StripPrefix, "/"),
+		addPrefix:   strings.TrimSuffix(cfg.AddPrefix, "/"),
+		replacement: cfg.Replacement,
+		host:        cfg.Host,
+	}
+	if cfg.Regex != "" {
+		re, err := regexp.Compile(cfg.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrBadRewrite, err)
+		}
+		rw.re = re
+	} else if cfg.Replacement != "" {
+		return nil, fmt.Errorf("%w: replacement without regex", ErrBadRewrite)
+	}
+	return rw, nil
+}
+
+func (rw *Rewrite) Path(p string) string {
+	// '/api' strips '/api' and '/api/x', but not '/apix'.
+	if rw.stripPrefix != "" {
+		if rest, ok := strings.CutPrefix(p, rw.stripPrefix); ok && (rest == "" || rest[0] == '/') {
+			p = rest
+		}
+	}
+	if rw.re != nil {
+		p = rw.re.ReplaceAllString(p, rw.replacement)
+	}
+	p = rw.addPrefix + p
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return p
+}
+
+/*
+Must be called before target path is joined.
+*/
+func (rw *Rewrite) Apply(r *http.Request) {
+	r.URL.Path = rw.Path(r.URL.Path)
+	r.URL.RawPath = ""
+}
+
+/*
+Must be called after target host is set.
+*/
+func (rw *Rewrite) ApplyHost(r *http.Request) {
+	if rw.host != "" {
+		r.Host = rw.host
+	}
+}
+
+type rewriteKey struct{}
+
+func NewContext(ctx context.Context, rw *Rewrite) context.Context {
+	return context.WithValue(ctx, rewriteKey{}, rw)
+}
+
+func FromContext(ctx context.Context) *Rewrite {
+	rw, _ := ctx.Value(rewriteKey{}).(*Rewrite)
+	return rw
+}
+
+/*
+Redirect answers with location built from request, without touching backends.
+*/
+type Redirect struct {
+	code    int
+	scheme  string
+	host    string
+	path    string
+	rewrite *Rewrite
+}
+
+func NewRedirect(cfg config.RedirectConfig, rw *Rewrite) (*Redirect, error) {
+	code := cfg.Code
+	switch code {
+	case 0:
+		code = http.StatusFound
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil, fmt.Errorf("%w: unsupported code %d", ErrBadRedirect, code)
+	}
+	switch cfg.Scheme {
+	case "", "http", "https":
+	default:
+		return nil, fmt.Errorf("%w: unsupported scheme %q", ErrBadRedirect, cfg.Scheme)
+	}
+	return &Redirect{
+		code:    code,
+		scheme:  cfg.Scheme,
+		host:    cfg.Host,
+		path:    cfg.Path,
+		rewrite: rw,
+	}, nil
+}
+
+func (rd *Redirect) Location(r *http.Request) string {
+	u := url.URL{
+		Scheme:   rd.scheme,
+		Host:     rd.host,
+		Path:     r.URL.Path,
+		RawQuery: r.URL.RawQuery,
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+		if r.TLS != nil {
+			u.Scheme = "https"
+		}
+	}
+	if u.Host == "" {
+		u.Host = r.Host
+		// Default port of the old scheme is wrong for the new one.
+		if rd.scheme != "" {
+			u.Host = stripPort(u.Host)
+		}
+	}
+	if rd.rewrite != nil {
+		u.Path = rd.rewrite.Path(u.Path)
+	}
+	if rd.path != "" {
+		u.Path = rd.path
+	}
+	return u.String()
+}
+
+func (rd *Redirect) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, rd.Location(r), rd.code)
+}
+
+func stripPort(host string) string {
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.Contains(host[i:], "]") {
+		return host[:i]
+	}
+	return host
+}
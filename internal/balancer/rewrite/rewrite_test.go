@@ -0,0 +1,65 @@
+package rewrite
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+)
+
+func TestRewrite_Path(t *testing.T) {
+	cases := []struct {
+		cfg  config.RewriteConfig
+		in   string
+		want string
+	}{
+		{config.RewriteConfig{StripPrefix: "/api"}, "/api/users", "/users"},
+		{config.RewriteConfig{StripPrefix: "/api/"}, "/api", "/"},
+		{config.RewriteConfig{StripPrefix: "/api"}, "/apix", "/apix"},
+		{config.RewriteConfig{AddPrefix: "/v2/"}, "/users", "/v2/users"},
+		{config.RewriteConfig{Regex: `^/users/(\d+)/posts$`, Replacement: "/posts/by/$1"}, "/users/7/posts", "/posts/by/7"},
+		{config.RewriteConfig{StripPrefix: "/api", Regex: `^/old/(.*)`, Replacement: "/new/$1", AddPrefix: "/svc"}, "/api/old/x", "/svc/new/x"},
+	}
+	for _, c := range cases {
+		rw, err := Compile(c.cfg)
+		require.NoError(t, err)
+		require.Equal(t, c.want, rw.Path(c.in), c.in)
+	}
+}
+
+func TestRewrite_Invalid(t *testing.T) {
+	_, err := Compile(config.RewriteConfig{Regex: "("})
+	require.ErrorIs(t, err, ErrBadRewrite)
+	_, err = Compile(config.RewriteConfig{Replacement: "/x"})
+	require.ErrorIs(t, err, ErrBadRewrite)
+
+	rw, err := Compile(config.RewriteConfig{})
+	require.NoError(t, err)
+	require.Nil(t, rw)
+}
+
+func TestRedirect(t *testing.T) {
+	rd, err := NewRedirect(config.RedirectConfig{Code: http.StatusPermanentRedirect, Scheme: "https"}, nil)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	rd.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "http://example.com:80/pay?id=1", nil))
+	require.Equal(t, http.StatusPermanentRedirect, rr.Code)
+	require.Equal(t, "https://example.com/pay?id=1", rr.Header().Get("Location"))
+
+	rw, err := Compile(config.RewriteConfig{StripPrefix: "/old"})
+	require.NoError(t, err)
+	rd, err = NewRedirect(config.RedirectConfig{Host: "new.example.com"}, rw)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/old/docs", nil)
+	req.TLS = &tls.ConnectionState{}
+	require.Equal(t, "https://new.example.com/docs", rd.Location(req))
+
+	_, err = NewRedirect(config.RedirectConfig{Code: http.StatusOK}, nil)
+	require.ErrorIs(t, err, ErrBadRedirect)
+}
@@ -0,0 +1,232 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	"github.com/humanbelnik/load-balancer/internal/balancer/headers"
+	"github.com/humanbelnik/load-balancer/internal/balancer/rewrite"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/proxy"
+)
+
+var (
+	ErrBadRoute        = errors.New("bad route")
+	ErrUnknownUpstream = errors.New("unknown upstream")
+)
+
+/*
+Route sends matching requests to handler of its upstream group.
+Every set condition must hold, route without conditions matches everything.
+*/
+type Route struct {
+	Name     string
+	Priority int
+	Handler  http.Handler
+
+	hosts      []string
+	pathPrefix string
+	pathRegex  *regexp.Regexp
+	methods    []string
+	scheme     string
+	headers    map[string]string
+
+	// Header rules and rewrite applied by proxy, nil if route has none.
+	rules   *headers.RouteRules
+	rewrite *rewrite.Rewrite
+	// 0 if proxy default is used.
+	flushInterval time.Duration
+}
+
+func NewRoute(cfg config.RouteConfig, h http.Handler) (Route, error) {
+	r := Route{
+		Name:       cfg.Name,
+		Priority:   cfg.Priority,
+		Handler:    h,
+		pathPrefix: cfg.PathPrefix,
+		scheme:     strings.ToLower(cfg.Scheme),
+		headers:    make(map[string]string, len(cfg.Headers)),
+
+		flushInterval: cfg.FlushInterval,
+	}
+	for _, host := range cfg.Hosts {
+		host = strings.ToLower(host)
+		if strings.Contains(host, "*") && (!strings.HasPrefix(host, "*.") || strings.Count(host, "*") > 1) {
+			return Route{}, fmt.Errorf("%w %q: wildcard host must look like '*.example.com': %q", ErrBadRoute, cfg.Name, host)
+		}
+		r.hosts = append(r.hosts, host)
+	}
+	for _, m := range cfg.Methods {
+		r.methods = append(r.methods, strings.ToUpper(m))
+	}
+	for k, v := range cfg.Headers {
+		r.headers[http.CanonicalHeaderKey(k)] = v
+	}
+	if cfg.PathRegex != "" {
+		re, err := regexp.Compile(cfg.PathRegex)
+		if err != nil {
+			return Route{}, fmt.Errorf("%w %q: %w", ErrBadRoute, cfg.Name, err)
+		}
+		r.pathRegex = re
+	}
+
+	rules, err := headers.CompileRoute(cfg)
+	if err != nil {
+		return Route{}, fmt.Errorf("%w %q: %w", ErrBadRoute, cfg.Name, err)
+	}
+	r.rules = rules
+
+	rw, err := rewrite.Compile(cfg.Rewrite)
+	if err != nil {
+		return Route{}, fmt.Errorf("%w %q: %w", ErrBadRoute, cfg.Name, err)
+	}
+	r.rewrite = rw
+	return r, nil
+}
+
+func (rt *Route) Match(r *http.Request) bool {
+	if len(rt.hosts) > 0 && !matchHost(rt.hosts, r.Host) {
+		return false
+	}
+	if rt.pathPrefix != "" && !strings.HasPrefix(r.URL.Path, rt.pathPrefix) {
+		return false
+	}
+	if rt.pathRegex != nil && !rt.pathRegex.MatchString(r.URL.Path) {
+		return false
+	}
+	if len(rt.methods) > 0 && !slices.Contains(rt.methods, r.Method) {
+		return false
+	}
+	if rt.scheme != "" && rt.scheme != scheme(r) {
+		return false
+	}
+	for name, want := range rt.headers {
+		values, ok := r.Header[name]
+		if !ok {
+			return false
+		}
+		if want != "" && !slices.Contains(values, want) {
+			return false
+		}
+	}
+	return true
+}
+
+func scheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+/*
+Wildcard '*.example.com' matches any subdomain, but not 'example.com' itself
+or 'badexample.com'.
+*/
+func matchHost(patterns []string, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	for _, p := range patterns {
+		// Suffix keeps the dot, so only whole labels match.
+		if suffix, ok := strings.CutPrefix(p, "*"); ok {
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+			continue
+		}
+		if host == p {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Router picks upstream group by request host, path, method and headers.
+Unmatched requests go to fallback handler, if set, or get 404.
+Routes are swapped atomically on config reload.
+*/
+type Router struct {
+	routes   atomic.Pointer[[]Route]
+	fallback http.Handler
+	logger   *slog.Logger
+}
+
+type Option func(*Router)
+
+func WithFallback(h http.Handler) Option {
+	return func(r *Router) {
+		r.fallback = h
+	}
+}
+
+func WithLogger(logger *slog.Logger) Option {
+	return func(r *Router) {
+		r.logger = logger
+	}
+}
+
+func New(routes []Route, opts ...Option) *Router {
+	r := &Router{
+		logger: slog.Default(),
+	}
+	r.SetRoutes(routes)
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+/*
+Replaces every route, requests in progress keep the old ones.
+*/
+func (rt *Router) SetRoutes(routes []Route) {
+	sorted := slices.Clone(routes)
+	slices.SortStableFunc(sorted, func(a, b Route) int {
+		return b.Priority - a.Priority
+	})
+	rt.routes.Store(&sorted)
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	routes := *rt.routes.Load()
+	for i := range routes {
+		if routes[i].Match(r) {
+			if rules := routes[i].rules; rules != nil {
+				r = r.WithContext(headers.NewContext(r.Context(), rules))
+				// Balancer answers on its own too, so rules apply to what gets written.
+				if !rules.Response.Empty() {
+					rw := headers.NewResponseWriter(w, r, rules.Response)
+					r = r.WithContext(headers.NewResponseContext(r.Context(), rw))
+					w = rw
+				}
+			}
+			if rw := routes[i].rewrite; rw != nil {
+				r = r.WithContext(rewrite.NewContext(r.Context(), rw))
+			}
+			if d := routes[i].flushInterval; d != 0 {
+				r = r.WithContext(proxy.WithFlushInterval(r.Context(), d))
+			}
+			routes[i].Handler.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	if rt.fallback != nil {
+		rt.fallback.ServeHTTP(w, r)
+		return
+	}
+	rt.logger.DebugContext(r.Context(), "no route matched", slog.String("host", r.Host), slog.String("path", r.URL.Path))
+	http.NotFound(w, r)
+}
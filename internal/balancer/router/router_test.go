@@ -0,0 +1,122 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+)
+
+func named(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream", name)
+	})
+}
+
+func newRouter(t *testing.T, opts ...Option) *Router {
+	t.Helper()
+	cfgs := []config.RouteConfig{
+		{Name: "api", Hosts: []string{"api.example.com"}, PathPrefix: "/v1/", Upstream: "api"},
+		{Name: "tenants", Hosts: []string{"*.example.com"}, Upstream: "tenants"},
+		{Name: "admin", PathRegex: `^/admin/\d+$`, Methods: []string{"post"}, Upstream: "admin"},
+		{Name: "canary", Priority: 10, Headers: map[string]string{"x-canary": "1"}, Upstream: "canary"},
+	}
+	routes := make([]Route, 0, len(cfgs))
+	for _, c := range cfgs {
+		r, err := NewRoute(c, named(c.Upstream))
+		require.NoError(t, err)
+		routes = append(routes, r)
+	}
+	return New(routes, opts...)
+}
+
+func route(rt *Router, method, target string, headers map[string]string) string {
+	req := httptest.NewRequest(method, target, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rr := httptest.NewRecorder()
+	rt.ServeHTTP(rr, req)
+	if rr.Code == http.StatusNotFound {
+		return ""
+	}
+	return rr.Header().Get("X-Upstream")
+}
+
+func TestRouter_Match(t *testing.T) {
+	rt := newRouter(t)
+
+	require.Equal(t, "api", route(rt, http.MethodGet, "http://api.example.com:8080/v1/users", nil))
+	// Not under /v1/, falls through to wildcard.
+	require.Equal(t, "tenants", route(rt, http.MethodGet, "http://api.example.com/health", nil))
+	require.Equal(t, "tenants", route(rt, http.MethodGet, "http://a.b.EXAMPLE.com/", nil))
+	require.Equal(t, "", route(rt, http.MethodGet, "http://example.com/", nil))
+
+	require.Equal(t, "admin", route(rt, http.MethodPost, "http://other/admin/42", nil))
+	require.Equal(t, "", route(rt, http.MethodGet, "http://other/admin/42", nil))
+	require.Equal(t, "", route(rt, http.MethodPost, "http://other/admin/x", nil))
+}
+
+func TestRouter_Priority(t *testing.T) {
+	rt := newRouter(t)
+
+	require.Equal(t, "canary", route(rt, http.MethodGet, "http://api.example.com/v1/users", map[string]string{"X-Canary": "1"}))
+	require.Equal(t, "api", route(rt, http.MethodGet, "http://api.example.com/v1/users", map[string]string{"X-Canary": "0"}))
+}
+
+func TestRouter_Fallback(t *testing.T) {
+	rt := newRouter(t, WithFallback(named("default")))
+
+	require.Equal(t, "default", route(rt, http.MethodGet, "http://example.com/", nil))
+}
+
+func TestNewRoute_BadRegex(t *testing.T) {
+	_, err := NewRoute(config.RouteConfig{Name: "bad", PathRegex: "("}, named("x"))
+	require.ErrorIs(t, err, ErrBadRoute)
+}
+
+func TestRouter_WildcardBoundary(t *testing.T) {
+	rt := newRouter(t)
+
+	require.Equal(t, "tenants", route(rt, http.MethodGet, "http://a.example.com/", nil))
+	require.Equal(t, "", route(rt, http.MethodGet, "http://badexample.com/", nil))
+
+	for _, host := range []string{"*example.com", "*", "a.*.example.com", "*.*.example.com"} {
+		_, err := NewRoute(config.RouteConfig{Name: "bad", Hosts: []string{host}}, named("x"))
+		require.ErrorIs(t, err, ErrBadRoute, host)
+	}
+}
+
+func TestRouter_SetRoutes(t *testing.T) {
+	rt := newRouter(t, WithFallback(named("default")))
+	require.Equal(t, "api", route(rt, http.MethodGet, "http://api.example.com/v1/users", nil))
+
+	r, err := NewRoute(config.RouteConfig{Name: "v2", PathPrefix: "/v2/", Upstream: "v2"}, named("v2"))
+	require.NoError(t, err)
+	rt.SetRoutes([]Route{r})
+
+	require.Equal(t, "v2", route(rt, http.MethodGet, "http://api.example.com/v2/users", nil))
+	require.Equal(t, "default", route(rt, http.MethodGet, "http://api.example.com/v1/users", nil))
+}
+
+func TestRouter_ResponseHeaders(t *testing.T) {
+	cfg := config.RouteConfig{
+		Name:            "api",
+		Upstream:        "api",
+		ResponseHeaders: config.HeaderRulesConfig{Set: map[string]string{"Cache-Control": "no-store"}},
+	}
+	// Balancer answers by itself when no backend is left.
+	failing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	})
+	r, err := NewRoute(cfg, failing)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	New([]Route{r}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusBadGateway, w.Code)
+	require.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+}
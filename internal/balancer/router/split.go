@@ -0,0 +1,146 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+)
+
+var (
+	ErrBadSplit = errors.New("bad split")
+)
+
+type Weight struct {
+	Upstream string `json:"upstream"`
+	Weight   int    `json:"weight"`
+}
+
+/*
+Split spreads requests between upstream groups proportionally to weights.
+Weights are swapped atomically, requests in progress are not affected.
+*/
+type Split struct {
+	handlers map[string]http.Handler
+	sticky   config.StickyConfig
+	weights  atomic.Pointer[[]Weight]
+}
+
+func NewSplit(cfg []config.SplitConfig, sticky *config.StickyConfig, handlers map[string]http.Handler) (*Split, error) {
+	s := &Split{handlers: make(map[string]http.Handler, len(cfg))}
+	if sticky != nil {
+		s.sticky = *sticky
+	}
+
+	weights := make([]Weight, 0, len(cfg))
+	for _, c := range cfg {
+		h, ok := handlers[c.Upstream]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownUpstream, c.Upstream)
+		}
+		if _, dup := s.handlers[c.Upstream]; dup {
+			return nil, fmt.Errorf("%w: %s listed twice", ErrBadSplit, c.Upstream)
+		}
+		s.handlers[c.Upstream] = h
+		weights = append(weights, Weight{Upstream: c.Upstream, Weight: c.Weight})
+	}
+	if err := validate(weights); err != nil {
+		return nil, err
+	}
+	s.weights.Store(&weights)
+	return s, nil
+}
+
+func validate(weights []Weight) error {
+	total := 0
+	for _, w := range weights {
+		if w.Weight < 0 {
+			return fmt.Errorf("%w: negative weight of %s", ErrBadSplit, w.Upstream)
+		}
+		total += w.Weight
+	}
+	if total == 0 {
+		return fmt.Errorf("%w: weights sum up to zero", ErrBadSplit)
+	}
+	return nil
+}
+
+func (s *Split) Weights() []Weight {
+	return append([]Weight(nil), *s.weights.Load()...)
+}
+
+/*
+Changes weights of given upstreams, others keep their current weight.
+*/
+func (s *Split) SetWeights(weights map[string]int) error {
+	for name := range weights {
+		if _, ok := s.handlers[name]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownUpstream, name)
+		}
+	}
+
+	updated := s.Weights()
+	for i := range updated {
+		if w, ok := weights[updated[i].Upstream]; ok {
+			updated[i].Weight = w
+		}
+	}
+	if err := validate(updated); err != nil {
+		return err
+	}
+	s.weights.Store(&updated)
+	return nil
+}
+
+func (s *Split) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handlers[s.pick(r)].ServeHTTP(w, r)
+}
+
+/*
+Upstreams own consecutive ranges of [0, total) in config order.
+Sticky key hashes to a fixed fraction of the range, not to a remainder of total,
+so raising weight of the last one (canary) keeps its sticky users there, even though total changes.
+*/
+func (s *Split) pick(r *http.Request) string {
+	weights := *s.weights.Load()
+	total := 0
+	for _, w := range weights {
+		total += w.Weight
+	}
+
+	var point int
+	if key, ok := s.stickyKey(r); ok {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		point = int(float64(h.Sum64()) / float64(math.MaxUint64) * float64(total))
+	} else {
+		point = rand.IntN(total)
+	}
+
+	for _, w := range weights {
+		if point < w.Weight {
+			return w.Upstream
+		}
+		point -= w.Weight
+	}
+	return weights[len(weights)-1].Upstream
+}
+
+func (s *Split) stickyKey(r *http.Request) (string, bool) {
+	if s.sticky.Header != "" {
+		if v := r.Header.Get(s.sticky.Header); v != "" {
+			return v, true
+		}
+	}
+	if s.sticky.Cookie != "" {
+		if c, err := r.Cookie(s.sticky.Cookie); err == nil && c.Value != "" {
+			return c.Value, true
+		}
+	}
+	return "", false
+}
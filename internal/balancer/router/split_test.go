@@ -0,0 +1,106 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+)
+
+func newSplit(t *testing.T, sticky *config.StickyConfig) *Split {
+	t.Helper()
+	s, err := NewSplit([]config.SplitConfig{
+		{Upstream: "stable", Weight: 95},
+		{Upstream: "canary", Weight: 5},
+	}, sticky, map[string]http.Handler{
+		"stable": named("stable"),
+		"canary": named("canary"),
+	})
+	require.NoError(t, err)
+	return s
+}
+
+func split(s *Split, user string) string {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	return rr.Header().Get("X-Upstream")
+}
+
+func TestSplit_Weights(t *testing.T) {
+	s := newSplit(t, nil)
+
+	canary := 0
+	for range 10000 {
+		if split(s, "") == "canary" {
+			canary++
+		}
+	}
+	require.InDelta(t, 500, canary, 150)
+
+	require.NoError(t, s.SetWeights(map[string]int{"stable": 0}))
+	require.Equal(t, "canary", split(s, ""))
+	require.Equal(t, []Weight{{"stable", 0}, {"canary", 5}}, s.Weights())
+}
+
+func TestSplit_Sticky(t *testing.T) {
+	s := newSplit(t, &config.StickyConfig{Header: "X-User"})
+
+	before := make(map[string]string)
+	for i := range 1000 {
+		user := strconv.Itoa(i)
+		before[user] = split(s, user)
+		require.Equal(t, before[user], split(s, user))
+	}
+
+	// Growing canary keeps its users.
+	require.NoError(t, s.SetWeights(map[string]int{"stable": 50, "canary": 50}))
+	for user, upstream := range before {
+		if upstream == "canary" {
+			require.Equal(t, "canary", split(s, user))
+		}
+	}
+}
+
+func TestSplit_StickyTotalChanged(t *testing.T) {
+	s := newSplit(t, &config.StickyConfig{Header: "X-User"})
+
+	var canary []string
+	for i := range 10000 {
+		if user := strconv.Itoa(i); split(s, user) == "canary" {
+			canary = append(canary, user)
+		}
+	}
+	require.InDelta(t, 500, len(canary), 150)
+
+	// Total goes from 100 to 105, canary share from 5% to ~9.5%.
+	require.NoError(t, s.SetWeights(map[string]int{"canary": 10}))
+	grown := 0
+	for i := range 10000 {
+		if split(s, strconv.Itoa(i)) == "canary" {
+			grown++
+		}
+	}
+	require.InDelta(t, 952, grown, 150)
+	for _, user := range canary {
+		require.Equal(t, "canary", split(s, user), user)
+	}
+}
+
+func TestSplit_Invalid(t *testing.T) {
+	s := newSplit(t, nil)
+
+	require.ErrorIs(t, s.SetWeights(map[string]int{"unknown": 1}), ErrUnknownUpstream)
+	require.ErrorIs(t, s.SetWeights(map[string]int{"stable": 0, "canary": 0}), ErrBadSplit)
+	require.ErrorIs(t, s.SetWeights(map[string]int{"canary": -1}), ErrBadSplit)
+
+	_, err := NewSplit([]config.SplitConfig{{Upstream: "missing", Weight: 1}}, nil, nil)
+	require.ErrorIs(t, err, ErrUnknownUpstream)
+}
@@ -1,13 +1,18 @@
 package factory
 
-import "github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+import (
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+)
 
-type Factory struct{}
+type Factory struct {
+	// Applied to every created server.
+	opts []server.Option
+}
 
-func New() *Factory {
-	return &Factory{}
+func New(opts ...server.Option) *Factory {
+	return &Factory{opts: opts}
 }
 
 func (f *Factory) Create(url string) (server.Server, error) {
-	return server.New(url)
+	return server.New(url, f.opts...)
 }
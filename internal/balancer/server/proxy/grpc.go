@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrGRPC = errors.New("server responded with gRPC error")
+)
+
+// gRPC status treated as server failure, same as 5xx.
+// Others may come from the call itself, eg. RESOURCE_EXHAUSTED from a client quota, so they are passed through.
+const grpcUnavailable = 14
+
+func IsGRPC(r *http.Request) bool {
+	ct, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && (ct == "application/grpc" || strings.HasPrefix(ct, "application/grpc+"))
+}
+
+/*
+Status is sent in trailers, or in headers if response has no messages (trailers-only).
+Trailers not announced before body get TrailerPrefix in response header.
+*/
+func grpcStatus(h http.Header) (int, bool) {
+	v := h.Get("Grpc-Status")
+	if v == "" {
+		v = h.Get(http.TrailerPrefix + "Grpc-Status")
+	}
+	code, err := strconv.Atoi(v)
+	return code, err == nil
+}
+
+func grpcFailure(code int) bool {
+	return code == grpcUnavailable
+}
+
+/*
+Trailers-only UNAVAILABLE response, gRPC clients don't read error pages.
+*/
+func GRPCUnavailable(w http.ResponseWriter, msg string) {
+	h := w.Header()
+	h.Set("Content-Type", "application/grpc")
+	h.Set("Grpc-Status", strconv.Itoa(grpcUnavailable))
+	h.Set("Grpc-Message", msg)
+	w.WriteHeader(http.StatusOK)
+}
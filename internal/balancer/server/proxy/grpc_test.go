@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+)
+
+/*
+Fake gRPC server: '/stream/<code>' sends a message and status in trailers,
+'/fail/<code>' sends trailers-only response.
+*/
+func grpcServer(t *testing.T) *url.URL {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kind, code, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		w.Header().Set("Content-Type", "application/grpc")
+		if kind == "fail" {
+			w.Header().Set("Grpc-Status", code)
+			return
+		}
+		w.Header().Set("Trailer", "Grpc-Status")
+		_, _ = w.Write([]byte{0, 0, 0, 0, 0})
+		w.Header().Set("Grpc-Status", code)
+	}))
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	srv.Config.Protocols = &protocols
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	return u
+}
+
+func TestProxy_GRPCStatus(t *testing.T) {
+	target := grpcServer(t)
+	tr, err := NewTransport(config.UpstreamConfig{Protocol: config.ProtocolH2C})
+	require.NoError(t, err)
+	p := New(target, WithTransport(tr))
+
+	call := func(path string) (*httptest.ResponseRecorder, error) {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader("\x00\x00\x00\x00\x00"))
+		r.Header.Set("Content-Type", "application/grpc+proto")
+		r.Header.Set("TE", "trailers")
+		rr := httptest.NewRecorder()
+		_, err := p.ServeAndReport(rr, r)
+		return rr, err
+	}
+
+	rr, err := call("/stream/0")
+	require.NoError(t, err)
+	require.Equal(t, "0", rr.Result().Trailer.Get("Grpc-Status"))
+
+	// Application errors are passed through, UNKNOWN, RESOURCE_EXHAUSTED and INTERNAL too.
+	for _, code := range []string{"2", "5", "8", "13"} {
+		rr, err = call("/fail/" + code)
+		require.NoError(t, err)
+		require.Equal(t, code, rr.Header().Get("Grpc-Status"))
+	}
+
+	// Nothing is written, so balancer may retry.
+	rr, err = call("/fail/14")
+	require.ErrorIs(t, err, ErrGRPC)
+	require.NotErrorIs(t, err, ErrResponseSent)
+	require.False(t, rr.Flushed)
+	require.Empty(t, rr.Header())
+	require.Zero(t, rr.Body.Len())
+
+	rr, err = call("/stream/14")
+	require.ErrorIs(t, err, ErrGRPC)
+	require.ErrorIs(t, err, ErrResponseSent)
+	require.Equal(t, "14", rr.Result().Trailer.Get("Grpc-Status"))
+}
+
+func TestProxy_GRPCTransportError(t *testing.T) {
+	p := New(&url.URL{Scheme: "http", Host: "127.0.0.1:1"})
+
+	r := httptest.NewRequest(http.MethodPost, "/svc/Method", nil)
+	r.Header.Set("Content-Type", "application/grpc")
+	rr := httptest.NewRecorder()
+	_, err := p.ServeAndReport(rr, r)
+	require.ErrorIs(t, err, ErrTransport)
+	require.Zero(t, rr.Body.Len())
+}
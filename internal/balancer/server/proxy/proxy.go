@@ -1,43 +1,317 @@
 package proxy
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/netip"
 	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/headers"
+	"github.com/humanbelnik/load-balancer/internal/balancer/rewrite"
+	"github.com/humanbelnik/load-balancer/internal/proxyproto"
+	"github.com/humanbelnik/load-balancer/internal/requestid"
+)
+
+var (
+	ErrServer    = errors.New("server responded with 5xx")
+	ErrTransport = errors.New("server unreachable")
+	// Failed response has been streamed to client, request can't be retried.
+	ErrResponseSent = errors.New("response already sent")
 )
 
+const tracerName = "github.com/humanbelnik/load-balancer/internal/balancer/server/proxy"
+
+// Held 5xx body larger than this is sent to client, the call is not retried then.
+const heldBodyLimit = 64 * 1024
+
+type attemptKey struct{}
+
+/*
+Attempt number (0 for the first try) is reported in upstream span.
+*/
+func WithAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+func attemptFrom(ctx context.Context) int {
+	attempt, _ := ctx.Value(attemptKey{}).(int)
+	return attempt
+}
+
+type flushKey struct{}
+
+/*
+Flush interval of the route, overrides proxy default.
+*/
+func WithFlushInterval(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, flushKey{}, d)
+}
+
+func flushIntervalFrom(ctx context.Context) time.Duration {
+	d, _ := ctx.Value(flushKey{}).(time.Duration)
+	return d
+}
+
+/*
+Observer is notified about every upstream attempt.
+*/
+type Observer interface {
+	ObserveUpstream(backend string, code int, d time.Duration)
+}
+
 type Proxy struct {
-	target *url.URL
-	proxy  *httputil.ReverseProxy
+	target   *url.URL
+	proxy    *httputil.ReverseProxy
+	observer Observer
+	tracer   trace.Tracer
+	logger   *slog.Logger
+
+	// Upgraded connections.
+	conns       *Conns
+	idleTimeout time.Duration
+	upgraded    atomic.Int64
+
+	// Copies of proxy by route flush interval.
+	mu       sync.Mutex
+	flushing map[time.Duration]*httputil.ReverseProxy
+}
+
+type Option func(*Proxy)
+
+func WithObserver(o Observer) Option {
+	return func(p *Proxy) {
+		p.observer = o
+	}
 }
 
-func New(target *url.URL) *Proxy {
+func WithTracer(t trace.Tracer) Option {
+	return func(p *Proxy) {
+		p.tracer = t
+	}
+}
+
+func WithLogger(logger *slog.Logger) Option {
+	return func(p *Proxy) {
+		p.logger = logger
+	}
+}
+
+/*
+Shared by servers of an upstream group, see NewTransport.
+*/
+func WithTransport(rt http.RoundTripper) Option {
+	return func(p *Proxy) {
+		p.proxy.Transport = rt
+	}
+}
+
+/*
+Upgraded connections are registered in conns for graceful shutdown.
+*/
+func WithConns(c *Conns) Option {
+	return func(p *Proxy) {
+		p.conns = c
+	}
+}
+
+/*
+Upgraded connection without traffic in both directions is closed after d, 0 disables.
+*/
+func WithIdleTimeout(d time.Duration) Option {
+	return func(p *Proxy) {
+		p.idleTimeout = d
+	}
+}
+
+func New(target *url.URL, opts ...Option) *Proxy {
 	p := &Proxy{
 		target: target,
 		proxy:  httputil.NewSingleHostReverseProxy(target),
+		tracer: otel.Tracer(tracerName),
+		logger: slog.Default(),
 	}
 	p.proxy.ErrorHandler = p.onError
+
+	// Route path rewrite goes before target path is joined.
+	// Pass request ID and W3C trace context of the attempt span to backend.
+	// Route header rules go in between, so they may drop the ID, but not the trace context.
+	director := p.proxy.Director
+	p.proxy.Director = func(r *http.Request) {
+		rw := rewrite.FromContext(r.Context())
+		if rw != nil {
+			rw.Apply(r)
+		}
+		director(r)
+		if rw != nil {
+			rw.ApplyHost(r)
+		}
+		// Upgrade to h2c is done by listener, server must not be asked for it again.
+		if strings.EqualFold(r.Header.Get("Upgrade"), "h2c") {
+			r.Header.Del("Upgrade")
+			r.Header.Del("HTTP2-Settings")
+		}
+
+		id := requestid.FromContext(r.Context())
+		if id != "" {
+			r.Header.Set(requestid.Header, id)
+		}
+		if rules := headers.FromContext(r.Context()); rules != nil {
+			vars := headers.RequestVars(r, id, p.target.String())
+			rules.Request.Apply(r.Header, &vars)
+		}
+		otel.GetTextMapPropagator().Inject(r.Context(), propagation.HeaderCarrier(r.Header))
+	}
+	// Balancer has already set its own ID on the response.
+	// Route response rules are applied by writer of the route, once headers are merged.
+	p.proxy.ModifyResponse = func(resp *http.Response) error {
+		resp.Header.Del(requestid.Header)
+		if rw := headers.ResponseFromContext(resp.Request.Context()); rw != nil {
+			rw.SetBackend(p.target.String())
+		}
+		return nil
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
 	return p
 }
 
-func (p *Proxy) ServeAndReport(w http.ResponseWriter, r *http.Request) error {
-	ri := &responseInterceptor{ResponseWriter: w}
-	p.proxy.ServeHTTP(ri, r)
+/*
+Returns response status. Error wraps ErrTransport if server was not reached
+and ErrServer if it answered with 5xx, also ErrResponseSent if 5xx was streamed.
+For gRPC calls failing status wraps ErrGRPC.
+
+Unless response has been streamed already (ErrResponseSent), failure is not written to client,
+so call may be retried, and caller answers if it is not.
+*/
+func (p *Proxy) ServeAndReport(w http.ResponseWriter, r *http.Request) (int, error) {
+	ctx, span := p.tracer.Start(r.Context(), "upstream "+r.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.URLFull(p.target.String()),
+			attribute.Int("lb.retry", attemptFrom(r.Context())),
+		),
+	)
+	defer span.End()
+
+	ri := &responseInterceptor{ResponseWriter: w, grpcStatus: -1, onHijack: p.trackUpgraded, header: w.Header().Clone()}
+	if IsGRPC(r) {
+		ri.grpc = true
+	}
+	// Sent to server in PROXY protocol header, if transport is configured to.
+	ctx = proxyproto.NewContext(ctx, remoteAddr(r), localAddr(r))
+	start := time.Now()
+	p.reverseProxy(ctx).ServeHTTP(ri, r.WithContext(ctx))
+	ri.finish()
+	if p.observer != nil {
+		p.observer.ObserveUpstream(p.target.String(), ri.status, time.Since(start))
+	}
 
+	span.SetAttributes(semconv.HTTPResponseStatusCode(ri.status), attribute.String("lb.outcome", ri.outcome()))
+	if ri.grpcStatus >= 0 {
+		span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(ri.grpcStatus))
+	}
+	if ri.proxyErr != nil {
+		span.RecordError(ri.proxyErr)
+	}
 	if ri.failed {
-		return fmt.Errorf("server %s failed", p.target)
+		span.SetStatus(codes.Error, ri.outcome())
+	}
+
+	switch {
+	case ri.proxyErr != nil:
+		return ri.status, fmt.Errorf("%w: %s: %w", ErrTransport, p.target, ri.proxyErr)
+	case ri.failed && ri.grpcStatus >= 0 && !ri.held:
+		return ri.status, fmt.Errorf("%w: %w: %s: grpc-status %d", ErrGRPC, ErrResponseSent, p.target, ri.grpcStatus)
+	case ri.failed && ri.grpcStatus >= 0:
+		return ri.status, fmt.Errorf("%w: %s: grpc-status %d", ErrGRPC, p.target, ri.grpcStatus)
+	case ri.failed && ri.flushed:
+		return ri.status, fmt.Errorf("%w: %w: %s", ErrServer, ErrResponseSent, p.target)
+	case ri.failed:
+		return ri.status, fmt.Errorf("%w: %s", ErrServer, p.target)
+	}
+	return ri.status, nil
+}
+
+func remoteAddr(r *http.Request) net.Addr {
+	ap, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return net.TCPAddrFromAddrPort(ap)
+}
+
+func localAddr(r *http.Request) net.Addr {
+	addr, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return addr
+}
+
+/*
+Copy of reverse proxy flushing with route's interval, the rest is shared.
+*/
+func (p *Proxy) reverseProxy(ctx context.Context) *httputil.ReverseProxy {
+	d := flushIntervalFrom(ctx)
+	if d == 0 {
+		return p.proxy
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rp, ok := p.flushing[d]
+	if !ok {
+		copied := *p.proxy
+		copied.FlushInterval = d
+		rp = &copied
+		if p.flushing == nil {
+			p.flushing = make(map[time.Duration]*httputil.ReverseProxy)
+		}
+		p.flushing[d] = rp
 	}
-	return nil
+	return rp
+}
+
+/*
+Number of open upgraded connections, eg. WebSocket ones.
+*/
+func (p *Proxy) Upgraded() int64 {
+	return p.upgraded.Load()
 }
 
 func (p *Proxy) onError(w http.ResponseWriter, r *http.Request, err error) {
+	ri, ok := w.(*responseInterceptor)
+	if ok {
+		ri.proxyErr = err
+	}
+
+	p.logger.WarnContext(r.Context(), "proxy error", slog.String("server", p.target.String()), slog.Any("err", err))
+	// Left for retry, client gets the balancer's error once every server has failed.
+	if ok {
+		ri.status = classifyStatusCode(err)
+		ri.failed = true
+		ri.held = true
+		return
+	}
 	msg := formatProxyError(err)
 	code := classifyStatusCode(err)
-
-	http.Error(w, msg, code)
+	requestid.Error(w, r, msg, code)
 }
 
 func formatProxyError(err error) string {
@@ -68,14 +342,135 @@ func isNetError(err error) bool {
 	return errors.As(err, &opErr)
 }
 
+/*
+Failed response is held, so the call may be retried: nothing is written to client.
+Held 5xx is sent once proxy flushes it, as streamed response, or once it is too large to keep.
+Unwrap keeps http.ResponseController (deadlines) working,
+flushes go through FlushError so held response is not sent before.
+Hijack is done for protocol switch (WebSocket), see upgrade.go.
+*/
 type responseInterceptor struct {
 	http.ResponseWriter
+	status int
 	failed bool
+	// Transport error, response was generated by onError.
+	proxyErr error
+
+	grpc bool
+	// -1 if not a gRPC response.
+	grpcStatus int
+	// Failed response is not written to client.
+	held bool
+	// Body of held 5xx, written if it gets flushed.
+	body bytes.Buffer
+	// Part of response has reached client, eg. streamed one.
+	flushed bool
+	// Response header before proxying, restored if response is held.
+	header http.Header
+
+	// Wraps connection hijacked for protocol switch.
+	onHijack func(net.Conn) net.Conn
+}
+
+func (ri *responseInterceptor) outcome() string {
+	switch {
+	case ri.proxyErr != nil:
+		return "error"
+	case ri.failed && ri.grpcStatus >= 0:
+		return "upstream_grpc_error"
+	case ri.failed:
+		return "upstream_5xx"
+	default:
+		return "success"
+	}
 }
 
 func (ri *responseInterceptor) WriteHeader(code int) {
+	ri.status = code
 	if code >= 500 {
 		ri.failed = true
+		ri.held = true
+		return
+	}
+	// Trailers-only response, nothing has been sent yet.
+	if ri.grpc {
+		if status, ok := grpcStatus(ri.Header()); ok && grpcFailure(status) {
+			ri.grpcStatus = status
+			ri.failed = true
+			ri.held = true
+			return
+		}
 	}
 	ri.ResponseWriter.WriteHeader(code)
 }
+
+func (ri *responseInterceptor) Write(b []byte) (int, error) {
+	if ri.status == 0 {
+		ri.WriteHeader(http.StatusOK)
+	}
+	if !ri.held {
+		return ri.ResponseWriter.Write(b)
+	}
+	if ri.grpc || ri.proxyErr != nil {
+		return len(b), nil
+	}
+	ri.body.Write(b)
+	if ri.body.Len() > heldBodyLimit {
+		if err := ri.release(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (ri *responseInterceptor) FlushError() error {
+	if ri.held {
+		// gRPC status is known only from trailers, nothing is sent before.
+		if ri.grpc || ri.proxyErr != nil {
+			return nil
+		}
+		if err := ri.release(); err != nil {
+			return err
+		}
+	}
+	ri.flushed = true
+	return http.NewResponseController(ri.ResponseWriter).Flush()
+}
+
+/*
+Sends held 5xx, it can't be retried after that.
+*/
+func (ri *responseInterceptor) release() error {
+	ri.held = false
+	ri.flushed = true
+	ri.ResponseWriter.WriteHeader(ri.status)
+	_, err := ri.ResponseWriter.Write(ri.body.Bytes())
+	ri.body.Reset()
+	return err
+}
+
+func (ri *responseInterceptor) Unwrap() http.ResponseWriter {
+	return ri.ResponseWriter
+}
+
+/*
+Reads gRPC status from trailers once response is copied,
+drops headers of held response.
+*/
+func (ri *responseInterceptor) finish() {
+	if ri.held {
+		h := ri.Header()
+		clear(h)
+		for k, v := range ri.header {
+			h[k] = v
+		}
+		return
+	}
+	if !ri.grpc || ri.proxyErr != nil || ri.status != http.StatusOK {
+		return
+	}
+	if status, ok := grpcStatus(ri.Header()); ok {
+		ri.grpcStatus = status
+		ri.failed = grpcFailure(status)
+	}
+}
@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+/*
+Backend sending two chunks with a pause, the second one is sent after release.
+*/
+func slowServer(t *testing.T, contentType string, status int) (*url.URL, chan struct{}) {
+	t.Helper()
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		first, second := "data: 1\n\n", "data: 2\n\n"
+		w.Header().Set("Content-Type", contentType)
+		if contentType != "text/event-stream" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(first)+len(second)))
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, first)
+		http.NewResponseController(w).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		fmt.Fprint(w, second)
+	}))
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	return u, release
+}
+
+/*
+Reports whether the first chunk reaches client before the second one is sent.
+*/
+func streamed(t *testing.T, p *Proxy, flush time.Duration, release chan struct{}) (bool, error) {
+	t.Helper()
+	errs := make(chan error, 1)
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if flush != 0 {
+			ctx = WithFlushInterval(ctx, flush)
+		}
+		_, err := p.ServeAndReport(w, r.WithContext(ctx))
+		errs <- err
+	}))
+	defer front.Close()
+
+	// Headers of buffered response are not sent before the body either.
+	got := make(chan struct{})
+	go func() {
+		resp, err := http.Get(front.URL)
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		if line == "data: 1\n" {
+			close(got)
+		}
+	}()
+
+	ok := false
+	select {
+	case <-got:
+		ok = true
+	case <-time.After(200 * time.Millisecond):
+	}
+	close(release)
+	return ok, <-errs
+}
+
+func TestProxy_Streaming(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		flush       time.Duration
+		streamed    bool
+	}{
+		{name: "event stream", contentType: "text/event-stream", streamed: true},
+		{name: "known length", contentType: "text/plain"},
+		{name: "route flushes every write", contentType: "text/plain", flush: -1, streamed: true},
+		{name: "route flush interval", contentType: "text/plain", flush: 10 * time.Millisecond, streamed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, release := slowServer(t, tt.contentType, http.StatusOK)
+			ok, err := streamed(t, New(target), tt.flush, release)
+			require.NoError(t, err)
+			require.Equal(t, tt.streamed, ok)
+		})
+	}
+}
+
+func TestProxy_StreamedFailureNotRetried(t *testing.T) {
+	target, release := slowServer(t, "text/event-stream", http.StatusServiceUnavailable)
+	_, err := streamed(t, New(target), 0, release)
+	require.ErrorIs(t, err, ErrServer)
+	require.ErrorIs(t, err, ErrResponseSent)
+
+	// Buffered failure is held, nothing reaches client, so it may be retried.
+	target, release = slowServer(t, "text/plain", http.StatusServiceUnavailable)
+	_, err = streamed(t, New(target), 0, release)
+	require.ErrorIs(t, err, ErrServer)
+	require.NotErrorIs(t, err, ErrResponseSent)
+}
+
+func TestProxy_HeldFailure(t *testing.T) {
+	size := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write(make([]byte, size))
+	}))
+	defer srv.Close()
+	target, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	p := New(target)
+
+	rr := httptest.NewRecorder()
+	rr.Header().Set("X-Request-Id", "abc")
+	status, err := p.ServeAndReport(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.ErrorIs(t, err, ErrServer)
+	require.NotErrorIs(t, err, ErrResponseSent)
+	require.Equal(t, http.StatusServiceUnavailable, status)
+	require.False(t, rr.Flushed)
+	require.Zero(t, rr.Body.Len())
+	// Header is left as it was for the next attempt.
+	require.Equal(t, http.Header{"X-Request-Id": {"abc"}}, rr.Header())
+
+	// Too large to keep, so it is sent.
+	size = heldBodyLimit + 1
+	rr = httptest.NewRecorder()
+	_, err = p.ServeAndReport(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.ErrorIs(t, err, ErrResponseSent)
+	require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	require.Equal(t, size, rr.Body.Len())
+}
+
+func TestWithFlushInterval(t *testing.T) {
+	require.Zero(t, flushIntervalFrom(context.Background()))
+	require.Equal(t, time.Second, flushIntervalFrom(WithFlushInterval(context.Background(), time.Second)))
+}
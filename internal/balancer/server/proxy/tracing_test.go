@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func tracedProxy(t *testing.T, status int) (*Proxy, *tracetest.SpanRecorder, *url.URL, chan string) {
+	t.Helper()
+	traceparents := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("Traceparent")
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	rec, tracer := recordSpans(t)
+	return New(u, WithTracer(tracer)), rec, u, traceparents
+}
+
+func recordSpans(t *testing.T) (*tracetest.SpanRecorder, trace.Tracer) {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	t.Cleanup(func() { _ = tp.Shutdown(t.Context()) })
+	return rec, tp.Tracer("test")
+}
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestProxy_Span(t *testing.T) {
+	prev := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(prev) })
+
+	p, rec, u, traceparents := tracedProxy(t, http.StatusOK)
+	r := httptest.NewRequest("GET", "/", nil)
+	_, err := p.ServeAndReport(httptest.NewRecorder(), r.WithContext(WithAttempt(r.Context(), 1)))
+	require.NoError(t, err)
+
+	spans := rec.Ended()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	require.Equal(t, "upstream GET", span.Name())
+	require.Equal(t, trace.SpanKindClient, span.SpanKind())
+	require.Equal(t, codes.Unset, span.Status().Code)
+
+	attrs := spanAttributes(span)
+	require.Equal(t, u.String(), attrs["url.full"].AsString())
+	require.Equal(t, int64(1), attrs["lb.retry"].AsInt64())
+	require.Equal(t, int64(http.StatusOK), attrs["http.response.status_code"].AsInt64())
+
+	// Server continues the attempt span.
+	traceparent := <-traceparents
+	require.Contains(t, traceparent, span.SpanContext().SpanID().String())
+}
+
+func TestProxy_SpanError(t *testing.T) {
+	p, rec, _, _ := tracedProxy(t, http.StatusServiceUnavailable)
+	_, err := p.ServeAndReport(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	require.ErrorIs(t, err, ErrServer)
+
+	spans := rec.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, codes.Error, spans[0].Status().Code)
+	require.Equal(t, int64(http.StatusServiceUnavailable), spanAttributes(spans[0])["http.response.status_code"].AsInt64())
+}
+
+func TestProxy_SpanTransportError(t *testing.T) {
+	rec, tracer := recordSpans(t)
+	p := New(&url.URL{Scheme: "http", Host: "127.0.0.1:1"}, WithTracer(tracer))
+	_, err := p.ServeAndReport(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	require.ErrorIs(t, err, ErrTransport)
+
+	spans := rec.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, codes.Error, spans[0].Status().Code)
+	require.NotEmpty(t, spans[0].Events(), "error is recorded")
+}
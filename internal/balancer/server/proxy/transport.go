@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	"github.com/humanbelnik/load-balancer/internal/proxyproto"
+)
+
+var (
+	ErrUpstreamTLS      = errors.New("bad upstream TLS config")
+	ErrUpstreamProtocol = errors.New("unknown upstream protocol")
+	// HTTP/2 multiplexes clients over one connection, it can have single PROXY header only.
+	ErrProxyProtocolH2 = errors.New("PROXY protocol requires HTTP/1.1 to servers")
+)
+
+/*
+Transport for servers of a single upstream group, connections are pooled across them.
+*/
+func NewTransport(cfg config.UpstreamConfig) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	var protocols http.Protocols
+	switch cfg.Protocol {
+	case "":
+	case config.ProtocolHTTP1:
+		protocols.SetHTTP1(true)
+	case config.ProtocolHTTP2:
+		protocols.SetHTTP2(true)
+	case config.ProtocolH2C:
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUpstreamProtocol, cfg.Protocol)
+	}
+	if err := CheckProtocol(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Protocol != "" {
+		t.Protocols = &protocols
+	}
+
+	version, err := proxyproto.ParseVersion(cfg.ProxyProtocol)
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 {
+		if cfg.Protocol == config.ProtocolHTTP2 || cfg.Protocol == config.ProtocolH2C {
+			return nil, ErrProxyProtocolH2
+		}
+		// Header tells about one client, connection can't be shared.
+		protocols = http.Protocols{}
+		protocols.SetHTTP1(true)
+		t.Protocols = &protocols
+		t.DisableKeepAlives = true
+		t.DialContext = proxyproto.Dialer(version, t.DialContext)
+	}
+
+	if cfg.TLS == nil {
+		return t, nil
+	}
+	tlsCfg, err := clientTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	t.TLSClientConfig = tlsCfg
+	return t, nil
+}
+
+/*
+HTTP/2 is spoken over TLS only, h2c over plain connections only. Otherwise every request would fail.
+*/
+func CheckProtocol(cfg config.UpstreamConfig) error {
+	want := ""
+	switch cfg.Protocol {
+	case config.ProtocolHTTP2:
+		want = "https"
+	case config.ProtocolH2C:
+		want = "http"
+	default:
+		return nil
+	}
+	for _, server := range cfg.Servers {
+		u, err := url.Parse(server)
+		// Bad URLs are reported by pool.
+		if err != nil {
+			continue
+		}
+		if u.Scheme != want {
+			return fmt.Errorf("%w: %q requires %s:// servers, got %s", ErrUpstreamProtocol, cfg.Protocol, want, server)
+		}
+	}
+	return nil
+}
+
+func clientTLSConfig(cfg *config.UpstreamTLSConfig) (*tls.Config, error) {
+	tlsCfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUpstreamTLS, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: no certificates in %s", ErrUpstreamTLS, cfg.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUpstreamTLS, err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
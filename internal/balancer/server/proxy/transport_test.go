@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	"github.com/humanbelnik/load-balancer/internal/proxyproto"
+)
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600))
+}
+
+/*
+Self-signed client certificate, returns it and paths to its files.
+*/
+func clientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "lb"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return cert, certFile, keyFile
+}
+
+func TestNewTransport_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	client, certFile, keyFile := clientCert(t, dir)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(client)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	defer srv.Close()
+
+	caFile := filepath.Join(dir, "ca.crt")
+	writePEM(t, caFile, "CERTIFICATE", srv.Certificate().Raw)
+
+	get := func(cfg *config.UpstreamTLSConfig) error {
+		tr, err := NewTransport(config.UpstreamConfig{TLS: cfg})
+		require.NoError(t, err)
+		defer tr.CloseIdleConnections()
+		resp, err := (&http.Client{Transport: tr}).Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+		return nil
+	}
+
+	// httptest certificate is issued for 127.0.0.1 and example.com, server_name replaces the address in checks.
+	require.NoError(t, get(&config.UpstreamTLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}))
+	require.NoError(t, get(&config.UpstreamTLSConfig{
+		CAFile:     caFile,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		ServerName: "example.com",
+	}))
+	// Unknown CA.
+	require.Error(t, get(&config.UpstreamTLSConfig{CertFile: certFile, KeyFile: keyFile}))
+	// No client certificate.
+	require.Error(t, get(&config.UpstreamTLSConfig{CAFile: caFile, ServerName: "example.com"}))
+	// Wrong name.
+	require.Error(t, get(&config.UpstreamTLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "other.com"}))
+	require.NoError(t, get(&config.UpstreamTLSConfig{CertFile: certFile, KeyFile: keyFile, InsecureSkipVerify: true}))
+}
+
+func TestNewTransport_BadFiles(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(notPEM, []byte("garbage"), 0o600))
+
+	_, err := NewTransport(config.UpstreamConfig{TLS: &config.UpstreamTLSConfig{CAFile: notPEM}})
+	require.ErrorIs(t, err, ErrUpstreamTLS)
+	_, err = NewTransport(config.UpstreamConfig{TLS: &config.UpstreamTLSConfig{CAFile: filepath.Join(dir, "missing.crt")}})
+	require.ErrorIs(t, err, ErrUpstreamTLS)
+	_, err = NewTransport(config.UpstreamConfig{TLS: &config.UpstreamTLSConfig{CertFile: notPEM}})
+	require.ErrorIs(t, err, ErrUpstreamTLS)
+}
+
+func TestNewTransport_Protocol(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	}))
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	srv.Config.Protocols = &protocols
+	srv.Start()
+	defer srv.Close()
+
+	proto := func(protocol string) string {
+		tr, err := NewTransport(config.UpstreamConfig{Protocol: protocol})
+		require.NoError(t, err)
+		defer tr.CloseIdleConnections()
+		resp, err := (&http.Client{Transport: tr}).Get(srv.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	require.Equal(t, "HTTP/1.1", proto(""))
+	require.Equal(t, "HTTP/1.1", proto(config.ProtocolHTTP1))
+	require.Equal(t, "HTTP/2.0", proto(config.ProtocolH2C))
+
+	_, err := NewTransport(config.UpstreamConfig{Protocol: "spdy"})
+	require.ErrorIs(t, err, ErrUpstreamProtocol)
+	// Plain connections never negotiate HTTP/2.
+	_, err = NewTransport(config.UpstreamConfig{Protocol: config.ProtocolHTTP2, Servers: []string{srv.URL}})
+	require.ErrorIs(t, err, ErrUpstreamProtocol)
+	_, err = NewTransport(config.UpstreamConfig{Protocol: config.ProtocolH2C, Servers: []string{"https://b:443"}})
+	require.ErrorIs(t, err, ErrUpstreamProtocol)
+}
+
+func TestNewTransport_ProxyProtocol(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.RemoteAddr))
+	}))
+	srv.Listener = proxyproto.NewListener(srv.Listener, []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}, time.Second)
+	srv.Start()
+	defer srv.Close()
+	target, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	tr, err := NewTransport(config.UpstreamConfig{ProxyProtocol: "v1"})
+	require.NoError(t, err)
+	p := New(target, WithTransport(tr))
+
+	// Each client gets its own connection.
+	for _, client := range []string{"203.0.113.7:4000", "198.51.100.9:5000"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = client
+		w := httptest.NewRecorder()
+		_, err := p.ServeAndReport(w, r)
+		require.NoError(t, err)
+		require.Equal(t, client, w.Body.String())
+	}
+
+	// Health checks send local header, server sees the connection as is.
+	resp, err := (&http.Client{Transport: tr}).Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "127.0.0.1:")
+
+	_, err = NewTransport(config.UpstreamConfig{ProxyProtocol: "v1", Protocol: config.ProtocolH2C})
+	require.ErrorIs(t, err, ErrProxyProtocolH2)
+	_, err = NewTransport(config.UpstreamConfig{ProxyProtocol: "v3"})
+	require.ErrorIs(t, err, proxyproto.ErrBadVersion)
+}
@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Conns tracks upgraded (hijacked) connections of all proxies,
+http.Server.Shutdown neither waits for nor closes them.
+*/
+type Conns struct {
+	mu       sync.Mutex
+	conns    map[*upgradedConn]struct{}
+	closed   bool
+	shutdown chan struct{}
+}
+
+func NewConns() *Conns {
+	return &Conns{
+		conns:    make(map[*upgradedConn]struct{}),
+		shutdown: make(chan struct{}),
+	}
+}
+
+func (c *Conns) Active() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.conns)
+}
+
+/*
+Waits for upgraded connections to finish, closes the rest when ctx is done.
+Connections upgraded after the call are closed right away.
+*/
+func (c *Conns) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.shutdown)
+	}
+	c.mu.Unlock()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if c.Active() == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			c.mu.Lock()
+			conns := make([]*upgradedConn, 0, len(c.conns))
+			for conn := range c.conns {
+				conns = append(conns, conn)
+			}
+			c.mu.Unlock()
+			for _, conn := range conns {
+				conn.Close()
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *Conns) add(conn *upgradedConn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	c.conns[conn] = struct{}{}
+	return true
+}
+
+func (c *Conns) remove(conn *upgradedConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.conns, conn)
+}
+
+/*
+Client side of upgraded connection, both directions of traffic go through it.
+Closed after idle timeout without reads and writes.
+*/
+type upgradedConn struct {
+	net.Conn
+	timeout    time.Duration
+	lastActive atomic.Int64
+	timer      *time.Timer
+	once       sync.Once
+	onClose    func()
+}
+
+func (p *Proxy) trackUpgraded(conn net.Conn) net.Conn {
+	uc := &upgradedConn{Conn: conn, timeout: p.idleTimeout}
+	p.upgraded.Add(1)
+	uc.onClose = func() {
+		p.upgraded.Add(-1)
+		if p.conns != nil {
+			p.conns.remove(uc)
+		}
+	}
+	if p.conns != nil && !p.conns.add(uc) {
+		uc.Close()
+		return uc
+	}
+	if uc.timeout > 0 {
+		uc.touch()
+		uc.timer = time.AfterFunc(uc.timeout, uc.checkIdle)
+	}
+	return uc
+}
+
+func (c *upgradedConn) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+func (c *upgradedConn) checkIdle() {
+	idle := time.Since(time.Unix(0, c.lastActive.Load()))
+	if idle >= c.timeout {
+		c.Close()
+		return
+	}
+	c.timer.Reset(c.timeout - idle)
+}
+
+func (c *upgradedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 && c.timeout > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+func (c *upgradedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 && c.timeout > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+func (c *upgradedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+		c.onClose()
+	})
+	return err
+}
+
+/*
+Called by reverse proxy once server agreed to switch protocols.
+*/
+func (ri *responseInterceptor) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(ri.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	ri.status = http.StatusSwitchingProtocols
+	if ri.onHijack != nil {
+		conn = ri.onHijack(conn)
+	}
+	return conn, brw, nil
+}
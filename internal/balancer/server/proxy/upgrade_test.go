@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+/*
+Backend switching to line echo protocol.
+*/
+func echoServer(t *testing.T) *url.URL {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		_ = brw.Flush()
+		for {
+			line, err := brw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			_, _ = brw.WriteString(line)
+			_ = brw.Flush()
+		}
+	}))
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	return u
+}
+
+/*
+Dials front server and switches to echo protocol.
+*/
+func upgrade(t *testing.T, front string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", front)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	_, err = io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: lb\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	require.NoError(t, err)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	return conn, br
+}
+
+func frontServer(t *testing.T, p *Proxy, statuses chan<- int) string {
+	t.Helper()
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, _ := p.ServeAndReport(w, r)
+		statuses <- status
+	}))
+	t.Cleanup(front.Close)
+	return front.Listener.Addr().String()
+}
+
+func TestProxy_Upgrade(t *testing.T) {
+	conns := NewConns()
+	p := New(echoServer(t), WithConns(conns))
+	statuses := make(chan int, 1)
+	conn, br := upgrade(t, frontServer(t, p, statuses))
+
+	_, err := io.WriteString(conn, "ping\n")
+	require.NoError(t, err)
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "ping\n", line)
+	require.EqualValues(t, 1, p.Upgraded())
+	require.Equal(t, 1, conns.Active())
+
+	conn.Close()
+	require.Equal(t, http.StatusSwitchingProtocols, <-statuses)
+	require.Eventually(t, func() bool { return p.Upgraded() == 0 && conns.Active() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestProxy_UpgradeIdleTimeout(t *testing.T) {
+	p := New(echoServer(t), WithIdleTimeout(100*time.Millisecond))
+	conn, br := upgrade(t, frontServer(t, p, make(chan int, 1)))
+
+	// Traffic keeps connection open.
+	for range 3 {
+		time.Sleep(50 * time.Millisecond)
+		_, err := io.WriteString(conn, "ping\n")
+		require.NoError(t, err)
+		_, err = br.ReadString('\n')
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err := br.ReadString('\n')
+	require.ErrorIs(t, err, io.EOF)
+	require.Eventually(t, func() bool { return p.Upgraded() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestConns_Shutdown(t *testing.T) {
+	conns := NewConns()
+	p := New(echoServer(t), WithConns(conns))
+	conn, br := upgrade(t, frontServer(t, p, make(chan int, 1)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, conns.Shutdown(ctx), context.DeadlineExceeded)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err := br.ReadString('\n')
+	require.ErrorIs(t, err, io.EOF)
+	require.Zero(t, conns.Active())
+	require.NoError(t, conns.Shutdown(context.Background()))
+}
@@ -6,12 +6,21 @@ import (
 	"net/http"
 	"net/url"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/humanbelnik/load-balancer/internal/balancer/server/proxy"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/stats"
 )
 
 var ErrBrokenURL = errors.New("broken URL")
 
+const (
+	StateAlive    = "alive"
+	StateEjected  = "ejected"
+	StateDraining = "draining"
+)
+
 /*
 Describes API of a server abstraction.
 */
@@ -22,22 +31,78 @@ type Server interface {
 	URL() string
 }
 
+/*
+Listener is notified about server state changes.
+BreakerTripped is reported when server is ejected after failed request.
+*/
+type Listener interface {
+	ServerStateChanged(url, from, to string)
+	BreakerTripped(url string, err error)
+}
+
+/*
+Runtime statistics of a server.
+*/
+type Snapshot struct {
+	URL            string
+	State          string
+	StateChangedAt time.Time
+	Ejections      uint64
+	// Upgraded connections (eg. WebSocket), counted in InFlight too.
+	Upgraded int64
+	stats.Snapshot
+}
+
 type ServerInst struct {
-	url   *url.URL
-	proxy *proxy.Proxy
-	mu    sync.RWMutex
-	alive bool
+	url      *url.URL
+	proxy    *proxy.Proxy
+	mu       sync.RWMutex
+	alive    bool
+	draining bool
+	changed  time.Time
+
+	// Alive -> dead transitions.
+	ejections atomic.Uint64
+	stats     *stats.Stats
+	listener  Listener
+}
+
+type options struct {
+	proxyOpts []proxy.Option
+	listener  Listener
+}
+
+type Option func(*options)
+
+func WithProxyOptions(opts ...proxy.Option) Option {
+	return func(o *options) {
+		o.proxyOpts = append(o.proxyOpts, opts...)
+	}
+}
+
+func WithListener(l Listener) Option {
+	return func(o *options) {
+		o.listener = l
+	}
 }
 
-func New(rawURL string) (*ServerInst, error) {
+func New(rawURL string, opts ...Option) (*ServerInst, error) {
 	parsed, err := url.Parse(rawURL)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrBrokenURL, err)
 	}
+
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
 	return &ServerInst{
-		url:   parsed,
-		proxy: proxy.New(parsed),
-		alive: true,
+		url:      parsed,
+		proxy:    proxy.New(parsed, o.proxyOpts...),
+		alive:    true,
+		changed:  time.Now(),
+		stats:    stats.New(),
+		listener: o.listener,
 	}, nil
 }
 
@@ -52,19 +117,123 @@ func (s *ServerInst) IsAlive() bool {
 }
 
 func (s *ServerInst) SetAlive(alive bool) {
+	s.setAlive(alive, nil)
+}
+
+/*
+Failure is reported to listener as breaker trip.
+*/
+func (s *ServerInst) setAlive(alive bool, failure error) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	from := s.state()
+	if s.alive && !alive {
+		s.ejections.Add(1)
+	}
+	if s.alive != alive {
+		s.changed = time.Now()
+	}
 	s.alive = alive
+	to := s.state()
+	s.mu.Unlock()
+
+	s.notify(from, to, failure)
+}
+
+func (s *ServerInst) notify(from, to string, failure error) {
+	if s.listener == nil || from == to {
+		return
+	}
+	if failure != nil {
+		s.listener.BreakerTripped(s.URL(), failure)
+	}
+	s.listener.ServerStateChanged(s.URL(), from, to)
+}
+
+/*
+Must be called with mu held.
+*/
+func (s *ServerInst) state() string {
+	switch {
+	case s.draining:
+		return StateDraining
+	case !s.alive:
+		return StateEjected
+	}
+	return StateAlive
+}
+
+func (s *ServerInst) Ejections() uint64 {
+	return s.ejections.Load()
+}
+
+/*
+Draining server is removed from configuration, but still finishes in-flight requests.
+*/
+func (s *ServerInst) SetDraining(draining bool) {
+	s.mu.Lock()
+	from := s.state()
+	if s.draining != draining {
+		s.changed = time.Now()
+	}
+	s.draining = draining
+	to := s.state()
+	s.mu.Unlock()
+
+	s.notify(from, to, nil)
+}
+
+func (s *ServerInst) InFlight() int64 {
+	return s.stats.InFlight()
+}
+
+/*
+Connection proxied outside of Serve (TCP) is accounted as a request lasting until it is closed.
+Connect error ejects the server, like a failed request.
+*/
+func (s *ServerInst) BeginConn() func(err error) {
+	done := s.stats.Begin()
+	return func(err error) {
+		done(0, err != nil)
+		if err != nil {
+			s.setAlive(false, err)
+		}
+	}
+}
+
+func (s *ServerInst) Upgraded() int64 {
+	return s.proxy.Upgraded()
+}
+
+func (s *ServerInst) RecordHealthCheck(ok bool, err error) {
+	s.stats.RecordHealth(ok, err)
+}
+
+func (s *ServerInst) Snapshot() Snapshot {
+	s.mu.RLock()
+	state := s.state()
+	changed := s.changed
+	s.mu.RUnlock()
+
+	return Snapshot{
+		URL:            s.URL(),
+		State:          state,
+		StateChangedAt: changed,
+		Ejections:      s.ejections.Load(),
+		Upgraded:       s.proxy.Upgraded(),
+		Snapshot:       s.stats.Snapshot(),
+	}
 }
 
 func (s *ServerInst) Serve(w http.ResponseWriter, r *http.Request) error {
-	err := s.proxy.ServeAndReport(w, r)
+	done := s.stats.Begin()
+	status, err := s.proxy.ServeAndReport(w, r)
+	done(status, errors.Is(err, proxy.ErrTransport))
 	/*
 		err != nil
 		on 5xx HTTP errors.
 	*/
 	if err != nil {
-		s.SetAlive(false)
+		s.setAlive(false, err)
 		return err
 	}
 	return nil
@@ -0,0 +1,161 @@
+package stats
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Latency samples kept per server.
+	windowSize = 1024
+	// Samples older than that are ignored.
+	windowAge = time.Minute
+)
+
+const (
+	Class4xx       = "4xx"
+	Class5xx       = "5xx"
+	ClassTransport = "transport"
+)
+
+type sample struct {
+	at      time.Time
+	latency time.Duration
+}
+
+type HealthResult struct {
+	At    time.Time `json:"at"`
+	OK    bool      `json:"ok"`
+	Error string    `json:"error,omitempty"`
+}
+
+type Latency struct {
+	P50     time.Duration
+	P90     time.Duration
+	P99     time.Duration
+	Samples int
+}
+
+type Snapshot struct {
+	InFlight int64
+	Total    uint64
+	Errors   map[string]uint64
+	Latency  Latency
+	Health   *HealthResult
+}
+
+/*
+Stats counts traffic of a single server.
+Latency percentiles are computed over a rolling window of recent requests.
+*/
+type Stats struct {
+	inFlight  atomic.Int64
+	total     atomic.Uint64
+	err4xx    atomic.Uint64
+	err5xx    atomic.Uint64
+	transport atomic.Uint64
+
+	mu      sync.Mutex
+	samples [windowSize]sample
+	next    int
+	health  *HealthResult
+}
+
+func New() *Stats {
+	return &Stats{}
+}
+
+/*
+Marks request as in-flight. Returned func must be called once it is finished.
+*/
+func (s *Stats) Begin() func(status int, transportErr bool) {
+	s.inFlight.Add(1)
+	start := time.Now()
+	return func(status int, transportErr bool) {
+		s.inFlight.Add(-1)
+		s.total.Add(1)
+		switch {
+		case transportErr:
+			s.transport.Add(1)
+		case status >= 500:
+			s.err5xx.Add(1)
+		case status >= 400:
+			s.err4xx.Add(1)
+		}
+
+		s.mu.Lock()
+		s.samples[s.next] = sample{at: start, latency: time.Since(start)}
+		s.next = (s.next + 1) % windowSize
+		s.mu.Unlock()
+	}
+}
+
+func (s *Stats) InFlight() int64 {
+	return s.inFlight.Load()
+}
+
+func (s *Stats) RecordHealth(ok bool, err error) {
+	res := &HealthResult{At: time.Now(), OK: ok}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	s.mu.Lock()
+	s.health = res
+	s.mu.Unlock()
+}
+
+func (s *Stats) Snapshot() Snapshot {
+	snap := Snapshot{
+		InFlight: s.inFlight.Load(),
+		Total:    s.total.Load(),
+		Errors: map[string]uint64{
+			Class4xx:       s.err4xx.Load(),
+			Class5xx:       s.err5xx.Load(),
+			ClassTransport: s.transport.Load(),
+		},
+	}
+
+	cutoff := time.Now().Add(-windowAge)
+	latencies := make([]time.Duration, 0, windowSize)
+
+	s.mu.Lock()
+	for _, smp := range s.samples {
+		if !smp.at.IsZero() && smp.at.After(cutoff) {
+			latencies = append(latencies, smp.latency)
+		}
+	}
+	if s.health != nil {
+		h := *s.health
+		snap.Health = &h
+	}
+	s.mu.Unlock()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	snap.Latency = Latency{
+		P50:     percentile(latencies, 0.50),
+		P90:     percentile(latencies, 0.90),
+		P99:     percentile(latencies, 0.99),
+		Samples: len(latencies),
+	}
+	return snap
+}
+
+/*
+Nearest-rank percentile of sorted values.
+*/
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(float64(len(sorted))*p)) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
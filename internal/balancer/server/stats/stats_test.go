@@ -0,0 +1,46 @@
+package stats
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats_Counters(t *testing.T) {
+	s := New()
+
+	done := s.Begin()
+	require.Equal(t, int64(1), s.InFlight())
+	done(200, false)
+	s.Begin()(404, false)
+	s.Begin()(503, false)
+	s.Begin()(0, true)
+
+	snap := s.Snapshot()
+	require.Equal(t, int64(0), snap.InFlight)
+	require.Equal(t, uint64(4), snap.Total)
+	require.Equal(t, uint64(1), snap.Errors[Class4xx])
+	require.Equal(t, uint64(1), snap.Errors[Class5xx])
+	require.Equal(t, uint64(1), snap.Errors[ClassTransport])
+	require.Equal(t, 4, snap.Latency.Samples)
+	require.Nil(t, snap.Health)
+
+	s.RecordHealth(false, errors.New("refused"))
+	snap = s.Snapshot()
+	require.False(t, snap.Health.OK)
+	require.Equal(t, "refused", snap.Health.Error)
+}
+
+func TestPercentile(t *testing.T) {
+	values := make([]time.Duration, 100)
+	for i := range values {
+		values[i] = time.Duration(i+1) * time.Millisecond
+	}
+
+	require.Equal(t, 50*time.Millisecond, percentile(values, 0.50))
+	require.Equal(t, 90*time.Millisecond, percentile(values, 0.90))
+	require.Equal(t, 99*time.Millisecond, percentile(values, 0.99))
+	require.Equal(t, time.Duration(0), percentile(nil, 0.5))
+}
@@ -0,0 +1,376 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	"github.com/humanbelnik/load-balancer/internal/balancer/l4"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+	"github.com/humanbelnik/load-balancer/internal/proxyproto"
+)
+
+var (
+	ErrClosed      = errors.New("tcp proxy closed")
+	ErrNoBackend   = errors.New("no backend accepted connection")
+	ErrBadAddress  = errors.New("server URL has no host and port")
+	errIdleTimeout = errors.New("idle timeout")
+)
+
+/*
+Pool gives alive servers of upstream group.
+*/
+type Pool interface {
+	Alive() ([]server.Server, error)
+}
+
+type Policy interface {
+	Select(servers []server.Server) (server.Server, error)
+}
+
+/*
+Proxy accepts TCP connections and pipes each one to a server chosen by policy.
+If server can't be reached, next one is tried.
+*/
+type Proxy struct {
+	cfg    config.TCPConfig
+	pool   Pool
+	policy Policy
+	logger *slog.Logger
+	dialer net.Dialer
+
+	// PROXY protocol on both sides.
+	trusted       []netip.Prefix
+	headerTimeout time.Duration
+	sendVersion   int
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+type Option func(*Proxy)
+
+func WithLogger(logger *slog.Logger) Option {
+	return func(p *Proxy) {
+		p.logger = logger
+	}
+}
+
+/*
+Client address is taken from PROXY protocol header of trusted sources.
+*/
+func WithAcceptProxyProtocol(trusted []netip.Prefix, headerTimeout time.Duration) Option {
+	return func(p *Proxy) {
+		p.trusted = trusted
+		p.headerTimeout = headerTimeout
+	}
+}
+
+/*
+Header of given version is sent to server before client's data.
+*/
+func WithSendProxyProtocol(version int) Option {
+	return func(p *Proxy) {
+		p.sendVersion = version
+	}
+}
+
+func New(cfg config.TCPConfig, pool Pool, policy Policy, opts ...Option) *Proxy {
+	if cfg.ConnectTimeout == 0 {
+		cfg.ConnectTimeout = config.DefaultConnectTimeout
+	}
+	p := &Proxy{
+		cfg:    cfg,
+		pool:   pool,
+		policy: policy,
+		logger: slog.Default(),
+		dialer: net.Dialer{Timeout: cfg.ConnectTimeout},
+		conns:  make(map[net.Conn]struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+func (p *Proxy) Name() string {
+	return p.cfg.Name
+}
+
+func (p *Proxy) Addr() string {
+	return p.cfg.Listen
+}
+
+func (p *Proxy) ListenAndServe() error {
+	l, err := net.Listen("tcp", p.cfg.Listen)
+	if err != nil {
+		return err
+	}
+	if p.trusted != nil {
+		l = proxyproto.NewListener(l, p.trusted, p.headerTimeout)
+	}
+	return p.Serve(l)
+}
+
+/*
+Blocks until Shutdown, then returns ErrClosed.
+*/
+func (p *Proxy) Serve(l net.Listener) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		l.Close()
+		return ErrClosed
+	}
+	p.listener = l
+	p.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			p.mu.Lock()
+			closed := p.closed
+			p.mu.Unlock()
+			if closed {
+				return ErrClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		if !p.track(conn) {
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer p.untrack(conn)
+			p.handle(conn)
+		}()
+	}
+}
+
+/*
+Stops accepting connections and waits for open ones to finish,
+closes the rest when ctx is done.
+*/
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	if p.listener != nil {
+		p.listener.Close()
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		for conn := range p.conns {
+			conn.Close()
+		}
+		p.mu.Unlock()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (p *Proxy) track(conn net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	p.conns[conn] = struct{}{}
+	p.wg.Add(1)
+	return true
+}
+
+func (p *Proxy) untrack(conn net.Conn) {
+	p.mu.Lock()
+	delete(p.conns, conn)
+	p.mu.Unlock()
+	p.wg.Done()
+}
+
+func (p *Proxy) handle(client net.Conn) {
+	defer client.Close()
+	logger := p.logger.With(slog.String("listener", p.cfg.Name), slog.String("client", client.RemoteAddr().String()))
+	if pc, ok := client.(*proxyproto.Conn); ok {
+		if _, err := pc.Header(); err != nil {
+			logger.Warn("tcp connection rejected", slog.Any("err", err))
+			return
+		}
+	}
+
+	backend, done, err := p.connect(client, logger)
+	if err != nil {
+		logger.Warn("tcp connect failed", slog.Any("err", err))
+		return
+	}
+	defer backend.Close()
+
+	err = pipe(client, backend, p.cfg.IdleTimeout)
+	if done != nil {
+		done(nil)
+	}
+	logger.Debug("tcp connection closed", slog.String("server", backend.RemoteAddr().String()), slog.Any("err", err))
+}
+
+/*
+Tries alive servers until one accepts connection, failed ones are ejected.
+*/
+func (p *Proxy) connect(client net.Conn, logger *slog.Logger) (net.Conn, func(error), error) {
+	alive, err := p.pool.Alive()
+	if err != nil {
+		return nil, nil, err
+	}
+	for range alive {
+		s, err := l4.SelectServer(p.policy, alive, client.RemoteAddr())
+		if err != nil {
+			return nil, nil, err
+		}
+		done := l4.BeginConn(s)
+
+		conn, err := p.dial(s, client)
+		if err == nil {
+			return conn, done, nil
+		}
+		logger.Warn("backend failed", slog.String("server", s.URL()), slog.Any("err", err))
+		l4.EndConn(s, done, err)
+	}
+	return nil, nil, ErrNoBackend
+}
+
+func (p *Proxy) dial(s server.Server, client net.Conn) (net.Conn, error) {
+	addr, err := Address(s.URL())
+	if err != nil {
+		return nil, err
+	}
+	conn, err := p.dialer.Dial("tcp", addr)
+	if err != nil || p.sendVersion == 0 {
+		return conn, err
+	}
+	h := &proxyproto.Header{Version: p.sendVersion, Source: client.RemoteAddr(), Destination: client.LocalAddr()}
+	if err := proxyproto.Write(conn, h); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+/*
+Host and port of 'tcp://host:port' or 'udp://host:port' server URL.
+*/
+func Address(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Port() == "" {
+		return "", fmt.Errorf("%w: %s", ErrBadAddress, rawURL)
+	}
+	return u.Host, nil
+}
+
+/*
+Copies both directions until both are finished or one fails.
+EOF from one side is passed on as half-close.
+*/
+func pipe(client, backend net.Conn, idle time.Duration) error {
+	a := &activity{idle: idle}
+	a.touch()
+
+	errc := make(chan error, 2)
+	go func() { errc <- a.copy(backend, client) }()
+	go func() { errc <- a.copy(client, backend) }()
+
+	err := <-errc
+	if err != nil {
+		// Unblocks the other direction.
+		client.Close()
+		backend.Close()
+		<-errc
+		return err
+	}
+	return <-errc
+}
+
+/*
+Last time any direction has moved data.
+*/
+type activity struct {
+	idle time.Duration
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (a *activity) touch() {
+	a.mu.Lock()
+	a.last = time.Now()
+	a.mu.Unlock()
+}
+
+func (a *activity) since() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return time.Since(a.last)
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+func (a *activity) copy(dst, src net.Conn) error {
+	buf := make([]byte, 32*1024)
+	for {
+		if a.idle > 0 {
+			src.SetReadDeadline(time.Now().Add(a.idle))
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			a.touch()
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF):
+			if cw, ok := dst.(closeWriter); ok {
+				return cw.CloseWrite()
+			}
+			return nil
+		case errors.Is(err, net.ErrClosed):
+			return err
+		default:
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				return err
+			}
+			// Other direction may still be busy.
+			if a.since() >= a.idle {
+				return errIdleTimeout
+			}
+		}
+	}
+}
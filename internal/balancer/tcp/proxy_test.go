@@ -0,0 +1,210 @@
+package tcp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	"github.com/humanbelnik/load-balancer/internal/balancer/policy/rr"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+	"github.com/humanbelnik/load-balancer/internal/proxyproto"
+)
+
+type staticPool []server.Server
+
+func (p staticPool) Alive() ([]server.Server, error) {
+	var alive []server.Server
+	for _, s := range p {
+		if s.IsAlive() {
+			alive = append(alive, s)
+		}
+	}
+	return alive, nil
+}
+
+/*
+Echo server, returns its 'tcp://' URL.
+*/
+func echoServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return "tcp://" + l.Addr().String()
+}
+
+/*
+Address nobody listens on.
+*/
+func deadServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	return "tcp://" + addr
+}
+
+func newServer(t *testing.T, rawURL string) *server.ServerInst {
+	t.Helper()
+	s, err := server.New(rawURL)
+	require.NoError(t, err)
+	return s
+}
+
+func start(t *testing.T, cfg config.TCPConfig, servers ...server.Server) (*Proxy, string) {
+	t.Helper()
+	p := New(cfg, staticPool(servers), rr.New())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- p.Serve(l) }()
+	t.Cleanup(func() {
+		_ = p.Shutdown(context.Background())
+		require.ErrorIs(t, <-served, ErrClosed)
+	})
+	return p, l.Addr().String()
+}
+
+func TestProxy_RetriesConnect(t *testing.T) {
+	dead := newServer(t, deadServer(t))
+	echo := newServer(t, echoServer(t))
+	_, addr := start(t, config.TCPConfig{}, dead, echo)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	// Half-close is passed on, echo server closes its side after reply.
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	reply, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(reply))
+
+	require.False(t, dead.IsAlive())
+	require.True(t, echo.IsAlive())
+	require.Eventually(t, func() bool { return echo.InFlight() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestProxy_NoBackend(t *testing.T) {
+	_, addr := start(t, config.TCPConfig{}, newServer(t, deadServer(t)))
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestProxy_IdleTimeout(t *testing.T) {
+	echo := newServer(t, echoServer(t))
+	_, addr := start(t, config.TCPConfig{IdleTimeout: 100 * time.Millisecond}, echo)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	buf := make([]byte, 4)
+	for range 3 {
+		time.Sleep(50 * time.Millisecond)
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+	}
+	require.EqualValues(t, 1, echo.InFlight())
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = conn.Read(buf)
+	require.ErrorIs(t, err, io.EOF)
+	require.Eventually(t, func() bool { return echo.InFlight() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestProxy_Shutdown(t *testing.T) {
+	echo := newServer(t, echoServer(t))
+	p, addr := start(t, config.TCPConfig{}, echo)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, make([]byte, 4))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, p.Shutdown(ctx), context.DeadlineExceeded)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+
+	_, err = net.Dial("tcp", addr)
+	require.Error(t, err)
+}
+
+func TestAddress(t *testing.T) {
+	addr, err := Address("tcp://db.internal:5432")
+	require.NoError(t, err)
+	require.Equal(t, "db.internal:5432", addr)
+
+	_, err = Address("tcp://db.internal")
+	require.ErrorIs(t, err, ErrBadAddress)
+}
+
+func TestProxy_ProxyProtocol(t *testing.T) {
+	loopback := []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+
+	// Replies with client address it has got from header.
+	bl, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer bl.Close()
+	go func() {
+		conn, err := proxyproto.NewListener(bl, loopback, time.Second).Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.WriteString(conn, conn.RemoteAddr().String())
+	}()
+
+	p := New(config.TCPConfig{}, staticPool{newServer(t, "tcp://"+bl.Addr().String())}, rr.New(),
+		WithAcceptProxyProtocol(loopback, time.Second), WithSendProxyProtocol(proxyproto.V2))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = p.Serve(proxyproto.NewListener(l, p.trusted, p.headerTimeout)) }()
+	defer p.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	client := net.TCPAddrFromAddrPort(netip.MustParseAddrPort("203.0.113.7:4000"))
+	require.NoError(t, proxyproto.Write(conn, &proxyproto.Header{Version: proxyproto.V1, Source: client, Destination: conn.RemoteAddr()}))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	reply, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, "203.0.113.7:4000", string(reply))
+}
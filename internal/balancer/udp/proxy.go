@@ -0,0 +1,314 @@
+package udp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	"github.com/humanbelnik/load-balancer/internal/balancer/l4"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+	"github.com/humanbelnik/load-balancer/internal/balancer/tcp"
+)
+
+var (
+	ErrClosed    = errors.New("udp proxy closed")
+	ErrNoBackend = errors.New("no backend for session")
+)
+
+// Largest UDP payload.
+const maxDatagram = 64 * 1024
+
+/*
+Pool gives alive servers of upstream group.
+*/
+type Pool interface {
+	Alive() ([]server.Server, error)
+}
+
+type Policy interface {
+	Select(servers []server.Server) (server.Server, error)
+}
+
+/*
+Proxy receives datagrams and forwards each client's ones to the server
+chosen by policy for its session. Replies are sent back from listener address.
+*/
+type Proxy struct {
+	cfg    config.UDPConfig
+	pool   Pool
+	policy Policy
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	conn     net.PacketConn
+	sessions map[string]*session
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+type Option func(*Proxy)
+
+func WithLogger(logger *slog.Logger) Option {
+	return func(p *Proxy) {
+		p.logger = logger
+	}
+}
+
+func New(cfg config.UDPConfig, pool Pool, policy Policy, opts ...Option) *Proxy {
+	if cfg.SessionTimeout == 0 {
+		cfg.SessionTimeout = config.DefaultSessionTimeout
+	}
+	p := &Proxy{
+		cfg:      cfg,
+		pool:     pool,
+		policy:   policy,
+		logger:   slog.Default(),
+		sessions: make(map[string]*session),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+func (p *Proxy) Name() string {
+	return p.cfg.Name
+}
+
+func (p *Proxy) Addr() string {
+	return p.cfg.Listen
+}
+
+/*
+Number of open sessions.
+*/
+func (p *Proxy) Sessions() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.sessions)
+}
+
+func (p *Proxy) ListenAndServe() error {
+	conn, err := net.ListenPacket("udp", p.cfg.Listen)
+	if err != nil {
+		return err
+	}
+	return p.Serve(conn)
+}
+
+/*
+Blocks until Shutdown, then returns ErrClosed.
+*/
+func (p *Proxy) Serve(conn net.PacketConn) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		conn.Close()
+		return ErrClosed
+	}
+	p.conn = conn
+	p.mu.Unlock()
+
+	buf := make([]byte, maxDatagram)
+	for {
+		n, client, err := conn.ReadFrom(buf)
+		if err != nil {
+			p.mu.Lock()
+			closed := p.closed
+			p.mu.Unlock()
+			if closed {
+				return ErrClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		s, err := p.session(client)
+		if err != nil {
+			p.logger.Warn("udp datagram dropped", slog.String("listener", p.cfg.Name), slog.String("client", client.String()), slog.Any("err", err))
+			continue
+		}
+		s.touch()
+		if _, err := s.backend.Write(buf[:n]); err != nil {
+			p.end(s, err)
+		}
+	}
+}
+
+/*
+Stops receiving datagrams and drops all sessions.
+Replies still on their way are lost, there is nowhere to send them from.
+*/
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	sessions := make([]*session, 0, len(p.sessions))
+	for _, s := range p.sessions {
+		sessions = append(sessions, s)
+	}
+	p.mu.Unlock()
+
+	for _, s := range sessions {
+		p.end(s, nil)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/*
+Client's datagrams go to one server until session expires.
+*/
+type session struct {
+	client  net.Addr
+	server  server.Server
+	backend net.Conn
+	done    func(error)
+	ended   sync.Once
+	// Unix nanoseconds of last datagram in any direction.
+	last atomic.Int64
+}
+
+func (s *session) touch() {
+	s.last.Store(time.Now().UnixNano())
+}
+
+func (s *session) idle() time.Duration {
+	return time.Since(time.Unix(0, s.last.Load()))
+}
+
+/*
+Existing session of client or a new one with server chosen by policy.
+Servers which address can't be used are ejected, next one is tried.
+*/
+func (p *Proxy) session(client net.Addr) (*session, error) {
+	key := client.String()
+	p.mu.Lock()
+	s, ok := p.sessions[key]
+	p.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+
+	alive, err := p.pool.Alive()
+	if err != nil {
+		return nil, err
+	}
+	for range alive {
+		srv, err := l4.SelectServer(p.policy, alive, client)
+		if err != nil {
+			return nil, err
+		}
+		// Session is counted as one connection.
+		done := l4.BeginConn(srv)
+
+		backend, err := dial(srv)
+		if err != nil {
+			p.logger.Warn("backend failed", slog.String("server", srv.URL()), slog.Any("err", err))
+			l4.EndConn(srv, done, err)
+			continue
+		}
+
+		s := &session{client: client, server: srv, backend: backend, done: done}
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			backend.Close()
+			l4.EndConn(srv, done, nil)
+			return nil, ErrClosed
+		}
+		p.sessions[key] = s
+		p.wg.Add(1)
+		p.mu.Unlock()
+
+		go p.reply(s)
+		return s, nil
+	}
+	return nil, ErrNoBackend
+}
+
+func dial(s server.Server) (net.Conn, error) {
+	addr, err := tcp.Address(s.URL())
+	if err != nil {
+		return nil, err
+	}
+	return net.Dial("udp", addr)
+}
+
+/*
+Sends server's datagrams back to client until session is idle for too long.
+Refused datagram (ICMP port unreachable) ends session and ejects server.
+*/
+func (p *Proxy) reply(s *session) {
+	defer p.wg.Done()
+
+	buf := make([]byte, maxDatagram)
+	for {
+		s.backend.SetReadDeadline(time.Now().Add(p.cfg.SessionTimeout))
+		n, err := s.backend.Read(buf)
+		if n > 0 {
+			s.touch()
+			if _, werr := p.conn.WriteTo(buf[:n], s.client); werr != nil {
+				p.end(s, nil)
+				return
+			}
+		}
+		if err == nil {
+			continue
+		}
+
+		var netErr net.Error
+		switch {
+		case errors.Is(err, net.ErrClosed):
+			p.end(s, nil)
+			return
+		case errors.As(err, &netErr) && netErr.Timeout():
+			// Client may still be sending.
+			if s.idle() >= p.cfg.SessionTimeout {
+				p.logger.Debug("udp session expired", slog.String("listener", p.cfg.Name), slog.String("client", s.client.String()), slog.String("server", s.server.URL()))
+				p.end(s, nil)
+				return
+			}
+		default:
+			p.logger.Warn("backend failed", slog.String("server", s.server.URL()), slog.Any("err", err))
+			p.end(s, err)
+			return
+		}
+	}
+}
+
+/*
+Removes session, err ejects its server.
+*/
+func (p *Proxy) end(s *session, err error) {
+	s.ended.Do(func() {
+		p.mu.Lock()
+		if p.sessions[s.client.String()] == s {
+			delete(p.sessions, s.client.String())
+		}
+		p.mu.Unlock()
+
+		s.backend.Close()
+		l4.EndConn(s.server, s.done, err)
+	})
+}
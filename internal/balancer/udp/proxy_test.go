@@ -0,0 +1,172 @@
+package udp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	"github.com/humanbelnik/load-balancer/internal/balancer/policy/ch"
+	"github.com/humanbelnik/load-balancer/internal/balancer/policy/rr"
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+)
+
+type staticPool []server.Server
+
+func (p staticPool) Alive() ([]server.Server, error) {
+	var alive []server.Server
+	for _, s := range p {
+		if s.IsAlive() {
+			alive = append(alive, s)
+		}
+	}
+	return alive, nil
+}
+
+/*
+Replies with its name and datagram, returns its 'udp://' URL.
+*/
+func echoServer(t *testing.T, name string) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, maxDatagram)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(append([]byte(name+":"), buf[:n]...), addr)
+		}
+	}()
+	return "udp://" + conn.LocalAddr().String()
+}
+
+/*
+Port nobody listens on, datagrams are refused.
+*/
+func deadServer(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := conn.LocalAddr().String()
+	conn.Close()
+	return "udp://" + addr
+}
+
+func newServer(t *testing.T, rawURL string) *server.ServerInst {
+	t.Helper()
+	s, err := server.New(rawURL)
+	require.NoError(t, err)
+	return s
+}
+
+func start(t *testing.T, cfg config.UDPConfig, policy Policy, servers ...server.Server) (*Proxy, string) {
+	t.Helper()
+	p := New(cfg, staticPool(servers), policy)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- p.Serve(conn) }()
+	t.Cleanup(func() {
+		_ = p.Shutdown(context.Background())
+		require.ErrorIs(t, <-served, ErrClosed)
+	})
+	return p, conn.LocalAddr().String()
+}
+
+func dialClient(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("udp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func exchange(t *testing.T, conn net.Conn, msg string) string {
+	t.Helper()
+	_, err := conn.Write([]byte(msg))
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, maxDatagram)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+func TestProxy_Sessions(t *testing.T) {
+	p, addr := start(t, config.UDPConfig{}, rr.New(),
+		newServer(t, echoServer(t, "a")), newServer(t, echoServer(t, "b")))
+
+	first := dialClient(t, addr)
+	second := dialClient(t, addr)
+	require.Equal(t, "a:ping", exchange(t, first, "ping"))
+	require.Equal(t, "b:ping", exchange(t, second, "ping"))
+
+	// Client sticks to its server.
+	require.Equal(t, "a:again", exchange(t, first, "again"))
+	require.Equal(t, "b:again", exchange(t, second, "again"))
+	require.Equal(t, 2, p.Sessions())
+}
+
+func TestProxy_SessionTimeout(t *testing.T) {
+	a := newServer(t, echoServer(t, "a"))
+	p, addr := start(t, config.UDPConfig{SessionTimeout: 100 * time.Millisecond}, rr.New(), a)
+
+	client := dialClient(t, addr)
+	require.Equal(t, "a:ping", exchange(t, client, "ping"))
+	require.Equal(t, int64(1), a.InFlight())
+
+	require.Eventually(t, func() bool { return p.Sessions() == 0 }, 2*time.Second, 20*time.Millisecond)
+	require.Equal(t, int64(0), a.InFlight())
+
+	// New session is opened on the next datagram.
+	require.Equal(t, "a:ping", exchange(t, client, "ping"))
+}
+
+func TestProxy_RefusedServerEjected(t *testing.T) {
+	dead := newServer(t, deadServer(t))
+	live := newServer(t, echoServer(t, "live"))
+	p, addr := start(t, config.UDPConfig{}, rr.New(), dead, live)
+
+	client := dialClient(t, addr)
+	_, err := client.Write([]byte("lost"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return !dead.IsAlive() }, 2*time.Second, 20*time.Millisecond)
+	require.Equal(t, 0, p.Sessions())
+
+	require.Equal(t, "live:ping", exchange(t, client, "ping"))
+}
+
+func TestProxy_ConsistentHash(t *testing.T) {
+	_, addr := start(t, config.UDPConfig{}, ch.New(),
+		newServer(t, echoServer(t, "a")), newServer(t, echoServer(t, "b")), newServer(t, echoServer(t, "c")))
+
+	// Clients from one IP land on the same server, whatever the source port.
+	want := exchange(t, dialClient(t, addr), "ping")
+	for range 5 {
+		require.Equal(t, want, exchange(t, dialClient(t, addr), "ping"))
+	}
+}
+
+func TestProxy_ShutdownDropsSessions(t *testing.T) {
+	a := newServer(t, echoServer(t, "a"))
+	p := New(config.UDPConfig{}, staticPool{a}, rr.New())
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- p.Serve(conn) }()
+
+	require.Equal(t, "a:ping", exchange(t, dialClient(t, conn.LocalAddr().String()), "ping"))
+	require.Equal(t, 1, p.Sessions())
+
+	require.NoError(t, p.Shutdown(context.Background()))
+	require.ErrorIs(t, <-served, ErrClosed)
+	require.Equal(t, 0, p.Sessions())
+	require.Equal(t, int64(0), a.InFlight())
+}
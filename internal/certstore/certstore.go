@@ -0,0 +1,260 @@
+package certstore
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/humanbelnik/load-balancer/internal/certstore/config"
+)
+
+var (
+	ErrNoCertificates = errors.New("no certificates configured")
+	ErrLoad           = errors.New("cannot load certificate")
+	ErrMinVersion     = errors.New("unknown TLS version")
+	ErrCipherSuite    = errors.New("unknown cipher suite")
+)
+
+/*
+Certificates by SNI name, swapped as a whole on reload.
+*/
+type certificates struct {
+	exact    map[string]*tls.Certificate
+	wildcard map[string]*tls.Certificate
+	fallback *tls.Certificate
+}
+
+/*
+Store picks certificate by SNI and reloads files on change.
+Handshakes in progress and established connections are not affected by reload.
+*/
+type Store struct {
+	cfgs   []config.CertificateConfig
+	certs  atomic.Pointer[certificates]
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	mtimes map[string]time.Time
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+type Option func(*Store)
+
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Store) {
+		s.logger = logger
+	}
+}
+
+func New(cfgs []config.CertificateConfig, opts ...Option) (*Store, error) {
+	if len(cfgs) == 0 {
+		return nil, ErrNoCertificates
+	}
+	s := &Store{
+		cfgs:   cfgs,
+		logger: slog.Default(),
+		mtimes: make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+/*
+Loads every certificate again. On failure previous ones are kept.
+*/
+func (s *Store) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	certs := &certificates{
+		exact:    make(map[string]*tls.Certificate),
+		wildcard: make(map[string]*tls.Certificate),
+	}
+	mtimes := make(map[string]time.Time)
+	for _, cfg := range s.cfgs {
+		cert, err := load(cfg, mtimes)
+		if err != nil {
+			return err
+		}
+		if certs.fallback == nil {
+			certs.fallback = cert
+		}
+
+		names := append([]string(nil), cfg.Names...)
+		names = append(names, cert.Leaf.DNSNames...)
+		if len(cert.Leaf.DNSNames) == 0 && cert.Leaf.Subject.CommonName != "" {
+			names = append(names, cert.Leaf.Subject.CommonName)
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			// First certificate wins, as with config order.
+			if suffix, ok := strings.CutPrefix(name, "*."); ok {
+				if _, exists := certs.wildcard[suffix]; !exists {
+					certs.wildcard[suffix] = cert
+				}
+				continue
+			}
+			if _, exists := certs.exact[name]; !exists {
+				certs.exact[name] = cert
+			}
+		}
+	}
+
+	s.certs.Store(certs)
+	s.mtimes = mtimes
+	return nil
+}
+
+func load(cfg config.CertificateConfig, mtimes map[string]time.Time) (*tls.Certificate, error) {
+	for _, path := range []string{cfg.CertFile, cfg.KeyFile, cfg.OCSPFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrLoad, err)
+		}
+		mtimes[path] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrLoad, cfg.CertFile, err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrLoad, cfg.CertFile, err)
+		}
+	}
+	if cfg.OCSPFile != "" {
+		if cert.OCSPStaple, err = os.ReadFile(cfg.OCSPFile); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrLoad, err)
+		}
+	}
+	return &cert, nil
+}
+
+/*
+Used as tls.Config.GetCertificate.
+*/
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs := s.certs.Load()
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name == "" {
+		return certs.fallback, nil
+	}
+	if cert, ok := certs.exact[name]; ok {
+		return cert, nil
+	}
+	// 'a.b.example.com' is matched by '*.b.example.com' only, like in certificates.
+	if _, parent, ok := strings.Cut(name, "."); ok {
+		if cert, ok := certs.wildcard[parent]; ok {
+			return cert, nil
+		}
+	}
+	return certs.fallback, nil
+}
+
+/*
+Reports whether any file was modified since the last load.
+*/
+func (s *Store) changed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for path, mtime := range s.mtimes {
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().Equal(mtime) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Polls files and reloads certificates once they change.
+*/
+func (s *Store) Watch(interval time.Duration) {
+	s.quit = make(chan struct{})
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !s.changed() {
+					continue
+				}
+				if err := s.Reload(); err != nil {
+					s.logger.Error("certificates reload failed, keeping previous ones", slog.Any("err", err))
+					continue
+				}
+				s.logger.Info("certificates reloaded")
+			case <-s.quit:
+				return
+			}
+		}
+	}()
+}
+
+func (s *Store) Close() error {
+	if s.quit != nil {
+		close(s.quit)
+		<-s.done
+	}
+	return nil
+}
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+/*
+Server side TLS config using store certificates.
+*/
+func TLSConfig(cfg config.TLSConfig, store *Store) (*tls.Config, error) {
+	minVersion, ok := versions[cfg.MinVersion]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrMinVersion, cfg.MinVersion)
+	}
+
+	suites := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		suites[cs.Name] = cs.ID
+	}
+	for _, cs := range tls.InsecureCipherSuites() {
+		suites[cs.Name] = cs.ID
+	}
+	var ciphers []uint16
+	for _, name := range cfg.CipherSuites {
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrCipherSuite, name)
+		}
+		ciphers = append(ciphers, id)
+	}
+
+	return &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   ciphers,
+		GetCertificate: store.GetCertificate,
+	}, nil
+}
@@ -0,0 +1,110 @@
+package certstore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/certstore/config"
+)
+
+/*
+Writes self-signed certificate for given names, returns its config.
+*/
+func writeCert(t *testing.T, dir, name string, serial int64, dnsNames ...string) config.CertificateConfig {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	cfg := config.CertificateConfig{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	require.NoError(t, os.WriteFile(cfg.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(cfg.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return cfg
+}
+
+func serial(t *testing.T, s *Store, sni string) int64 {
+	t.Helper()
+	cert, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: sni})
+	require.NoError(t, err)
+	return cert.Leaf.SerialNumber.Int64()
+}
+
+func TestStore_SNI(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New([]config.CertificateConfig{
+		writeCert(t, dir, "default", 1, "example.com"),
+		writeCert(t, dir, "api", 2, "api.example.com"),
+		writeCert(t, dir, "wild", 3, "*.apps.example.com"),
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, int64(1), serial(t, s, ""))
+	require.Equal(t, int64(1), serial(t, s, "unknown.org"))
+	require.Equal(t, int64(2), serial(t, s, "API.example.com"))
+	require.Equal(t, int64(3), serial(t, s, "shop.apps.example.com"))
+	require.Equal(t, int64(1), serial(t, s, "a.shop.apps.example.com"))
+}
+
+func TestStore_Reload(t *testing.T) {
+	dir := t.TempDir()
+	cfg := writeCert(t, dir, "site", 1, "example.com")
+	s, err := New([]config.CertificateConfig{cfg})
+	require.NoError(t, err)
+	require.False(t, s.changed())
+
+	writeCert(t, dir, "site", 2, "example.com")
+	// Make sure mtime differs on coarse filesystems.
+	future := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(cfg.CertFile, future, future))
+	require.True(t, s.changed())
+
+	require.NoError(t, s.Reload())
+	require.Equal(t, int64(2), serial(t, s, "example.com"))
+
+	// Broken files keep previous certificate.
+	require.NoError(t, os.WriteFile(cfg.KeyFile, []byte("garbage"), 0o600))
+	require.ErrorIs(t, s.Reload(), ErrLoad)
+	require.Equal(t, int64(2), serial(t, s, "example.com"))
+}
+
+func TestTLSConfig(t *testing.T) {
+	_, err := TLSConfig(config.TLSConfig{MinVersion: "1.4"}, nil)
+	require.ErrorIs(t, err, ErrMinVersion)
+
+	_, err = TLSConfig(config.TLSConfig{MinVersion: "1.2", CipherSuites: []string{"TLS_NOPE"}}, nil)
+	require.ErrorIs(t, err, ErrCipherSuite)
+
+	cfg, err := TLSConfig(config.TLSConfig{
+		MinVersion:   "1.2",
+		CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+	}, &Store{})
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion)
+	require.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, cfg.CipherSuites)
+}
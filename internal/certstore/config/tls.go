@@ -0,0 +1,25 @@
+package config
+
+import "time"
+
+type CertificateConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// DER encoded OCSP response stapled to handshakes, optional.
+	OCSPFile string `yaml:"ocsp_file"`
+	// Served for these SNI names in addition to names from certificate.
+	Names []string `yaml:"names"`
+}
+
+type TLSConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Addr    string `yaml:"addr" env-default:"localhost:8443"`
+	// '1.0', '1.1', '1.2' or '1.3'.
+	MinVersion string `yaml:"min_version" env-default:"1.2"`
+	// Go names, eg. 'TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256'. Only affect TLS 1.2 and older.
+	CipherSuites []string `yaml:"cipher_suites"`
+	// First one is served to clients without matching SNI.
+	Certificates []CertificateConfig `yaml:"certificates"`
+	// Files are checked for changes that often, 0 disables polling (SIGHUP still works).
+	ReloadInterval time.Duration `yaml:"reload_interval" env-default:"10s"`
+}
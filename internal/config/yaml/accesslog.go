@@ -0,0 +1,34 @@
+package yaml_config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/humanbelnik/load-balancer/internal/accesslog/config"
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+var (
+	ErrCannotLoadAccessLog = errors.New("cannot load access log config")
+)
+
+type AccessLogYAMLLoader struct{}
+
+func NewAccessLogLoader() *AccessLogYAMLLoader {
+	return &AccessLogYAMLLoader{}
+}
+
+type AccessLogWrapper struct {
+	AccessLog config.AccessLogConfig `yaml:"access_log"`
+}
+
+func (l *AccessLogYAMLLoader) Load(path string) (config.AccessLogConfig, error) {
+	var cfg AccessLogWrapper
+
+	err := cleanenv.ReadConfig(path, &cfg)
+	if err != nil {
+		return config.AccessLogConfig{}, fmt.Errorf("%w: %w", ErrCannotLoadAccessLog, err)
+	}
+
+	return cfg.AccessLog, nil
+}
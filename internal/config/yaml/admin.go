@@ -0,0 +1,34 @@
+package yaml_config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/humanbelnik/load-balancer/internal/admin/config"
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+var (
+	ErrCannotLoadAdmin = errors.New("cannot load admin config")
+)
+
+type AdminYAMLLoader struct{}
+
+func NewAdminLoader() *AdminYAMLLoader {
+	return &AdminYAMLLoader{}
+}
+
+type AdminWrapper struct {
+	Admin config.AdminConfig `yaml:"admin"`
+}
+
+func (l *AdminYAMLLoader) Load(path string) (config.AdminConfig, error) {
+	var cfg AdminWrapper
+
+	err := cleanenv.ReadConfig(path, &cfg)
+	if err != nil {
+		return config.AdminConfig{}, fmt.Errorf("%w: %w", ErrCannotLoadAdmin, err)
+	}
+
+	return cfg.Admin, nil
+}
@@ -0,0 +1,34 @@
+package yaml_config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+var (
+	ErrCannotLoadConcurrency = errors.New("cannot load concurrency config")
+)
+
+type ConcurrencyYAMLLoader struct{}
+
+func NewConcurrencyLoader() *ConcurrencyYAMLLoader {
+	return &ConcurrencyYAMLLoader{}
+}
+
+type ConcurrencyWrapper struct {
+	Concurrency config.ConcurrencyConfig `yaml:"concurrency"`
+}
+
+func (l *ConcurrencyYAMLLoader) Load(path string) (config.ConcurrencyConfig, error) {
+	var cfg ConcurrencyWrapper
+
+	err := cleanenv.ReadConfig(path, &cfg)
+	if err != nil {
+		return config.ConcurrencyConfig{}, fmt.Errorf("%w: %w", ErrCannotLoadConcurrency, err)
+	}
+
+	return cfg.Concurrency, nil
+}
@@ -0,0 +1,34 @@
+package yaml_config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+var (
+	ErrCannotLoadHealthCheck = errors.New("cannot load health check config")
+)
+
+type HealthCheckYAMLLoader struct{}
+
+func NewHealthCheckLoader() *HealthCheckYAMLLoader {
+	return &HealthCheckYAMLLoader{}
+}
+
+type HealthCheckWrapper struct {
+	HealthCheck config.HealthCheckConfig `yaml:"health_check"`
+}
+
+func (l *HealthCheckYAMLLoader) Load(path string) (config.HealthCheckConfig, error) {
+	var cfg HealthCheckWrapper
+
+	err := cleanenv.ReadConfig(path, &cfg)
+	if err != nil {
+		return config.HealthCheckConfig{}, fmt.Errorf("%w: %w", ErrCannotLoadHealthCheck, err)
+	}
+
+	return cfg.HealthCheck, nil
+}
@@ -0,0 +1,34 @@
+package yaml_config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+var (
+	ErrCannotLoadHTTP2 = errors.New("cannot load HTTP/2 config")
+)
+
+type HTTP2YAMLLoader struct{}
+
+func NewHTTP2Loader() *HTTP2YAMLLoader {
+	return &HTTP2YAMLLoader{}
+}
+
+type HTTP2Wrapper struct {
+	HTTP2 config.HTTP2Config `yaml:"http2"`
+}
+
+func (l *HTTP2YAMLLoader) Load(path string) (config.HTTP2Config, error) {
+	var cfg HTTP2Wrapper
+
+	err := cleanenv.ReadConfig(path, &cfg)
+	if err != nil {
+		return config.HTTP2Config{}, fmt.Errorf("%w: %w", ErrCannotLoadHTTP2, err)
+	}
+
+	return cfg.HTTP2, nil
+}
@@ -0,0 +1,34 @@
+package yaml_config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+var (
+	ErrCannotLoadProxyProtocol = errors.New("cannot load PROXY protocol config")
+)
+
+type ProxyProtocolYAMLLoader struct{}
+
+func NewProxyProtocolLoader() *ProxyProtocolYAMLLoader {
+	return &ProxyProtocolYAMLLoader{}
+}
+
+type ProxyProtocolWrapper struct {
+	ProxyProtocol config.ProxyProtocolConfig `yaml:"proxy_protocol"`
+}
+
+func (l *ProxyProtocolYAMLLoader) Load(path string) (config.ProxyProtocolConfig, error) {
+	var cfg ProxyProtocolWrapper
+
+	err := cleanenv.ReadConfig(path, &cfg)
+	if err != nil {
+		return config.ProxyProtocolConfig{}, fmt.Errorf("%w: %w", ErrCannotLoadProxyProtocol, err)
+	}
+
+	return cfg.ProxyProtocol, nil
+}
@@ -0,0 +1,34 @@
+package yaml_config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/humanbelnik/load-balancer/internal/requestid/config"
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+var (
+	ErrCannotLoadRequestID = errors.New("cannot load request id config")
+)
+
+type RequestIDYAMLLoader struct{}
+
+func NewRequestIDLoader() *RequestIDYAMLLoader {
+	return &RequestIDYAMLLoader{}
+}
+
+type RequestIDWrapper struct {
+	RequestID config.RequestIDConfig `yaml:"request_id"`
+}
+
+func (l *RequestIDYAMLLoader) Load(path string) (config.RequestIDConfig, error) {
+	var cfg RequestIDWrapper
+
+	err := cleanenv.ReadConfig(path, &cfg)
+	if err != nil {
+		return config.RequestIDConfig{}, fmt.Errorf("%w: %w", ErrCannotLoadRequestID, err)
+	}
+
+	return cfg.RequestID, nil
+}
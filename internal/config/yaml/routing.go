@@ -0,0 +1,49 @@
+package yaml_config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+var (
+	ErrCannotLoadRouting = errors.New("cannot load routing config")
+	ErrDefaultUpstream   = errors.New("both 'servers' and 'default' upstream are set")
+)
+
+type RoutingYAMLLoader struct{}
+
+func NewRoutingLoader() *RoutingYAMLLoader {
+	return &RoutingYAMLLoader{}
+}
+
+type RoutingWrapper struct {
+	Servers              []string `yaml:"servers"`
+	config.RoutingConfig `yaml:",inline"`
+}
+
+/*
+Top-level 'servers' list becomes 'default' upstream group.
+*/
+func (l *RoutingYAMLLoader) Load(path string) (config.RoutingConfig, error) {
+	var cfg RoutingWrapper
+
+	err := cleanenv.ReadConfig(path, &cfg)
+	if err != nil {
+		return config.RoutingConfig{}, fmt.Errorf("%w: %w", ErrCannotLoadRouting, err)
+	}
+
+	routing := cfg.RoutingConfig
+	if routing.Upstreams == nil {
+		routing.Upstreams = make(map[string]config.UpstreamConfig)
+	}
+	if len(cfg.Servers) > 0 {
+		if _, exists := routing.Upstreams[config.DefaultUpstream]; exists {
+			return config.RoutingConfig{}, fmt.Errorf("%w: %w", ErrCannotLoadRouting, ErrDefaultUpstream)
+		}
+		routing.Upstreams[config.DefaultUpstream] = config.UpstreamConfig{Servers: cfg.Servers}
+	}
+	return routing, nil
+}
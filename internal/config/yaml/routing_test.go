@@ -0,0 +1,93 @@
+package yaml_config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestRoutingLoader(t *testing.T) {
+	path := writeConfig(t, `
+servers:
+  - http://localhost:9001
+upstreams:
+  api:
+    servers: [http://localhost:9101, http://localhost:9102]
+    policy: least_connections
+    health_check:
+      enabled: true
+      path: /healthz
+routes:
+  - name: api
+    priority: 10
+    hosts: [api.example.com, "*.api.example.com"]
+    path_prefix: /v1/
+    methods: [GET]
+    headers:
+      X-Canary: ""
+    upstream: api
+    flush_interval: 100ms
+  - name: app
+    split:
+      - upstream: default
+        weight: 95
+      - upstream: api
+        weight: 5
+    sticky:
+      header: X-User-ID
+`)
+	cfg, err := NewRoutingLoader().Load(path)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"http://localhost:9001"}, cfg.Upstreams[config.DefaultUpstream].Servers)
+	api := cfg.Upstreams["api"]
+	require.Equal(t, []string{"http://localhost:9101", "http://localhost:9102"}, api.Servers)
+	require.Equal(t, config.PolicyLeastConnections, api.Policy)
+	require.NotNil(t, api.HealthCheck)
+	require.Equal(t, "/healthz", api.HealthCheck.Path)
+	require.Nil(t, api.Concurrency)
+
+	require.Len(t, cfg.Routes, 2)
+	r := cfg.Routes[0]
+	require.Equal(t, 10, r.Priority)
+	require.Equal(t, []string{"api.example.com", "*.api.example.com"}, r.Hosts)
+	require.Equal(t, map[string]string{"X-Canary": ""}, r.Headers)
+	require.Equal(t, 100*time.Millisecond, r.FlushInterval)
+	require.Equal(t, []config.SplitConfig{{Upstream: "default", Weight: 95}, {Upstream: "api", Weight: 5}}, cfg.Routes[1].Split)
+	require.Equal(t, "X-User-ID", cfg.Routes[1].Sticky.Header)
+}
+
+func TestRoutingLoader_ServersOnly(t *testing.T) {
+	cfg, err := NewRoutingLoader().Load(writeConfig(t, "servers: [http://localhost:9001]\n"))
+	require.NoError(t, err)
+	require.Len(t, cfg.Upstreams, 1)
+	require.Empty(t, cfg.Routes)
+}
+
+func TestRoutingLoader_DefaultTwice(t *testing.T) {
+	_, err := NewRoutingLoader().Load(writeConfig(t, `
+servers: [http://localhost:9001]
+upstreams:
+  default:
+    servers: [http://localhost:9002]
+`))
+	require.ErrorIs(t, err, ErrDefaultUpstream)
+	require.ErrorIs(t, err, ErrCannotLoadRouting)
+}
+
+func TestRoutingLoader_Missing(t *testing.T) {
+	_, err := NewRoutingLoader().Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	require.ErrorIs(t, err, ErrCannotLoadRouting)
+}
@@ -0,0 +1,34 @@
+package yaml_config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+var (
+	ErrCannotLoadTCP = errors.New("cannot load TCP config")
+)
+
+type TCPYAMLLoader struct{}
+
+func NewTCPLoader() *TCPYAMLLoader {
+	return &TCPYAMLLoader{}
+}
+
+type TCPWrapper struct {
+	TCP []config.TCPConfig `yaml:"tcp"`
+}
+
+func (l *TCPYAMLLoader) Load(path string) ([]config.TCPConfig, error) {
+	var cfg TCPWrapper
+
+	err := cleanenv.ReadConfig(path, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCannotLoadTCP, err)
+	}
+
+	return cfg.TCP, nil
+}
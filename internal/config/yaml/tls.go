@@ -0,0 +1,34 @@
+package yaml_config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/humanbelnik/load-balancer/internal/certstore/config"
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+var (
+	ErrCannotLoadTLS = errors.New("cannot load TLS config")
+)
+
+type TLSYAMLLoader struct{}
+
+func NewTLSLoader() *TLSYAMLLoader {
+	return &TLSYAMLLoader{}
+}
+
+type TLSWrapper struct {
+	TLS config.TLSConfig `yaml:"tls"`
+}
+
+func (l *TLSYAMLLoader) Load(path string) (config.TLSConfig, error) {
+	var cfg TLSWrapper
+
+	err := cleanenv.ReadConfig(path, &cfg)
+	if err != nil {
+		return config.TLSConfig{}, fmt.Errorf("%w: %w", ErrCannotLoadTLS, err)
+	}
+
+	return cfg.TLS, nil
+}
@@ -0,0 +1,34 @@
+package yaml_config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/humanbelnik/load-balancer/internal/tracing/config"
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+var (
+	ErrCannotLoadTracing = errors.New("cannot load tracing config")
+)
+
+type TracingYAMLLoader struct{}
+
+func NewTracingLoader() *TracingYAMLLoader {
+	return &TracingYAMLLoader{}
+}
+
+type TracingWrapper struct {
+	Tracing config.TracingConfig `yaml:"tracing"`
+}
+
+func (l *TracingYAMLLoader) Load(path string) (config.TracingConfig, error) {
+	var cfg TracingWrapper
+
+	err := cleanenv.ReadConfig(path, &cfg)
+	if err != nil {
+		return config.TracingConfig{}, fmt.Errorf("%w: %w", ErrCannotLoadTracing, err)
+	}
+
+	return cfg.Tracing, nil
+}
@@ -0,0 +1,34 @@
+package yaml_config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+var (
+	ErrCannotLoadUDP = errors.New("cannot load UDP config")
+)
+
+type UDPYAMLLoader struct{}
+
+func NewUDPLoader() *UDPYAMLLoader {
+	return &UDPYAMLLoader{}
+}
+
+type UDPWrapper struct {
+	UDP []config.UDPConfig `yaml:"udp"`
+}
+
+func (l *UDPYAMLLoader) Load(path string) ([]config.UDPConfig, error) {
+	var cfg UDPWrapper
+
+	err := cleanenv.ReadConfig(path, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCannotLoadUDP, err)
+	}
+
+	return cfg.UDP, nil
+}
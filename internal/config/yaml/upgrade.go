@@ -0,0 +1,34 @@
+package yaml_config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/config"
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+var (
+	ErrCannotLoadUpgrade = errors.New("cannot load upgrade config")
+)
+
+type UpgradeYAMLLoader struct{}
+
+func NewUpgradeLoader() *UpgradeYAMLLoader {
+	return &UpgradeYAMLLoader{}
+}
+
+type UpgradeWrapper struct {
+	Upgrade config.UpgradeConfig `yaml:"upgrade"`
+}
+
+func (l *UpgradeYAMLLoader) Load(path string) (config.UpgradeConfig, error) {
+	var cfg UpgradeWrapper
+
+	err := cleanenv.ReadConfig(path, &cfg)
+	if err != nil {
+		return config.UpgradeConfig{}, fmt.Errorf("%w: %w", ErrCannotLoadUpgrade, err)
+	}
+
+	return cfg.Upgrade, nil
+}
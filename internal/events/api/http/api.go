@@ -0,0 +1,133 @@
+package api_events
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/humanbelnik/load-balancer/internal/events"
+)
+
+const defaultHeartbeat = 15 * time.Second
+
+type Bus interface {
+	Subscribe(lastID uint64, types ...events.Type) (<-chan events.Event, []events.Event, func())
+}
+
+type API struct {
+	Bus       Bus
+	heartbeat time.Duration
+	logger    *slog.Logger
+}
+
+type Option func(*API)
+
+func WithLogger(logger *slog.Logger) Option {
+	return func(a *API) {
+		a.logger = logger
+	}
+}
+
+/*
+Comment lines sent to idle streams, so proxies don't drop them.
+*/
+func WithHeartbeat(d time.Duration) Option {
+	return func(a *API) {
+		a.heartbeat = d
+	}
+}
+
+func New(bus Bus, opts ...Option) *API {
+	api := &API{
+		Bus:       bus,
+		heartbeat: defaultHeartbeat,
+		logger:    slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(api)
+	}
+	return api
+}
+
+/*
+Streams events as Server-Sent Events.
+Optional 'types' query parameter filters them, e.g. ?types=server_state,breaker_tripped.
+Missed events are replayed after Last-Event-ID, while kept in history.
+*/
+func (a *API) Stream(w http.ResponseWriter, r *http.Request) {
+	var types []events.Type
+	if raw := r.URL.Query().Get("types"); raw != "" {
+		for _, t := range strings.Split(raw, ",") {
+			types = append(types, events.Type(strings.TrimSpace(t)))
+		}
+	}
+
+	var lastID uint64
+	if raw := r.Header.Get("Last-Event-ID"); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastID = id
+	}
+
+	rc := http.NewResponseController(w)
+	// Stream lives longer than any server write timeout.
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	ch, replay, cancel := a.Bus.Subscribe(lastID, types...)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		a.logger.Error("events stream is not flushable", slog.Any("err", err))
+		return
+	}
+
+	for _, e := range replay {
+		if err := a.write(w, e); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(a.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-ch:
+			if err := a.write(w, e); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (a *API) write(w http.ResponseWriter, e events.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		a.logger.Error("encode event", slog.Any("err", err))
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
@@ -0,0 +1,135 @@
+package api_events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/events"
+)
+
+/*
+Reports subscriptions and their cancellation.
+*/
+type trackingBus struct {
+	*events.Bus
+	subscribed chan struct{}
+	cancelled  chan struct{}
+}
+
+func (b *trackingBus) Subscribe(lastID uint64, types ...events.Type) (<-chan events.Event, []events.Event, func()) {
+	ch, replay, cancel := b.Bus.Subscribe(lastID, types...)
+	b.subscribed <- struct{}{}
+	return ch, replay, func() {
+		cancel()
+		close(b.cancelled)
+	}
+}
+
+func newStream(t *testing.T, opts ...Option) (*events.Bus, *trackingBus, string) {
+	t.Helper()
+	bus := events.NewBus()
+	tb := &trackingBus{Bus: bus, subscribed: make(chan struct{}, 1), cancelled: make(chan struct{})}
+	srv := httptest.NewServer(http.HandlerFunc(New(tb, opts...).Stream))
+	t.Cleanup(srv.Close)
+	return bus, tb, srv.URL
+}
+
+func connect(t *testing.T, ctx context.Context, url string, header http.Header) (*http.Response, *bufio.Reader) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	require.NoError(t, err)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp, bufio.NewReader(resp.Body)
+}
+
+/*
+Lines of the next message, up to the blank line.
+*/
+func readMessage(t *testing.T, r *bufio.Reader) []string {
+	t.Helper()
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\n" {
+			return lines
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+}
+
+func TestStream(t *testing.T) {
+	bus, tb, url := newStream(t)
+	resp, r := connect(t, context.Background(), url+"?types=server_state", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	require.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+	<-tb.subscribed
+
+	bus.ServerAdded("http://a")
+	bus.ServerStateChanged("http://a", "alive", "ejected")
+
+	// Filtered out event still takes its ID.
+	msg := readMessage(t, r)
+	require.Len(t, msg, 3)
+	require.Equal(t, "id: 2", msg[0])
+	require.Equal(t, "event: server_state", msg[1])
+	require.True(t, strings.HasPrefix(msg[2], "data: "))
+
+	var e events.Event
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(msg[2], "data: ")), &e))
+	require.Equal(t, events.ServerState, e.Type)
+	require.Equal(t, "ejected", e.Data["to"])
+}
+
+func TestStream_Replay(t *testing.T) {
+	bus, tb, url := newStream(t)
+	bus.ServerAdded("http://a")
+	bus.ServerAdded("http://b")
+
+	_, r := connect(t, context.Background(), url, http.Header{"Last-Event-ID": {"1"}})
+	<-tb.subscribed
+	require.Equal(t, "id: 2", readMessage(t, r)[0])
+
+	bus.ServerAdded("http://c")
+	require.Equal(t, "id: 3", readMessage(t, r)[0])
+}
+
+func TestStream_BadLastEventID(t *testing.T) {
+	_, _, url := newStream(t)
+	resp, _ := connect(t, context.Background(), url, http.Header{"Last-Event-ID": {"abc"}})
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestStream_Heartbeat(t *testing.T) {
+	_, _, url := newStream(t, WithHeartbeat(10*time.Millisecond))
+	_, r := connect(t, context.Background(), url, nil)
+	require.Equal(t, []string{": ping"}, readMessage(t, r))
+}
+
+func TestStream_ClientDisconnect(t *testing.T) {
+	_, tb, url := newStream(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	connect(t, ctx, url, nil)
+	<-tb.subscribed
+
+	cancel()
+	select {
+	case <-tb.cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler is still running after client has gone")
+	}
+}
@@ -0,0 +1,242 @@
+package events
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	ServerAdded    Type = "server_added"
+	ServerRemoved  Type = "server_removed"
+	ServerState    Type = "server_state"
+	BreakerTripped Type = "breaker_tripped"
+	ConfigReload   Type = "config_reload"
+	PoolUpdated    Type = "pool_updated"
+	RateLimitRule  Type = "ratelimit_rule"
+	SplitChanged   Type = "split_changed"
+	SplitRemoved   Type = "split_removed"
+)
+
+const (
+	// Events kept for subscribers reconnecting with Last-Event-ID.
+	defaultHistory = 256
+	// Events queued per subscriber, the rest is dropped.
+	defaultBuffer = 64
+)
+
+type Event struct {
+	ID   uint64         `json:"id"`
+	Type Type           `json:"type"`
+	Time time.Time      `json:"time"`
+	Data map[string]any `json:"data,omitempty"`
+}
+
+type subscriber struct {
+	ch    chan Event
+	types map[Type]struct{}
+}
+
+func (s *subscriber) wants(t Type) bool {
+	if len(s.types) == 0 {
+		return true
+	}
+	_, ok := s.types[t]
+	return ok
+}
+
+/*
+Bus fans out pool, health and configuration events to subscribers.
+Publishing never blocks: slow subscribers lose events.
+Implements listeners of server, pool, watcher and rate limiter API.
+*/
+type Bus struct {
+	mu      sync.Mutex
+	seq     uint64
+	subs    map[*subscriber]struct{}
+	history []Event
+	size    int
+	buffer  int
+}
+
+type Option func(*Bus)
+
+func WithHistory(size int) Option {
+	return func(b *Bus) {
+		b.size = size
+	}
+}
+
+func WithBuffer(size int) Option {
+	return func(b *Bus) {
+		b.buffer = size
+	}
+}
+
+func NewBus(opts ...Option) *Bus {
+	b := &Bus{
+		subs:   make(map[*subscriber]struct{}),
+		size:   defaultHistory,
+		buffer: defaultBuffer,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+func (b *Bus) Publish(t Type, data map[string]any) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	e := Event{ID: b.seq, Type: t, Time: time.Now(), Data: data}
+
+	if b.size > 0 {
+		if len(b.history) == b.size {
+			b.history = b.history[1:]
+		}
+		b.history = append(b.history, e)
+	}
+
+	for s := range b.subs {
+		if !s.wants(t) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+		}
+	}
+}
+
+/*
+Subscribes to events of given types (every type, if none given).
+Events published after lastID and still kept in history are replayed first.
+Returned func must be called to unsubscribe.
+*/
+func (b *Bus) Subscribe(lastID uint64, types ...Type) (<-chan Event, []Event, func()) {
+	s := &subscriber{
+		ch:    make(chan Event, b.buffer),
+		types: make(map[Type]struct{}, len(types)),
+	}
+	for _, t := range types {
+		s.types[t] = struct{}{}
+	}
+
+	b.mu.Lock()
+	var replay []Event
+	if lastID > 0 {
+		for _, e := range b.history {
+			if e.ID > lastID && s.wants(e.Type) {
+				replay = append(replay, e)
+			}
+		}
+	}
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return s.ch, replay, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, s)
+			b.mu.Unlock()
+		})
+	}
+}
+
+/*
+Up to n latest events of given types (every type, if none given), oldest first.
+*/
+func (b *Bus) Recent(n int, types ...Type) []Event {
+	filter := &subscriber{types: make(map[Type]struct{}, len(types))}
+	for _, t := range types {
+		filter.types[t] = struct{}{}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	result := make([]Event, 0, n)
+	for i := len(b.history) - 1; i >= 0 && len(result) < n; i-- {
+		if filter.wants(b.history[i].Type) {
+			result = append(result, b.history[i])
+		}
+	}
+	slices.Reverse(result)
+	return result
+}
+
+func (b *Bus) ServerAdded(url string) {
+	b.Publish(ServerAdded, map[string]any{"url": url})
+}
+
+func (b *Bus) ServerRemoved(url string, draining bool) {
+	b.Publish(ServerRemoved, map[string]any{"url": url, "draining": draining})
+}
+
+func (b *Bus) ServerStateChanged(url, from, to string) {
+	b.Publish(ServerState, map[string]any{"url": url, "from": from, "to": to})
+}
+
+func (b *Bus) BreakerTripped(url string, err error) {
+	b.Publish(BreakerTripped, map[string]any{"url": url, "error": errString(err)})
+}
+
+/*
+Observer of pool of an upstream group, one per group.
+*/
+func (b *Bus) PoolObserver(upstream string) *PoolObserver {
+	return &PoolObserver{bus: b, upstream: upstream}
+}
+
+type PoolObserver struct {
+	bus      *Bus
+	upstream string
+}
+
+func (o *PoolObserver) PoolUpdated(size int, err error) {
+	data := map[string]any{"upstream": o.upstream, "result": "success", "size": size}
+	if err != nil {
+		data["result"] = "failure"
+		data["error"] = err.Error()
+	}
+	o.bus.Publish(PoolUpdated, data)
+}
+
+/*
+Published once per reload, with its final result.
+*/
+func (b *Bus) ConfigReloaded(err error) {
+	if err != nil {
+		b.Publish(ConfigReload, map[string]any{"result": "failure", "error": err.Error()})
+		return
+	}
+	b.Publish(ConfigReload, map[string]any{"result": "success"})
+}
+
+func (b *Bus) RuleChanged(action string, rule map[string]any) {
+	data := map[string]any{"action": action}
+	for k, v := range rule {
+		data[k] = v
+	}
+	b.Publish(RateLimitRule, data)
+}
+
+func (b *Bus) SplitChanged(route string, weights map[string]int) {
+	b.Publish(SplitChanged, map[string]any{"route": route, "weights": weights})
+}
+
+func (b *Bus) SplitRemoved(route string) {
+	b.Publish(SplitRemoved, map[string]any{"route": route})
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
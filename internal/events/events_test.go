@@ -0,0 +1,82 @@
+package events
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBus_Filter(t *testing.T) {
+	b := NewBus()
+	ch, _, cancel := b.Subscribe(0, ServerState)
+	defer cancel()
+
+	b.ServerAdded("http://a")
+	b.ServerStateChanged("http://a", "alive", "ejected")
+
+	e := <-ch
+	require.Equal(t, ServerState, e.Type)
+	require.Equal(t, "ejected", e.Data["to"])
+	require.Empty(t, ch)
+}
+
+func TestBus_Replay(t *testing.T) {
+	b := NewBus(WithHistory(2))
+	b.ServerAdded("http://a")
+	b.ServerAdded("http://b")
+	b.BreakerTripped("http://b", errors.New("502"))
+
+	_, replay, cancel := b.Subscribe(1)
+	defer cancel()
+
+	require.Len(t, replay, 2)
+	require.Equal(t, uint64(2), replay[0].ID)
+	require.Equal(t, BreakerTripped, replay[1].Type)
+	require.Equal(t, "502", replay[1].Data["error"])
+}
+
+func TestBus_SlowSubscriber(t *testing.T) {
+	b := NewBus(WithBuffer(1))
+	ch, _, cancel := b.Subscribe(0)
+
+	b.ServerAdded("http://a")
+	b.ServerAdded("http://b")
+	require.Len(t, ch, 1)
+
+	cancel()
+	b.ServerAdded("http://c")
+	require.Len(t, ch, 1)
+}
+
+func TestBus_Recent(t *testing.T) {
+	b := NewBus()
+	b.ServerAdded("http://a")
+	b.BreakerTripped("http://a", errors.New("502"))
+	b.ServerAdded("http://b")
+	b.BreakerTripped("http://b", errors.New("503"))
+
+	recent := b.Recent(10, BreakerTripped)
+	require.Len(t, recent, 2)
+	require.Equal(t, "http://a", recent[0].Data["url"])
+
+	recent = b.Recent(1)
+	require.Len(t, recent, 1)
+	require.Equal(t, uint64(4), recent[0].ID)
+}
+
+func TestBus_PoolObserver(t *testing.T) {
+	b := NewBus()
+	b.PoolObserver("api").PoolUpdated(2, nil)
+	b.PoolObserver("web").PoolUpdated(1, errors.New("bad url"))
+	b.ConfigReloaded(nil)
+
+	pools := b.Recent(10, PoolUpdated)
+	require.Len(t, pools, 2)
+	require.Equal(t, map[string]any{"upstream": "api", "result": "success", "size": 2}, pools[0].Data)
+	require.Equal(t, "failure", pools[1].Data["result"])
+
+	reloads := b.Recent(10, ConfigReload)
+	require.Len(t, reloads, 1)
+	require.Equal(t, "success", reloads[0].Data["result"])
+}
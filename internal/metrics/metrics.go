@@ -0,0 +1,219 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/humanbelnik/load-balancer/internal/balancer/server/server"
+)
+
+const namespace = "lb"
+
+/*
+Pool gives every server for per-backend gauges, dead ones included.
+*/
+type Pool interface {
+	All() []server.Server
+}
+
+/*
+Implemented by servers counting their alive -> dead transitions.
+*/
+type Ejector interface {
+	Ejections() uint64
+}
+
+/*
+Implemented by servers proxying upgraded connections.
+*/
+type Upgrader interface {
+	Upgraded() int64
+}
+
+/*
+Metrics collects balancer metrics in Prometheus format.
+Implements observers of balancer, proxy and rate limiter.
+*/
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests  *prometheus.CounterVec
+	upstream  *prometheus.HistogramVec
+	retries   *prometheus.CounterVec
+	pools     *poolCollector
+	reloads   *prometheus.CounterVec
+	ratelimit *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "Requests handled by balancer.",
+		}, []string{"backend", "code", "method"}),
+		upstream: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "upstream_duration_seconds",
+			Help:      "Duration of a single upstream attempt.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"backend", "code"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retries_total",
+			Help:      "Attempts retried on the next backend.",
+		}, []string{"backend"}),
+		pools: &poolCollector{pools: make(map[string]Pool)},
+		reloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "config_reloads_total",
+			Help:      "Server pool configuration reloads.",
+		}, []string{"result"}),
+		ratelimit: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ratelimit_decisions_total",
+			Help:      "Rate limiter decisions per rule.",
+		}, []string{"rule", "decision"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests, m.upstream, m.retries, m.reloads, m.ratelimit, m.pools,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+/*
+Exposes pool size, per-server alive state and ejection count of upstream group, read at scrape time.
+*/
+func (m *Metrics) RegisterPool(upstream string, p Pool) {
+	m.pools.add(upstream, p)
+}
+
+/*
+Exposes requests let through by rate limiter shadow mode.
+*/
+func (m *Metrics) RegisterShadowRejected(count func() uint64) {
+	m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ratelimit_shadow_rejected_total",
+		Help:      "Requests that would have been rejected by shadowed limits.",
+	}, func() float64 { return float64(count()) }))
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+func (m *Metrics) RequestServed(backend, method string, code int) {
+	m.requests.WithLabelValues(backend, codeClass(code), method).Inc()
+}
+
+func (m *Metrics) Retry(backend string) {
+	m.retries.WithLabelValues(backend).Inc()
+}
+
+func (m *Metrics) ObserveUpstream(backend string, code int, d time.Duration) {
+	m.upstream.WithLabelValues(backend, codeClass(code)).Observe(d.Seconds())
+}
+
+/*
+Counted once per reload, with its final result.
+*/
+func (m *Metrics) ConfigReloaded(err error) {
+	if err != nil {
+		m.reloads.WithLabelValues("failure").Inc()
+		return
+	}
+	m.reloads.WithLabelValues("success").Inc()
+}
+
+func (m *Metrics) RateLimitDecision(rule string, allowed bool) {
+	decision := "deny"
+	if allowed {
+		decision = "allow"
+	}
+	m.ratelimit.WithLabelValues(rule, decision).Inc()
+}
+
+/*
+2xx, 4xx, etc. Zero code means no response was written.
+*/
+func codeClass(code int) string {
+	if code < 100 || code > 599 {
+		return "none"
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
+
+type poolCollector struct {
+	mu    sync.RWMutex
+	pools map[string]Pool
+}
+
+func (c *poolCollector) add(upstream string, p Pool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pools[upstream] = p
+}
+
+var (
+	poolSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "pool", "size"),
+		"Servers in pool, dead and draining ones included.",
+		[]string{"upstream"}, nil,
+	)
+	aliveDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "backend", "alive"),
+		"Whether backend is alive (1) or ejected (0).",
+		[]string{"upstream", "backend"}, nil,
+	)
+	ejectionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "backend", "ejections_total"),
+		"Alive to dead transitions of backend.",
+		[]string{"upstream", "backend"}, nil,
+	)
+	upgradedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "backend", "upgraded_connections"),
+		"Open upgraded (WebSocket) connections to backend.",
+		[]string{"upstream", "backend"}, nil,
+	)
+)
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolSizeDesc
+	ch <- aliveDesc
+	ch <- ejectionsDesc
+	ch <- upgradedDesc
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for upstream, pool := range c.pools {
+		servers := pool.All()
+		ch <- prometheus.MustNewConstMetric(poolSizeDesc, prometheus.GaugeValue, float64(len(servers)), upstream)
+		for _, s := range servers {
+			alive := 0.0
+			if s.IsAlive() {
+				alive = 1
+			}
+			ch <- prometheus.MustNewConstMetric(aliveDesc, prometheus.GaugeValue, alive, upstream, s.URL())
+			if e, ok := s.(Ejector); ok {
+				ch <- prometheus.MustNewConstMetric(ejectionsDesc, prometheus.CounterValue, float64(e.Ejections()), upstream, s.URL())
+			}
+			if u, ok := s.(Upgrader); ok {
+				ch <- prometheus.MustNewConstMetric(upgradedDesc, prometheus.GaugeValue, float64(u.Upgraded()), upstream, s.URL())
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+/*
+Metric of family with given labels, nil if not collected.
+*/
+func find(t *testing.T, m *Metrics, name string, labels map[string]string) *dto.Metric {
+	t.Helper()
+	families, err := m.registry.Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+	next:
+		for _, metric := range f.GetMetric() {
+			for _, l := range metric.GetLabel() {
+				if want, ok := labels[l.GetName()]; ok && want != l.GetValue() {
+					continue next
+				}
+			}
+			return metric
+		}
+	}
+	return nil
+}
+
+func TestMetrics_Requests(t *testing.T) {
+	m := New()
+	m.RequestServed("http://a", "GET", 200)
+	m.RequestServed("http://a", "GET", 204)
+	m.RequestServed("http://a", "GET", 502)
+	m.RequestServed("", "POST", 0)
+	m.Retry("http://a")
+
+	ok := find(t, m, "lb_requests_total", map[string]string{"backend": "http://a", "code": "2xx", "method": "GET"})
+	require.NotNil(t, ok)
+	require.Equal(t, 2.0, ok.GetCounter().GetValue())
+
+	failed := find(t, m, "lb_requests_total", map[string]string{"backend": "http://a", "code": "5xx"})
+	require.NotNil(t, failed)
+	require.Equal(t, 1.0, failed.GetCounter().GetValue())
+
+	none := find(t, m, "lb_requests_total", map[string]string{"code": "none", "method": "POST"})
+	require.NotNil(t, none)
+	require.Equal(t, 1.0, none.GetCounter().GetValue())
+
+	retries := find(t, m, "lb_retries_total", map[string]string{"backend": "http://a"})
+	require.NotNil(t, retries)
+	require.Equal(t, 1.0, retries.GetCounter().GetValue())
+}
+
+func TestMetrics_UpstreamHistogram(t *testing.T) {
+	m := New()
+	m.ObserveUpstream("http://a", 200, 20*time.Millisecond)
+	m.ObserveUpstream("http://a", 200, 2*time.Second)
+
+	metric := find(t, m, "lb_upstream_duration_seconds", map[string]string{"backend": "http://a", "code": "2xx"})
+	require.NotNil(t, metric)
+	h := metric.GetHistogram()
+	require.Equal(t, uint64(2), h.GetSampleCount())
+	require.InDelta(t, 2.02, h.GetSampleSum(), 1e-9)
+	for _, b := range h.GetBucket() {
+		switch b.GetUpperBound() {
+		case 0.025:
+			require.Equal(t, uint64(1), b.GetCumulativeCount())
+		case 2.5:
+			require.Equal(t, uint64(2), b.GetCumulativeCount())
+		}
+	}
+}
+
+func TestMetrics_Reloads(t *testing.T) {
+	m := New()
+	require.Nil(t, find(t, m, "lb_config_reloads_total", map[string]string{"result": "success"}))
+
+	m.ConfigReloaded(nil)
+	m.ConfigReloaded(errors.New("bad url"))
+	m.ConfigReloaded(errors.New("bad yaml"))
+
+	success := find(t, m, "lb_config_reloads_total", map[string]string{"result": "success"})
+	require.NotNil(t, success)
+	require.Equal(t, 1.0, success.GetCounter().GetValue())
+	failure := find(t, m, "lb_config_reloads_total", map[string]string{"result": "failure"})
+	require.NotNil(t, failure)
+	require.Equal(t, 2.0, failure.GetCounter().GetValue())
+}
+
+func TestMetrics_RateLimit(t *testing.T) {
+	m := New()
+	m.RateLimitDecision("global", true)
+	m.RateLimitDecision("global", false)
+	m.RateLimitDecision("global", false)
+
+	deny := find(t, m, "lb_ratelimit_decisions_total", map[string]string{"rule": "global", "decision": "deny"})
+	require.NotNil(t, deny)
+	require.Equal(t, 2.0, deny.GetCounter().GetValue())
+}
@@ -0,0 +1,156 @@
+package proxyproto
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+var (
+	ErrBadTrusted = errors.New("bad trusted source")
+)
+
+/*
+Trusted sources from config, CIDRs or single addresses.
+*/
+func ParseTrusted(sources []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(sources))
+	for _, s := range sources {
+		if p, err := netip.ParsePrefix(s); err == nil {
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrBadTrusted, s)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+/*
+Listener expects header on connections from trusted sources, others are passed as is.
+Trusted connection without valid header fails on first read.
+*/
+type Listener struct {
+	net.Listener
+	trusted []netip.Prefix
+	timeout time.Duration
+}
+
+/*
+Header must arrive within timeout, 0 means no limit.
+*/
+func NewListener(l net.Listener, trusted []netip.Prefix, timeout time.Duration) *Listener {
+	return &Listener{Listener: l, trusted: trusted, timeout: timeout}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusts(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn, timeout: l.timeout}, nil
+}
+
+func (l *Listener) trusts(addr net.Addr) bool {
+	ap, ok := addrPort(addr)
+	if !ok {
+		return false
+	}
+	for _, p := range l.trusted {
+		if p.Contains(ap.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Conn reports addresses from header. Header is read lazily, not to block Accept,
+by the first call of Read, RemoteAddr, LocalAddr or deadline setters.
+*/
+type Conn struct {
+	net.Conn
+	timeout time.Duration
+
+	once   sync.Once
+	r      *bufio.Reader
+	header *Header
+	err    error
+}
+
+func (c *Conn) init() {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		}
+		c.r = bufio.NewReader(c.Conn)
+		c.header, c.err = Read(c.r)
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Time{})
+		}
+	})
+}
+
+/*
+Header sent by the source, error if it was missing or malformed.
+*/
+func (c *Conn) Header() (*Header, error) {
+	c.init()
+	return c.header, c.err
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	c.init()
+	if c.err == nil && !c.header.Local && c.header.Source != nil {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	c.init()
+	if c.err == nil && !c.header.Local && c.header.Destination != nil {
+		return c.header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// Header deadline must not override caller's one.
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.init()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.init()
+	return c.Conn.SetReadDeadline(t)
+}
+
+/*
+Half-close for TCP proxy.
+*/
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
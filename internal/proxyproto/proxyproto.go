@@ -0,0 +1,303 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Protocol versions.
+const (
+	V1 = 1
+	V2 = 2
+)
+
+var (
+	ErrNoHeader   = errors.New("no PROXY protocol header")
+	ErrBadHeader  = errors.New("malformed PROXY protocol header")
+	ErrBadVersion = errors.New("unknown PROXY protocol version")
+)
+
+var signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	// Longest v1 line, CRLF included.
+	maxV1Length = 107
+
+	commandLocal = 0x0
+	commandProxy = 0x1
+
+	familyInet  = 0x1
+	familyInet6 = 0x2
+
+	protoStream = 0x1
+	protoDgram  = 0x2
+)
+
+/*
+Version from config, 'v1' or 'v2'. Empty string gives 0 - protocol is off.
+*/
+func ParseVersion(s string) (int, error) {
+	switch s {
+	case "":
+		return 0, nil
+	case "v1":
+		return V1, nil
+	case "v2":
+		return V2, nil
+	default:
+		return 0, fmt.Errorf("%w: %q", ErrBadVersion, s)
+	}
+}
+
+/*
+Header describes original connection. Local one (v1 UNKNOWN, v2 LOCAL) is opened
+by the proxy itself, eg. for health check, and carries no addresses.
+*/
+type Header struct {
+	Version     int
+	Local       bool
+	Source      net.Addr
+	Destination net.Addr
+}
+
+/*
+Reads v1 or v2 header, nothing past it is consumed.
+*/
+func Read(r *bufio.Reader) (*Header, error) {
+	prefix, err := r.Peek(len(signatureV2))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoHeader, err)
+	}
+	switch {
+	case bytes.Equal(prefix, signatureV2):
+		return readV2(r)
+	case bytes.HasPrefix(prefix, []byte("PROXY ")):
+		return readV1(r)
+	default:
+		return nil, ErrNoHeader
+	}
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadHeader, err)
+	}
+	if len(line) > maxV1Length || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: bad v1 line", ErrBadHeader)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	h := &Header{Version: V1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		h.Local = true
+		return h, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("%w: bad v1 line %q", ErrBadHeader, line)
+	}
+
+	src, err := parseV1Addr(fields[2], fields[4], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Addr(fields[3], fields[5], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	h.Source, h.Destination = net.TCPAddrFromAddrPort(src), net.TCPAddrFromAddrPort(dst)
+	return h, nil
+}
+
+func parseV1Addr(ip, port string, v4 bool) (netip.AddrPort, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Is4() != v4 {
+		return netip.AddrPort{}, fmt.Errorf("%w: bad address %q", ErrBadHeader, ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("%w: bad port %q", ErrBadHeader, port)
+	}
+	return netip.AddrPortFrom(addr, uint16(p)), nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadHeader, err)
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, fmt.Errorf("%w: v2 version %d", ErrBadHeader, fixed[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadHeader, err)
+	}
+
+	h := &Header{Version: V2}
+	switch fixed[12] & 0xf {
+	case commandLocal:
+		h.Local = true
+		return h, nil
+	case commandProxy:
+	default:
+		return nil, fmt.Errorf("%w: v2 command %d", ErrBadHeader, fixed[12]&0xf)
+	}
+
+	family, proto := fixed[13]>>4, fixed[13]&0xf
+	var size int
+	switch family {
+	case familyInet:
+		size = 4
+	case familyInet6:
+		size = 16
+	default:
+		// Unix sockets and unspecified family: addresses are of no use, TLVs are skipped.
+		h.Local = true
+		return h, nil
+	}
+	if len(body) < 2*size+4 {
+		return nil, fmt.Errorf("%w: v2 addresses too short", ErrBadHeader)
+	}
+	srcIP, _ := netip.AddrFromSlice(body[:size])
+	dstIP, _ := netip.AddrFromSlice(body[size : 2*size])
+	src := netip.AddrPortFrom(srcIP, binary.BigEndian.Uint16(body[2*size:]))
+	dst := netip.AddrPortFrom(dstIP, binary.BigEndian.Uint16(body[2*size+2:]))
+
+	switch proto {
+	case protoStream:
+		h.Source, h.Destination = net.TCPAddrFromAddrPort(src), net.TCPAddrFromAddrPort(dst)
+	case protoDgram:
+		h.Source, h.Destination = net.UDPAddrFromAddrPort(src), net.UDPAddrFromAddrPort(dst)
+	default:
+		h.Local = true
+	}
+	return h, nil
+}
+
+/*
+Header bytes to be written before any data.
+Source other than TCP or UDP address makes it local, unknown destination is sent as unspecified one.
+*/
+func (h *Header) Format() ([]byte, error) {
+	src, srcOK := addrPort(h.Source)
+	dst, dstOK := addrPort(h.Destination)
+	if srcOK && !dstOK {
+		dst = netip.AddrPortFrom(netip.IPv4Unspecified(), 0)
+		if !src.Addr().Is4() {
+			dst = netip.AddrPortFrom(netip.IPv6Unspecified(), 0)
+		}
+	}
+	local := h.Local || !srcOK
+	if !local && src.Addr().Is4() != dst.Addr().Is4() {
+		// Same family is required, IPv4 one is mapped.
+		src = netip.AddrPortFrom(netip.AddrFrom16(src.Addr().As16()), src.Port())
+		dst = netip.AddrPortFrom(netip.AddrFrom16(dst.Addr().As16()), dst.Port())
+	}
+
+	switch h.Version {
+	case V1:
+		if local {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		family := "TCP6"
+		if src.Addr().Is4() {
+			family = "TCP4"
+		}
+		return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", family, src.Addr(), dst.Addr(), src.Port(), dst.Port()), nil
+	case V2:
+		buf := append([]byte{}, signatureV2...)
+		if local {
+			return append(buf, 0x20|commandLocal, 0, 0, 0), nil
+		}
+		family := byte(familyInet6)
+		if src.Addr().Is4() {
+			family = familyInet
+		}
+		proto := byte(protoStream)
+		if _, ok := h.Source.(*net.UDPAddr); ok {
+			proto = protoDgram
+		}
+		srcIP, dstIP := src.Addr().AsSlice(), dst.Addr().AsSlice()
+		buf = append(buf, 0x20|commandProxy, family<<4|proto)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(2*len(srcIP)+4))
+		buf = append(buf, srcIP...)
+		buf = append(buf, dstIP...)
+		buf = binary.BigEndian.AppendUint16(buf, src.Port())
+		return binary.BigEndian.AppendUint16(buf, dst.Port()), nil
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrBadVersion, h.Version)
+	}
+}
+
+func addrPort(a net.Addr) (netip.AddrPort, bool) {
+	var ap netip.AddrPort
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		ap = a.AddrPort()
+	case *net.UDPAddr:
+		ap = a.AddrPort()
+	default:
+		return netip.AddrPort{}, false
+	}
+	// 4in6 addresses of dual stack sockets go as IPv4.
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()), ap.IsValid()
+}
+
+type addrsKey struct{}
+
+type addrs struct {
+	src, dst net.Addr
+}
+
+/*
+Addresses of client connection, sent to server by Dialer.
+*/
+func NewContext(ctx context.Context, src, dst net.Addr) context.Context {
+	return context.WithValue(ctx, addrsKey{}, addrs{src: src, dst: dst})
+}
+
+func FromContext(ctx context.Context) (src, dst net.Addr) {
+	a, _ := ctx.Value(addrsKey{}).(addrs)
+	return a.src, a.dst
+}
+
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+/*
+Dialer writes header right after connection is established.
+Without addresses in context (eg. health check) header is a local one.
+*/
+func Dialer(version int, dial DialFunc) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		src, dst := FromContext(ctx)
+		h := &Header{Version: version, Source: src, Destination: dst, Local: src == nil}
+		if err := Write(conn, h); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+func Write(w io.Writer, h *Header) error {
+	b, err := h.Format()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
@@ -0,0 +1,184 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func tcpAddr(s string) *net.TCPAddr {
+	return net.TCPAddrFromAddrPort(netip.MustParseAddrPort(s))
+}
+
+func TestHeader_RoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		header   Header
+		src, dst string
+	}{
+		{name: "v1 ipv4", header: Header{Version: V1, Source: tcpAddr("10.0.0.1:5000"), Destination: tcpAddr("10.0.0.2:80")}, src: "10.0.0.1:5000", dst: "10.0.0.2:80"},
+		{name: "v1 ipv6", header: Header{Version: V1, Source: tcpAddr("[2001:db8::1]:5000"), Destination: tcpAddr("[2001:db8::2]:443")}, src: "[2001:db8::1]:5000", dst: "[2001:db8::2]:443"},
+		{name: "v2 ipv4", header: Header{Version: V2, Source: tcpAddr("10.0.0.1:5000"), Destination: tcpAddr("10.0.0.2:80")}, src: "10.0.0.1:5000", dst: "10.0.0.2:80"},
+		{name: "v2 mixed", header: Header{Version: V2, Source: tcpAddr("10.0.0.1:5000"), Destination: tcpAddr("[2001:db8::2]:443")}, src: "10.0.0.1:5000", dst: "[2001:db8::2]:443"},
+		{name: "v1 local", header: Header{Version: V1, Local: true}},
+		{name: "v2 local", header: Header{Version: V2}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := tc.header.Format()
+			require.NoError(t, err)
+
+			r := bufio.NewReader(io.MultiReader(bytes.NewReader(b), strings.NewReader("payload")))
+			h, err := Read(r)
+			require.NoError(t, err)
+			require.Equal(t, tc.header.Version, h.Version)
+			if tc.src == "" {
+				require.True(t, h.Local)
+			} else {
+				require.False(t, h.Local)
+				require.Equal(t, tc.src, h.Source.String())
+				require.Equal(t, tc.dst, h.Destination.String())
+			}
+
+			rest, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, "payload", string(rest))
+		})
+	}
+}
+
+func TestRead_Invalid(t *testing.T) {
+	for _, raw := range []string{
+		"GET / HTTP/1.1\r\n\r\n",
+		"PROXY TCP4 10.0.0.1 10.0.0.2 5000\r\n",
+		"PROXY TCP4 2001:db8::1 10.0.0.2 5000 80\r\n",
+		"PROXY TCP4 10.0.0.1 10.0.0.2 5000 80\n",
+		"PROXY TCP4 10.0.0.1 10.0.0.2 5000 80" + strings.Repeat(" ", 100) + "\r\n",
+		"\r\n\r\n\x00\r\nQUIT\n\x31\x11\x00\x00",
+	} {
+		_, err := Read(bufio.NewReader(strings.NewReader(raw)))
+		require.Error(t, err, raw)
+	}
+	_, err := Read(bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n")))
+	require.ErrorIs(t, err, ErrNoHeader)
+}
+
+func TestParseTrusted(t *testing.T) {
+	prefixes, err := ParseTrusted([]string{"10.0.0.0/8", "192.168.1.7", "::1"})
+	require.NoError(t, err)
+	require.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.7/32"),
+		netip.MustParsePrefix("::1/128"),
+	}, prefixes)
+
+	_, err = ParseTrusted([]string{"10.0.0.0/33"})
+	require.ErrorIs(t, err, ErrBadTrusted)
+}
+
+/*
+Listener on loopback, returns accepted connections.
+*/
+func listen(t *testing.T, trusted ...string) (string, <-chan net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	prefixes, err := ParseTrusted(trusted)
+	require.NoError(t, err)
+	pl := NewListener(l, prefixes, time.Second)
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		for {
+			conn, err := pl.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			accepted <- conn
+		}
+	}()
+	return l.Addr().String(), accepted
+}
+
+func TestListener(t *testing.T) {
+	t.Run("trusted", func(t *testing.T) {
+		addr, accepted := listen(t, "127.0.0.0/8")
+		client, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer client.Close()
+		require.NoError(t, Write(client, &Header{Version: V2, Source: tcpAddr("203.0.113.7:4000"), Destination: tcpAddr("198.51.100.1:443")}))
+		_, err = client.Write([]byte("hello"))
+		require.NoError(t, err)
+
+		conn := <-accepted
+		require.Equal(t, "203.0.113.7:4000", conn.RemoteAddr().String())
+		require.Equal(t, "198.51.100.1:443", conn.LocalAddr().String())
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(buf))
+	})
+
+	t.Run("trusted without header", func(t *testing.T) {
+		addr, accepted := listen(t, "127.0.0.1")
+		client, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer client.Close()
+		_, err = client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+
+		conn := <-accepted
+		_, err = conn.Read(make([]byte, 1))
+		require.ErrorIs(t, err, ErrNoHeader)
+		require.Equal(t, client.LocalAddr().String(), conn.RemoteAddr().String())
+	})
+
+	t.Run("untrusted", func(t *testing.T) {
+		addr, accepted := listen(t, "10.0.0.0/8")
+		client, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer client.Close()
+		require.NoError(t, Write(client, &Header{Version: V1, Source: tcpAddr("203.0.113.7:4000"), Destination: tcpAddr("198.51.100.1:443")}))
+
+		// Header is not interpreted, spoofing is not possible.
+		conn := <-accepted
+		require.Equal(t, client.LocalAddr().String(), conn.RemoteAddr().String())
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(line, "PROXY TCP4 203.0.113.7"))
+	})
+}
+
+func TestDialer(t *testing.T) {
+	addr, accepted := listen(t, "127.0.0.1")
+	var d net.Dialer
+	dial := Dialer(V1, d.DialContext)
+
+	ctx := NewContext(context.Background(), tcpAddr("203.0.113.7:4000"), tcpAddr("198.51.100.1:80"))
+	conn, err := dial(ctx, "tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	h, err := (<-accepted).(*Conn).Header()
+	require.NoError(t, err)
+	require.Equal(t, "203.0.113.7:4000", h.Source.String())
+
+	// Health checks have no client.
+	conn, err = dial(context.Background(), "tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	h, err = (<-accepted).(*Conn).Header()
+	require.NoError(t, err)
+	require.True(t, h.Local)
+
+	_, err = ParseVersion("v3")
+	require.ErrorIs(t, err, ErrBadVersion)
+}
@@ -9,9 +9,36 @@ import (
 	"github.com/humanbelnik/load-balancer/internal/ratelimiter/ratelimiter"
 )
 
+/*
+Limiter is either a local limiter or a cluster-aware wrapper around it.
+*/
+type Limiter interface {
+	SetClient(ip string, capacity *int, rate *time.Duration) error
+	RemoveClient(ip string) error
+	Clients() []ratelimiter.ClientConfig
+	SetShadow(ip string, shadow bool) error
+	GlobalShadow() bool
+	SetGlobalShadow(shadow bool)
+}
+
+/*
+Listener is notified about rule changes made through API.
+*/
+type Listener interface {
+	RuleChanged(action string, rule map[string]any)
+}
+
+const (
+	ActionSet          = "set"
+	ActionRemove       = "remove"
+	ActionShadow       = "shadow"
+	ActionGlobalShadow = "global_shadow"
+)
+
 type API struct {
-	Limiter *ratelimiter.Limiter
-	logger  *slog.Logger
+	Limiter  Limiter
+	logger   *slog.Logger
+	listener Listener
 }
 
 type Option func(*API)
@@ -22,7 +49,13 @@ func WithLogger(logger *slog.Logger) Option {
 	}
 }
 
-func New(limiter *ratelimiter.Limiter, opts ...Option) *API {
+func WithListener(l Listener) Option {
+	return func(a *API) {
+		a.listener = l
+	}
+}
+
+func New(limiter Limiter, opts ...Option) *API {
 	api := &API{
 		Limiter: limiter,
 		logger:  slog.Default(),
@@ -37,12 +70,50 @@ type AddRequest struct {
 	IP          string `json:"ip"`
 	Capacity    int    `json:"capacity"`
 	RefillEvery string `json:"refill_every"` // e.g. "2s"
+	Shadow      *bool  `json:"shadow,omitempty"`
+}
+
+type ClientResponse struct {
+	IP          string `json:"ip"`
+	Capacity    int    `json:"capacity"`
+	RefillEvery string `json:"refill_every"`
+	Shadow      bool   `json:"shadow"`
+}
+
+/*
+Empty IP switches global mode.
+*/
+type ShadowRequest struct {
+	IP     string `json:"ip,omitempty"`
+	Shadow *bool  `json:"shadow"`
+}
+
+type ShadowResponse struct {
+	Shadow bool `json:"shadow"`
 }
 
 type DeleteRequest struct {
 	IP string `json:"ip"`
 }
 
+func (a *API) ListClients(w http.ResponseWriter, r *http.Request) {
+	clients := a.Limiter.Clients()
+	resp := make([]ClientResponse, 0, len(clients))
+	for _, c := range clients {
+		resp = append(resp, ClientResponse{
+			IP:          c.IP,
+			Capacity:    c.Capacity,
+			RefillEvery: c.RefillEvery.String(),
+			Shadow:      c.Shadow,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		a.logger.Error("encode clients", slog.Any("err", err))
+	}
+}
+
 func (a *API) AddClient(w http.ResponseWriter, r *http.Request) {
 	var req AddRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -69,7 +140,19 @@ func (a *API) AddClient(w http.ResponseWriter, r *http.Request) {
 		a.logger.Error("SetClient failed", slog.Any("err", err))
 		return
 	}
+	if req.Shadow != nil {
+		if err := a.Limiter.SetShadow(req.IP, *req.Shadow); err != nil {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			a.logger.Error("SetShadow failed", slog.Any("err", err))
+			return
+		}
+	}
 	a.logger.Info("client added", slog.String("ip", req.IP), slog.Int("capacity", req.Capacity), slog.Duration("refill", dur))
+	rule := map[string]any{"ip": req.IP, "capacity": req.Capacity, "refill_every": dur.String()}
+	if req.Shadow != nil {
+		rule["shadow"] = *req.Shadow
+	}
+	a.notify(ActionSet, rule)
 	w.WriteHeader(http.StatusCreated)
 }
 
@@ -93,5 +176,54 @@ func (a *API) DeleteClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	a.logger.Info("client deleted", slog.String("ip", req.IP))
+	a.notify(ActionRemove, map[string]any{"ip": req.IP})
 	w.WriteHeader(http.StatusOK)
 }
+
+func (a *API) GetShadow(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ShadowResponse{Shadow: a.Limiter.GlobalShadow()}); err != nil {
+		a.logger.Error("encode shadow", slog.Any("err", err))
+	}
+}
+
+/*
+Flips client (or every client, if IP is empty) between shadow and enforce modes.
+*/
+func (a *API) SetShadow(w http.ResponseWriter, r *http.Request) {
+	var req ShadowRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.logger.Warn("invalid JSON on SetShadow", slog.Any("err", err))
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.Shadow == nil {
+		a.logger.Warn("missing shadow on SetShadow")
+		http.Error(w, "missing shadow", http.StatusBadRequest)
+		return
+	}
+
+	if req.IP == "" {
+		a.Limiter.SetGlobalShadow(*req.Shadow)
+		a.logger.Info("global shadow mode changed", slog.Bool("shadow", *req.Shadow))
+		a.notify(ActionGlobalShadow, map[string]any{"shadow": *req.Shadow})
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err := a.Limiter.SetShadow(req.IP, *req.Shadow); err != nil {
+		a.logger.Warn("SetShadow failed", slog.String("ip", req.IP), slog.Any("err", err))
+		http.Error(w, "client not found", http.StatusNotFound)
+		return
+	}
+	a.logger.Info("client shadow mode changed", slog.String("ip", req.IP), slog.Bool("shadow", *req.Shadow))
+	a.notify(ActionShadow, map[string]any{"ip": req.IP, "shadow": *req.Shadow})
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *API) notify(action string, rule map[string]any) {
+	if a.listener != nil {
+		a.listener.RuleChanged(action, rule)
+	}
+}
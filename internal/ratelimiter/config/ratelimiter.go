@@ -2,7 +2,46 @@ package config
 
 import "time"
 
+const (
+	ModeLocal  = "local"
+	ModeRedis  = "redis"
+	ModeGossip = "gossip"
+)
+
+type RedisConfig struct {
+	Addr      string `yaml:"addr" env-default:"localhost:6379"`
+	Password  string `yaml:"password" env:"LB_REDIS_PASSWORD"`
+	DB        int    `yaml:"db"`
+	KeyPrefix string `yaml:"key_prefix" env-default:"lb:rl:"`
+	// Per-call deadline. Slow store should not slow down proxied traffic.
+	Timeout time.Duration `yaml:"timeout" env-default:"50ms"`
+	// How long to use local buckets after store failure before trying again.
+	RetryAfter time.Duration `yaml:"retry_after" env-default:"5s"`
+}
+
+type GossipConfig struct {
+	// Unique within cluster, hostname is used if empty.
+	NodeName      string `yaml:"node_name"`
+	BindAddr      string `yaml:"bind_addr" env-default:"0.0.0.0"`
+	BindPort      int    `yaml:"bind_port" env-default:"7946"`
+	AdvertiseAddr string `yaml:"advertise_addr"`
+	// Static peers or seeds in 'host:port' format. Rest of the cluster is discovered through them.
+	Peers []string `yaml:"peers"`
+	// How often consumption deltas are pushed to peers.
+	SyncInterval time.Duration `yaml:"sync_interval" env-default:"200ms"`
+	// Optional 16, 24 or 32 byte key to encrypt gossip traffic.
+	SecretKey string `yaml:"secret_key" env:"LB_GOSSIP_KEY"`
+}
+
 type RateLimiterConfig struct {
 	DefaultCapacity   int           `yaml:"default_capacity"`
 	DefaultRefillRate time.Duration `yaml:"default_refill_rate"`
+
+	// Evaluate limits, but let rejected requests through.
+	Shadow bool `yaml:"shadow"`
+
+	// 'local' keeps buckets in memory, 'redis' and 'gossip' share them between instances.
+	Mode   string       `yaml:"mode" env-default:"local"`
+	Redis  RedisConfig  `yaml:"redis"`
+	Gossip GossipConfig `yaml:"gossip"`
 }
@@ -0,0 +1,453 @@
+package gossip
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+
+	"github.com/humanbelnik/load-balancer/internal/ratelimiter/config"
+	"github.com/humanbelnik/load-balancer/internal/ratelimiter/ratelimiter"
+)
+
+var (
+	ErrCreate     = errors.New("unable to create gossip node")
+	ErrBadMessage = errors.New("malformed gossip message")
+)
+
+/*
+Limiter is a local rate limiter, whose state is kept in sync with peers.
+*/
+type Limiter interface {
+	Allow(ip string) bool
+	Consume(ip string, n int)
+	SetClient(ip string, capacity *int, rate *time.Duration) error
+	RemoveClient(ip string) error
+	Clients() []ratelimiter.ClientConfig
+	Shadow(ip string) bool
+	SetShadow(ip string, shadow bool) error
+	GlobalShadow() bool
+	SetGlobalShadow(shadow bool)
+}
+
+const (
+	msgDeltas byte = iota + 1
+	msgClient
+	msgGlobal
+)
+
+/*
+Tokens consumed on the sender since its previous push.
+*/
+type deltasMsg struct {
+	Node   string         `json:"node"`
+	Deltas map[string]int `json:"deltas"`
+}
+
+/*
+Client configuration change. Last writer (by version) wins.
+*/
+type clientMsg struct {
+	IP          string        `json:"ip"`
+	Capacity    int           `json:"capacity"`
+	RefillEvery time.Duration `json:"refill_every"`
+	Shadow      bool          `json:"shadow"`
+	Version     int64         `json:"version"`
+	Deleted     bool          `json:"deleted"`
+}
+
+/*
+Cluster-wide settings change. Last writer (by version) wins.
+*/
+type globalMsg struct {
+	Shadow  bool  `json:"shadow"`
+	Version int64 `json:"version"`
+}
+
+/*
+Push/pull payload.
+*/
+type state struct {
+	Clients []clientMsg `json:"clients"`
+	Global  globalMsg   `json:"global"`
+}
+
+/*
+Node joins balancer instances into a memberlist cluster.
+Every instance enforces limits locally, but applies consumption of peers,
+so a client gets approximately one global limit.
+*/
+type Node struct {
+	limiter Limiter
+	cfg     config.GossipConfig
+	logger  *slog.Logger
+
+	list  atomic.Pointer[memberlist.Memberlist]
+	queue *memberlist.TransmitLimitedQueue
+
+	mu      sync.Mutex
+	deltas  map[string]int
+	clients map[string]clientMsg
+	global  globalMsg
+
+	quit chan struct{}
+}
+
+type Option func(*Node)
+
+func WithLogger(logger *slog.Logger) Option {
+	return func(n *Node) {
+		n.logger = logger
+	}
+}
+
+func New(cfg config.GossipConfig, limiter Limiter, opts ...Option) (*Node, error) {
+	// Default covers missing key only, ticker panics on explicit zero.
+	if cfg.SyncInterval <= 0 {
+		return nil, fmt.Errorf("%w: sync_interval must be positive, got %s", ErrCreate, cfg.SyncInterval)
+	}
+	n := &Node{
+		limiter: limiter,
+		cfg:     cfg,
+		logger:  slog.Default(),
+		deltas:  make(map[string]int),
+		clients: make(map[string]clientMsg),
+		quit:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+
+	// Known clients start with zero version, so any explicit change wins.
+	for _, c := range limiter.Clients() {
+		n.clients[c.IP] = clientMsg{IP: c.IP, Capacity: c.Capacity, RefillEvery: c.RefillEvery, Shadow: c.Shadow}
+	}
+	n.global = globalMsg{Shadow: limiter.GlobalShadow()}
+
+	mcfg := memberlist.DefaultLANConfig()
+	if cfg.NodeName != "" {
+		mcfg.Name = cfg.NodeName
+	}
+	mcfg.BindAddr = cfg.BindAddr
+	mcfg.BindPort = cfg.BindPort
+	mcfg.AdvertisePort = cfg.BindPort
+	mcfg.AdvertiseAddr = cfg.AdvertiseAddr
+	if cfg.SecretKey != "" {
+		mcfg.SecretKey = []byte(cfg.SecretKey)
+	}
+	mcfg.Delegate = n
+	mcfg.Logger = slog.NewLogLogger(n.logger.Handler(), slog.LevelDebug)
+
+	n.queue = &memberlist.TransmitLimitedQueue{
+		NumNodes:       n.numMembers,
+		RetransmitMult: mcfg.RetransmitMult,
+	}
+
+	list, err := memberlist.Create(mcfg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCreate, err)
+	}
+	n.list.Store(list)
+
+	if len(cfg.Peers) > 0 {
+		// Peers may start later, they will join us themselves.
+		if joined, err := list.Join(cfg.Peers); err != nil {
+			n.logger.Warn("gossip join failed", slog.Any("peers", cfg.Peers), slog.Any("err", err))
+		} else {
+			n.logger.Info("gossip joined", slog.Int("contacted", joined))
+		}
+	}
+
+	go n.syncLoop()
+	return n, nil
+}
+
+func (n *Node) numMembers() int {
+	list := n.list.Load()
+	if list == nil {
+		return 1
+	}
+	return list.NumMembers()
+}
+
+/*
+Gossip address of this node in 'host:port' format.
+*/
+func (n *Node) Addr() string {
+	return n.list.Load().LocalNode().Address()
+}
+
+func (n *Node) Members() []string {
+	members := n.list.Load().Members()
+	result := make([]string, 0, len(members))
+	for _, m := range members {
+		result = append(result, m.Name)
+	}
+	return result
+}
+
+func (n *Node) Allow(ip string) bool {
+	if !n.limiter.Allow(ip) {
+		return false
+	}
+	n.mu.Lock()
+	n.deltas[ip]++
+	n.mu.Unlock()
+	return true
+}
+
+func (n *Node) SetClient(ip string, capacity *int, rate *time.Duration) error {
+	if err := n.limiter.SetClient(ip, capacity, rate); err != nil {
+		return err
+	}
+	n.publishClient(ip)
+	return nil
+}
+
+func (n *Node) SetShadow(ip string, shadow bool) error {
+	if err := n.limiter.SetShadow(ip, shadow); err != nil {
+		return err
+	}
+	n.publishClient(ip)
+	return nil
+}
+
+func (n *Node) Shadow(ip string) bool {
+	return n.limiter.Shadow(ip)
+}
+
+func (n *Node) GlobalShadow() bool {
+	return n.limiter.GlobalShadow()
+}
+
+func (n *Node) SetGlobalShadow(shadow bool) {
+	n.limiter.SetGlobalShadow(shadow)
+
+	msg := globalMsg{Shadow: shadow, Version: time.Now().UnixNano()}
+	n.mu.Lock()
+	n.global = msg
+	n.mu.Unlock()
+
+	n.queue.QueueBroadcast(&broadcast{key: "global", msg: encode(msgGlobal, msg)})
+}
+
+/*
+Broadcasts effective client values, defaults may differ between instances.
+*/
+func (n *Node) publishClient(ip string) {
+	for _, c := range n.limiter.Clients() {
+		if c.IP != ip {
+			continue
+		}
+		n.publish(clientMsg{IP: ip, Capacity: c.Capacity, RefillEvery: c.RefillEvery, Shadow: c.Shadow})
+	}
+}
+
+func (n *Node) RemoveClient(ip string) error {
+	if err := n.limiter.RemoveClient(ip); err != nil {
+		return err
+	}
+	n.publish(clientMsg{IP: ip, Deleted: true})
+	return nil
+}
+
+func (n *Node) Clients() []ratelimiter.ClientConfig {
+	return n.limiter.Clients()
+}
+
+func (n *Node) publish(msg clientMsg) {
+	msg.Version = time.Now().UnixNano()
+
+	n.mu.Lock()
+	n.clients[msg.IP] = msg
+	n.mu.Unlock()
+
+	n.queue.QueueBroadcast(&broadcast{key: "client:" + msg.IP, msg: encode(msgClient, msg)})
+}
+
+func (n *Node) Close() error {
+	close(n.quit)
+	list := n.list.Load()
+	if err := list.Leave(time.Second); err != nil {
+		n.logger.Warn("gossip leave failed", slog.Any("err", err))
+	}
+	return list.Shutdown()
+}
+
+/*
+Pushes consumption deltas to every peer directly.
+Broadcast queue is not used here: retransmits would count the same tokens twice.
+*/
+func (n *Node) syncLoop() {
+	ticker := time.NewTicker(n.cfg.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n.pushDeltas()
+		case <-n.quit:
+			return
+		}
+	}
+}
+
+func (n *Node) pushDeltas() {
+	n.mu.Lock()
+	if len(n.deltas) == 0 {
+		n.mu.Unlock()
+		return
+	}
+	deltas := n.deltas
+	n.deltas = make(map[string]int)
+	n.mu.Unlock()
+
+	list := n.list.Load()
+	payload := encode(msgDeltas, deltasMsg{Node: list.LocalNode().Name, Deltas: deltas})
+	for _, member := range list.Members() {
+		if member.Name == list.LocalNode().Name {
+			continue
+		}
+		if err := list.SendReliable(member, payload); err != nil {
+			n.logger.Debug("gossip push failed", slog.String("peer", member.Name), slog.Any("err", err))
+		}
+	}
+}
+
+func (n *Node) apply(msg clientMsg) {
+	n.mu.Lock()
+	known, ok := n.clients[msg.IP]
+	if ok && known.Version >= msg.Version {
+		n.mu.Unlock()
+		return
+	}
+	n.clients[msg.IP] = msg
+	n.mu.Unlock()
+
+	var err error
+	if msg.Deleted {
+		err = n.limiter.RemoveClient(msg.IP)
+	} else if err = n.limiter.SetClient(msg.IP, &msg.Capacity, &msg.RefillEvery); err == nil {
+		err = n.limiter.SetShadow(msg.IP, msg.Shadow)
+	}
+	if err != nil {
+		n.logger.Warn("apply peer client config", slog.String("ip", msg.IP), slog.Any("err", err))
+	}
+}
+
+func (n *Node) applyGlobal(msg globalMsg) {
+	n.mu.Lock()
+	if n.global.Version >= msg.Version {
+		n.mu.Unlock()
+		return
+	}
+	n.global = msg
+	n.mu.Unlock()
+
+	n.limiter.SetGlobalShadow(msg.Shadow)
+}
+
+/*
+memberlist.Delegate implementation.
+*/
+
+func (n *Node) NodeMeta(limit int) []byte {
+	return nil
+}
+
+func (n *Node) NotifyMsg(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	switch b[0] {
+	case msgDeltas:
+		var msg deltasMsg
+		if err := json.Unmarshal(b[1:], &msg); err != nil {
+			n.logger.Warn("gossip deltas", slog.Any("err", fmt.Errorf("%w: %w", ErrBadMessage, err)))
+			return
+		}
+		for ip, consumed := range msg.Deltas {
+			n.limiter.Consume(ip, consumed)
+		}
+	case msgClient:
+		var msg clientMsg
+		if err := json.Unmarshal(b[1:], &msg); err != nil {
+			n.logger.Warn("gossip client", slog.Any("err", fmt.Errorf("%w: %w", ErrBadMessage, err)))
+			return
+		}
+		n.apply(msg)
+	case msgGlobal:
+		var msg globalMsg
+		if err := json.Unmarshal(b[1:], &msg); err != nil {
+			n.logger.Warn("gossip global", slog.Any("err", fmt.Errorf("%w: %w", ErrBadMessage, err)))
+			return
+		}
+		n.applyGlobal(msg)
+	default:
+		n.logger.Warn("gossip", slog.Any("err", ErrBadMessage), slog.Int("type", int(b[0])))
+	}
+}
+
+func (n *Node) GetBroadcasts(overhead, limit int) [][]byte {
+	return n.queue.GetBroadcasts(overhead, limit)
+}
+
+/*
+Full configuration (tombstones included) for push/pull sync.
+*/
+func (n *Node) LocalState(join bool) []byte {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	st := state{
+		Clients: make([]clientMsg, 0, len(n.clients)),
+		Global:  n.global,
+	}
+	for _, c := range n.clients {
+		st.Clients = append(st.Clients, c)
+	}
+	b, _ := json.Marshal(st)
+	return b
+}
+
+func (n *Node) MergeRemoteState(buf []byte, join bool) {
+	var st state
+	if err := json.Unmarshal(buf, &st); err != nil {
+		n.logger.Warn("gossip state", slog.Any("err", fmt.Errorf("%w: %w", ErrBadMessage, err)))
+		return
+	}
+	for _, c := range st.Clients {
+		if c.Version == 0 {
+			continue
+		}
+		n.apply(c)
+	}
+	n.applyGlobal(st.Global)
+}
+
+func encode(kind byte, v any) []byte {
+	b, _ := json.Marshal(v)
+	return append([]byte{kind}, b...)
+}
+
+type broadcast struct {
+	key string
+	msg []byte
+}
+
+/*
+Newer change of the same setting replaces queued one.
+*/
+func (b *broadcast) Invalidates(other memberlist.Broadcast) bool {
+	o, ok := other.(*broadcast)
+	return ok && o.key == b.key
+}
+
+func (b *broadcast) Message() []byte {
+	return b.msg
+}
+
+func (b *broadcast) Finished() {}
@@ -0,0 +1,92 @@
+package gossip
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/ratelimiter/config"
+	"github.com/humanbelnik/load-balancer/internal/ratelimiter/ratelimiter"
+)
+
+type memStore struct{}
+
+func (memStore) Add(IP string, capacity int, refillEvery time.Duration) error { return nil }
+func (memStore) Delete(IP string) error                                       { return nil }
+func (memStore) LoadAll() ([]ratelimiter.ClientConfig, error)                 { return nil, nil }
+
+type countingLimiter struct {
+	*ratelimiter.Limiter
+	consumed atomic.Int64
+}
+
+func (l *countingLimiter) Consume(ip string, n int) {
+	l.consumed.Add(int64(n))
+	l.Limiter.Consume(ip, n)
+}
+
+func startNode(t *testing.T, name string, peers ...string) (*Node, *countingLimiter) {
+	t.Helper()
+	limiter := &countingLimiter{Limiter: ratelimiter.New(config.RateLimiterConfig{
+		DefaultCapacity:   1,
+		DefaultRefillRate: time.Hour,
+	}, memStore{})}
+
+	n, err := New(config.GossipConfig{
+		NodeName:     name,
+		BindAddr:     "127.0.0.1",
+		BindPort:     0,
+		Peers:        peers,
+		SyncInterval: 20 * time.Millisecond,
+	}, limiter)
+	require.NoError(t, err)
+	t.Cleanup(func() { n.Close() })
+	return n, limiter
+}
+
+func TestNode_SyncsClientsAndConsumption(t *testing.T) {
+	a, _ := startNode(t, "a")
+	b, limB := startNode(t, "b", a.Addr())
+	c, limC := startNode(t, "c", a.Addr())
+
+	require.Eventually(t, func() bool {
+		return len(a.Members()) == 3 && len(b.Members()) == 3 && len(c.Members()) == 3
+	}, 5*time.Second, 20*time.Millisecond)
+
+	capacity, refill := 3, time.Hour
+	require.NoError(t, a.SetClient("10.0.0.1", &capacity, &refill))
+
+	for _, n := range []*Node{b, c} {
+		require.Eventually(t, func() bool {
+			clients := n.Clients()
+			return len(clients) == 1 && clients[0].Capacity == 3
+		}, 5*time.Second, 20*time.Millisecond, fmt.Sprintf("client not propagated to %s", n.cfg.NodeName))
+	}
+
+	for range capacity {
+		require.True(t, a.Allow("10.0.0.1"))
+	}
+	require.False(t, a.Allow("10.0.0.1"))
+
+	for _, lim := range []*countingLimiter{limB, limC} {
+		require.Eventually(t, func() bool {
+			return lim.consumed.Load() == int64(capacity)
+		}, 5*time.Second, 20*time.Millisecond)
+	}
+	require.False(t, b.Allow("10.0.0.1"))
+	require.False(t, c.Allow("10.0.0.1"))
+
+	require.NoError(t, b.RemoveClient("10.0.0.1"))
+	require.Eventually(t, func() bool {
+		return len(a.Clients()) == 0 && len(c.Clients()) == 0
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestNew_BadSyncInterval(t *testing.T) {
+	limiter := ratelimiter.New(config.RateLimiterConfig{DefaultCapacity: 1, DefaultRefillRate: time.Hour}, memStore{})
+	_, err := New(config.GossipConfig{BindAddr: "127.0.0.1"}, limiter)
+	require.ErrorIs(t, err, ErrCreate)
+}
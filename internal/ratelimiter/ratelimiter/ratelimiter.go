@@ -3,7 +3,9 @@ package ratelimiter
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/humanbelnik/load-balancer/internal/ratelimiter/config"
@@ -12,6 +14,7 @@ import (
 var (
 	ErrAddIP    = errors.New("unable to add ip")
 	ErrRemoveIP = errors.New("unable to remove ip")
+	ErrNoClient = errors.New("no such client")
 )
 
 /*
@@ -23,7 +26,9 @@ type tokenBucket struct {
 	tokens     int
 	refEvery   time.Duration
 	lastRefill time.Time
-	mu         sync.Mutex
+	// Shadow bucket is evaluated, but not enforced.
+	shadow bool
+	mu     sync.Mutex
 }
 
 type Storage interface {
@@ -36,19 +41,42 @@ type ClientConfig struct {
 	IP          string
 	Capacity    int
 	RefillEvery time.Duration
+	Shadow      bool
 }
 
+/*
+Observer is notified about every decision.
+Rule is client IP, or "unknown" for clients without configuration.
+*/
+type Observer interface {
+	RateLimitDecision(rule string, allowed bool)
+}
+
+const UnknownRule = "unknown"
+
 type Limiter struct {
 	cfg config.RateLimiterConfig
+	// Global shadow mode, overrides per-client one.
+	shadow atomic.Bool
 
 	clients map[string]*tokenBucket
 	mu      sync.RWMutex
 	ticker  *time.Ticker
 	quit    chan struct{}
 	store   Storage
+
+	observer Observer
 }
 
-func New(cfg config.RateLimiterConfig, store Storage) *Limiter {
+type Option func(*Limiter)
+
+func WithObserver(o Observer) Option {
+	return func(rl *Limiter) {
+		rl.observer = o
+	}
+}
+
+func New(cfg config.RateLimiterConfig, store Storage, opts ...Option) *Limiter {
 	rl := &Limiter{
 		cfg:     cfg,
 		clients: make(map[string]*tokenBucket),
@@ -56,6 +84,10 @@ func New(cfg config.RateLimiterConfig, store Storage) *Limiter {
 		quit:    make(chan struct{}),
 		store:   store,
 	}
+	rl.shadow.Store(cfg.Shadow)
+	for _, opt := range opts {
+		opt(rl)
+	}
 
 	go rl.refillLoop()
 	return rl
@@ -112,11 +144,20 @@ func (rl *Limiter) SetClient(ip string, capacity *int, rate *time.Duration) erro
 		return fmt.Errorf("%w: %w", ErrAddIP, err)
 	}
 
+	// Reconfiguring limits should not flip enforcement mode.
+	var shadow bool
+	if old, ok := rl.clients[ip]; ok {
+		old.mu.Lock()
+		shadow = old.shadow
+		old.mu.Unlock()
+	}
+
 	rl.clients[ip] = &tokenBucket{
 		capacity:   cap,
 		tokens:     cap,
 		refEvery:   ref,
 		lastRefill: time.Now(),
+		shadow:     shadow,
 	}
 	return nil
 }
@@ -133,7 +174,39 @@ func (rl *Limiter) RemoveClient(ip string) error {
 	return nil
 }
 
-func (rl *Limiter) Allow(ip string) bool {
+/*
+Switches single client between shadow and enforce modes.
+*/
+func (rl *Limiter) SetShadow(ip string, shadow bool) error {
+	rl.mu.RLock()
+	bucket, ok := rl.clients[ip]
+	rl.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoClient, ip)
+	}
+
+	bucket.mu.Lock()
+	bucket.shadow = shadow
+	bucket.mu.Unlock()
+	return nil
+}
+
+func (rl *Limiter) SetGlobalShadow(shadow bool) {
+	rl.shadow.Store(shadow)
+}
+
+func (rl *Limiter) GlobalShadow() bool {
+	return rl.shadow.Load()
+}
+
+/*
+Reports whether rejections for the client should only be logged.
+*/
+func (rl *Limiter) Shadow(ip string) bool {
+	if rl.shadow.Load() {
+		return true
+	}
+
 	rl.mu.RLock()
 	bucket, ok := rl.clients[ip]
 	rl.mu.RUnlock()
@@ -143,10 +216,94 @@ func (rl *Limiter) Allow(ip string) bool {
 
 	bucket.mu.Lock()
 	defer bucket.mu.Unlock()
+	return bucket.shadow
+}
+
+/*
+Takes tokens consumed elsewhere (eg. on a peer instance) out of the bucket.
+Never blocks and never goes below zero.
+*/
+func (rl *Limiter) Consume(ip string, n int) {
+	rl.mu.RLock()
+	bucket, ok := rl.clients[ip]
+	rl.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	bucket.tokens -= n
+	if bucket.tokens < 0 {
+		bucket.tokens = 0
+	}
+}
 
-	if bucket.tokens > 0 {
+/*
+Returns configuration of a single client.
+*/
+func (rl *Limiter) Client(ip string) (ClientConfig, bool) {
+	rl.mu.RLock()
+	bucket, ok := rl.clients[ip]
+	rl.mu.RUnlock()
+	if !ok {
+		return ClientConfig{}, false
+	}
+
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	return ClientConfig{
+		IP:          ip,
+		Capacity:    bucket.capacity,
+		RefillEvery: bucket.refEvery,
+		Shadow:      bucket.shadow,
+	}, true
+}
+
+/*
+Snapshot of configured clients, sorted by IP.
+*/
+func (rl *Limiter) Clients() []ClientConfig {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	result := make([]ClientConfig, 0, len(rl.clients))
+	for ip, bucket := range rl.clients {
+		bucket.mu.Lock()
+		result = append(result, ClientConfig{
+			IP:          ip,
+			Capacity:    bucket.capacity,
+			RefillEvery: bucket.refEvery,
+			Shadow:      bucket.shadow,
+		})
+		bucket.mu.Unlock()
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].IP < result[j].IP })
+	return result
+}
+
+func (rl *Limiter) Allow(ip string) bool {
+	rl.mu.RLock()
+	bucket, ok := rl.clients[ip]
+	rl.mu.RUnlock()
+	if !ok {
+		rl.observe(UnknownRule, false)
+		return false
+	}
+
+	bucket.mu.Lock()
+	allowed := bucket.tokens > 0
+	if allowed {
 		bucket.tokens--
-		return true
 	}
-	return false
+	bucket.mu.Unlock()
+
+	rl.observe(ip, allowed)
+	return allowed
+}
+
+func (rl *Limiter) observe(rule string, allowed bool) {
+	if rl.observer != nil {
+		rl.observer.RateLimitDecision(rule, allowed)
+	}
 }
@@ -0,0 +1,172 @@
+package redis_limiter
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/humanbelnik/load-balancer/internal/ratelimiter/config"
+	"github.com/humanbelnik/load-balancer/internal/ratelimiter/ratelimiter"
+)
+
+/*
+GCRA (generic cell rate algorithm) over a single key.
+Key holds theoretical arrival time (TAT) in microseconds of store clock,
+so every instance shares the same time source.
+Equivalent to a token bucket of ARGV[2] tokens refilled by one every ARGV[1].
+
+KEYS[1] - bucket key
+ARGV[1] - emission interval, microseconds
+ARGV[2] - burst (bucket capacity)
+*/
+const gcraSource = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+
+local tat = tonumber(redis.call('GET', KEYS[1]))
+if not tat or tat < now then
+	tat = now
+end
+
+local new_tat = tat + interval
+if now < new_tat - burst * interval then
+	return 0
+end
+
+redis.call('SET', KEYS[1], new_tat, 'PX', math.ceil((new_tat - now) / 1000))
+return 1
+`
+
+var gcra = redis.NewScript(gcraSource)
+
+/*
+Local limiter owns client configuration and serves as a fallback
+while the shared store is unreachable.
+*/
+type Local interface {
+	Client(ip string) (ratelimiter.ClientConfig, bool)
+	Allow(ip string) bool
+	Shadow(ip string) bool
+}
+
+/*
+Limiter keeps bucket state in a Redis-protocol store,
+so all balancer instances enforce one limit per client.
+*/
+type Limiter struct {
+	client   redis.Scripter
+	local    Local
+	cfg      config.RedisConfig
+	logger   *slog.Logger
+	observer ratelimiter.Observer
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+type Option func(*Limiter)
+
+func WithLogger(logger *slog.Logger) Option {
+	return func(l *Limiter) {
+		l.logger = logger
+	}
+}
+
+func WithObserver(o ratelimiter.Observer) Option {
+	return func(l *Limiter) {
+		l.observer = o
+	}
+}
+
+func New(client redis.Scripter, local Local, cfg config.RedisConfig, opts ...Option) *Limiter {
+	l := &Limiter{
+		client: client,
+		local:  local,
+		cfg:    cfg,
+		logger: slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+/*
+Creates go-redis client from config.
+*/
+func NewClient(cfg config.RedisConfig) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+}
+
+func (l *Limiter) Allow(ip string) bool {
+	client, ok := l.local.Client(ip)
+	if !ok {
+		l.observe(ratelimiter.UnknownRule, false)
+		return false
+	}
+
+	// Local limiter reports its own decisions.
+	if l.isDown() {
+		return l.local.Allow(ip)
+	}
+
+	allowed, err := l.allowShared(client)
+	if err != nil {
+		l.markDown(err)
+		return l.local.Allow(ip)
+	}
+	l.observe(ip, allowed)
+	return allowed
+}
+
+func (l *Limiter) observe(rule string, allowed bool) {
+	if l.observer != nil {
+		l.observer.RateLimitDecision(rule, allowed)
+	}
+}
+
+func (l *Limiter) Shadow(ip string) bool {
+	return l.local.Shadow(ip)
+}
+
+func (l *Limiter) allowShared(client ratelimiter.ClientConfig) (bool, error) {
+	ctx := context.Background()
+	if l.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.cfg.Timeout)
+		defer cancel()
+	}
+
+	res, err := gcra.Run(ctx, l.client,
+		[]string{l.cfg.KeyPrefix + client.IP},
+		client.RefillEvery.Microseconds(),
+		client.Capacity,
+	).Int()
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+func (l *Limiter) isDown() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Now().Before(l.downUntil)
+}
+
+func (l *Limiter) markDown(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.downUntil = time.Now().Add(l.cfg.RetryAfter)
+	l.logger.Warn("rate limiter store unreachable, using local buckets",
+		slog.Any("err", err), slog.Duration("retry_after", l.cfg.RetryAfter))
+}
@@ -0,0 +1,83 @@
+package redis_limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/ratelimiter/config"
+	"github.com/humanbelnik/load-balancer/internal/ratelimiter/ratelimiter"
+)
+
+type fakeLocal struct {
+	clients map[string]ratelimiter.ClientConfig
+	calls   int
+}
+
+func (f *fakeLocal) Client(ip string) (ratelimiter.ClientConfig, bool) {
+	c, ok := f.clients[ip]
+	return c, ok
+}
+
+func (f *fakeLocal) Allow(ip string) bool {
+	f.calls++
+	return true
+}
+
+func (f *fakeLocal) Shadow(ip string) bool {
+	return false
+}
+
+func setup(t *testing.T) (*miniredis.Miniredis, *fakeLocal, config.RedisConfig) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Unix(1_700_000_000, 0))
+
+	local := &fakeLocal{clients: map[string]ratelimiter.ClientConfig{
+		"10.0.0.1": {IP: "10.0.0.1", Capacity: 2, RefillEvery: time.Second},
+	}}
+	cfg := config.RedisConfig{
+		KeyPrefix:  "test:",
+		Timeout:    time.Second,
+		RetryAfter: time.Minute,
+	}
+	return mr, local, cfg
+}
+
+func TestLimiter_SharedBetweenInstances(t *testing.T) {
+	mr, local, cfg := setup(t)
+
+	a := New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), local, cfg)
+	b := New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), local, cfg)
+
+	require.True(t, a.Allow("10.0.0.1"))
+	require.True(t, b.Allow("10.0.0.1"))
+	require.False(t, a.Allow("10.0.0.1"))
+	require.False(t, b.Allow("10.0.0.1"))
+
+	mr.SetTime(time.Unix(1_700_000_001, 0))
+	require.True(t, b.Allow("10.0.0.1"))
+	require.False(t, a.Allow("10.0.0.1"))
+
+	require.Zero(t, local.calls)
+}
+
+func TestLimiter_UnknownClient(t *testing.T) {
+	mr, local, cfg := setup(t)
+	l := New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), local, cfg)
+
+	require.False(t, l.Allow("10.0.0.2"))
+}
+
+func TestLimiter_FallbackToLocal(t *testing.T) {
+	mr, local, cfg := setup(t)
+	l := New(redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1}), local, cfg)
+	mr.Close()
+
+	require.True(t, l.Allow("10.0.0.1"))
+	require.True(t, l.Allow("10.0.0.1"))
+	require.Equal(t, 2, local.calls)
+}
@@ -0,0 +1,8 @@
+package config
+
+type RequestIDConfig struct {
+	// Reuse X-Request-ID sent by client instead of generating a new one.
+	TrustIncoming bool `yaml:"trust_incoming"`
+	// Trust only clients from these networks. Empty means any client.
+	TrustedCIDRs []string `yaml:"trusted_cidrs"`
+}
@@ -0,0 +1,156 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/humanbelnik/load-balancer/internal/requestid/config"
+)
+
+const Header = "X-Request-ID"
+
+// Longer incoming IDs are replaced.
+const maxLen = 128
+
+var (
+	ErrBadCIDR = errors.New("invalid trusted network")
+)
+
+type ctxKey struct{}
+
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+/*
+Empty string if request has no ID.
+*/
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+/*
+Resolver picks request ID: trusted incoming one or a freshly generated.
+*/
+type Resolver struct {
+	trustIncoming bool
+	trusted       []*net.IPNet
+}
+
+/*
+Never trusts incoming IDs.
+*/
+func Default() *Resolver {
+	return &Resolver{}
+}
+
+func New(cfg config.RequestIDConfig) (*Resolver, error) {
+	r := &Resolver{trustIncoming: cfg.TrustIncoming}
+	for _, raw := range cfg.TrustedCIDRs {
+		_, network, err := net.ParseCIDR(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrBadCIDR, err)
+		}
+		r.trusted = append(r.trusted, network)
+	}
+	return r, nil
+}
+
+func (res *Resolver) Resolve(r *http.Request) string {
+	if incoming := r.Header.Get(Header); res.trusts(r) && valid(incoming) {
+		return incoming
+	}
+	return Generate()
+}
+
+func (res *Resolver) trusts(r *http.Request) bool {
+	if !res.trustIncoming {
+		return false
+	}
+	if len(res.trusted) == 0 {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range res.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Incoming ID ends up in logs and headers, so only safe characters are allowed.
+*/
+func valid(id string) bool {
+	if id == "" || len(id) > maxLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func Generate() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+/*
+Handler adds request_id attribute to records logged with request context.
+*/
+type Handler struct {
+	slog.Handler
+}
+
+func NewHandler(inner slog.Handler) *Handler {
+	return &Handler{Handler: inner}
+}
+
+func (h *Handler) Handle(ctx context.Context, rec slog.Record) error {
+	if id := FromContext(ctx); id != "" {
+		rec.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, rec)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{Handler: h.Handler.WithGroup(name)}
+}
+
+/*
+Same as http.Error, but mentions request ID, so a failed response
+can be matched with balancer and backend logs.
+*/
+func Error(w http.ResponseWriter, r *http.Request, msg string, code int) {
+	if id := FromContext(r.Context()); id != "" {
+		msg += "\nrequest id: " + id
+	}
+	http.Error(w, msg, code)
+}
@@ -0,0 +1,75 @@
+package requestid
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/humanbelnik/load-balancer/internal/requestid/config"
+)
+
+func request(remote, id string) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = remote
+	if id != "" {
+		r.Header.Set(Header, id)
+	}
+	return r
+}
+
+func TestResolver_Generate(t *testing.T) {
+	res := Default()
+
+	id := res.Resolve(request("10.0.0.1:5000", ""))
+	require.Len(t, id, 32)
+	require.NotEqual(t, id, res.Resolve(request("10.0.0.1:5000", "")))
+
+	// Incoming ID is not trusted by default.
+	require.NotEqual(t, "abc-1", res.Resolve(request("10.0.0.1:5000", "abc-1")))
+}
+
+func TestResolver_TrustIncoming(t *testing.T) {
+	res, err := New(config.RequestIDConfig{TrustIncoming: true, TrustedCIDRs: []string{"10.0.0.0/8"}})
+	require.NoError(t, err)
+
+	require.Equal(t, "abc-1", res.Resolve(request("10.0.0.1:5000", "abc-1")))
+	require.NotEqual(t, "abc-1", res.Resolve(request("192.168.0.1:5000", "abc-1")))
+
+	// Unsafe or too long IDs are replaced even from trusted clients.
+	require.NotEqual(t, "abc 1", res.Resolve(request("10.0.0.1:5000", "abc 1")))
+	long := strings.Repeat("a", maxLen+1)
+	require.NotEqual(t, long, res.Resolve(request("10.0.0.1:5000", long)))
+
+	// Missing one is generated.
+	require.Len(t, res.Resolve(request("10.0.0.1:5000", "")), 32)
+
+	_, err = New(config.RequestIDConfig{TrustIncoming: true, TrustedCIDRs: []string{"10.0.0.0/33"}})
+	require.ErrorIs(t, err, ErrBadCIDR)
+}
+
+func TestHandler(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := slog.New(NewHandler(slog.NewTextHandler(out, nil)))
+
+	logger.InfoContext(NewContext(context.Background(), "abc-1"), "served")
+	require.Contains(t, out.String(), "request_id=abc-1")
+
+	out.Reset()
+	logger.InfoContext(context.Background(), "served")
+	require.NotContains(t, out.String(), "request_id")
+}
+
+func TestError(t *testing.T) {
+	r := request("10.0.0.1:5000", "")
+	w := httptest.NewRecorder()
+	Error(w, r.WithContext(NewContext(r.Context(), "abc-1")), "all backends failed", http.StatusBadGateway)
+
+	require.Equal(t, http.StatusBadGateway, w.Code)
+	require.Equal(t, "all backends failed\nrequest id: abc-1\n", w.Body.String())
+}
@@ -0,0 +1,21 @@
+package config
+
+const (
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+type TracingConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	ServiceName string `yaml:"service_name" env-default:"load-balancer"`
+	// 'otlp', 'stdout' or 'file'.
+	Exporter string `yaml:"exporter" env-default:"otlp"`
+	// OTLP/HTTP collector, eg. 'localhost:4318'.
+	Endpoint string `yaml:"endpoint" env-default:"localhost:4318"`
+	Insecure bool   `yaml:"insecure"`
+	// Used by 'file' exporter.
+	File string `yaml:"file" env-default:"traces.json"`
+	// Fraction of new traces to sample, parent decision is respected.
+	SampleRatio float64 `yaml:"sample_ratio" env-default:"1"`
+}